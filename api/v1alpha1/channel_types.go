@@ -32,12 +32,78 @@ type ChannelSpec struct {
 	Trigger string `json:"trigger"`
 	// +optional
 	Container *corev1.Container `json:"container"`
+	// +optional
+	State *ChannelState `json:"state,omitempty"`
+	// +optional
+	Dedup *ChannelDedup `json:"dedup,omitempty"`
+	// Routes are ordered clauses used to send events to different targets
+	// based on their content. When routes are specified, stream.to is optional.
+	// +optional
+	Routes []ChannelRoute `json:"routes,omitempty"`
+	// RouteMatch specifies whether events are sent to the first matching route
+	// or to all matching routes, defaults to first
+	// +optional
+	// +kubebuilder:validation:Enum=first;all
+	RouteMatch string `json:"routeMatch,omitempty"`
+	// Autoscaling scales the channel with the load of its input stream
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+}
+
+// ChannelRoute sends the events, matching a predicate, to a list of targets.
+// A route without predicate is a default route, which receives the events
+// that do not match any other route.
+type ChannelRoute struct {
+	// When is a boolean expression used to match events
+	// +optional
+	When string `json:"when,omitempty"`
+	// Select is an expression used to generate the route output, defaults to stream.select
+	// +optional
+	Select string `json:"select,omitempty"`
+	// To are the targets where matching events are sent
+	To []OutputTarget `json:"to"`
+}
+
+// ChannelDedup configures the deduplication of stream events. Ids of
+// seen events are remembered, for a TTL, in a bounded in-memory set which can
+// be backed by a Dapr state store.
+type ChannelDedup struct {
+	// ID is an expression that computes the id of an event, defaults to the CloudEvent id
+	// +optional
+	ID string `json:"id,omitempty"`
+	// TTL is how long ids are remembered (i.e. 30s, 10m), defaults to 10m
+	// +optional
+	TTL string `json:"ttl,omitempty"`
+	// Size is the maximum number of ids remembered in memory, defaults to 10000
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Size int `json:"size,omitempty"`
+	// Store is the name of a Dapr state store component used to share seen ids
+	// across replicas and restarts
+	// +optional
+	Store string `json:"store,omitempty"`
+}
+
+// ChannelState configures per-key stateful processing of stream events.
+// The state of each key is stored in a Dapr state store and is exposed to
+// expressions as variable `state`.
+type ChannelState struct {
+	// Store is the name of the Dapr state store component
+	Store string `json:"store"`
+	// Key is an expression that computes the key (string or int) of an event
+	Key string `json:"key"`
+	// Initial is an expression that computes the state of a new key, defaults to {}
+	// +optional
+	Initial string `json:"initial,omitempty"`
+	// Update is an expression that computes the new state (a map) of the key of an event
+	Update string `json:"update"`
 }
 
 // ChannelStatus defines the observed state of Channel
 type ChannelStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions include ReferencesResolved, false when referenced objects are not found
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,11 +27,109 @@ var (
 	KafkaProtocolName = "kafka"
 )
 
+// Authentication modes of a ClusterStream, the properties they require depend on the protocol
+const (
+	AuthTypeNone = "none"
+	// AuthTypeSASL is a username and password authentication (SASL/PLAIN for kafka)
+	AuthTypeSASL        = "sasl"
+	AuthTypeSCRAMSHA256 = "scram-sha-256"
+	AuthTypeSCRAMSHA512 = "scram-sha-512"
+	AuthTypeMTLS        = "mtls"
+	AuthTypeOAuth       = "oauth"
+)
+
+const (
+	// ClusterStreamConditionValid reports whether the protocol, authentication and
+	// properties of a ClusterStream are valid
+	ClusterStreamConditionValid = "Valid"
+	// ClusterStreamConditionReachable reports the result of the last connectivity probe
+	ClusterStreamConditionReachable = "Reachable"
+	// ClusterStreamConditionDeletionBlocked reports, while a ClusterStream is deleted,
+	// the Streams still referencing it
+	ClusterStreamConditionDeletionBlocked = "DeletionBlocked"
+)
+
+// Deletion policies of a ClusterStream
+const (
+	// ClusterStreamDeletionBlock blocks the deletion until no Stream references the
+	// ClusterStream
+	ClusterStreamDeletionBlock = "Block"
+	// ClusterStreamDeletionCascade deletes the Streams referencing the ClusterStream,
+	// the ClusterStream is deleted once they are gone
+	ClusterStreamDeletionCascade = "Cascade"
+)
+
+// SecretKeyReference selects a key of a Secret
+type SecretKeyReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+}
+
+// ClusterStreamTLS configures the encryption of the connections to the brokers
+type ClusterStreamTLS struct {
+	// CASecretRef selects the CA bundle verifying the certificates of the brokers,
+	// defaults to the system roots
+	// +optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+	// ClientCertSecretRef is a kubernetes.io/tls Secret whose certificate (tls.crt) and
+	// key (tls.key) authenticate the client
+	// +optional
+	ClientCertSecretRef *corev1.SecretReference `json:"clientCertSecretRef,omitempty"`
+	// InsecureSkipVerify disables the verification of the certificates of the brokers
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// ClusterStreamProbe configures the connectivity probe of a ClusterStream: the
+// controller periodically connects to the brokers and fetches their metadata
+type ClusterStreamProbe struct {
+	// PeriodSeconds is the interval between probes, defaults to 30
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds is the timeout of a probe, defaults to 5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
 // ClusterStreamSpec defines the desired state of ClusterStream
 type ClusterStreamSpec struct {
 	Protocol   string            `json:"protocol"`
 	Properties map[string]string `json:"properties"`
-	AuthType   string            `json:"authType,omitempty"`
+	// SecretProperties are properties whose values are read from Secrets. They are
+	// rendered as secretKeyRef metadata of the pubsub component.
+	// +optional
+	SecretProperties map[string]SecretKeyReference `json:"secretProperties,omitempty"`
+	// AuthType is the authentication mode of the protocol, it adds the metadata of the
+	// mode to the pubsub component and validates that its properties are set
+	// +kubebuilder:validation:Enum=none;sasl;scram-sha-256;scram-sha-512;mtls;oauth
+	// +optional
+	AuthType string `json:"authType,omitempty"`
+	// CredentialsSecretRef is a Secret whose keys are added to the metadata of the
+	// pubsub component. The Secret and the secret properties are copied to the namespaces
+	// using the stream and the component references the copy, credentials are not stored
+	// in the component.
+	// +optional
+	CredentialsSecretRef *corev1.SecretReference `json:"credentialsSecretRef,omitempty"`
+	// TLS encrypts the connections to the brokers, it is translated into the metadata
+	// of the protocol
+	// +optional
+	TLS *ClusterStreamTLS `json:"tls,omitempty"`
+	// Probe enables the connectivity probe of the stream
+	// +optional
+	Probe *ClusterStreamProbe `json:"probe,omitempty"`
+	// AllowedNamespaces selects the namespaces where the stream can be used, defaults
+	// to all namespaces
+	// +optional
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+	// DeletionPolicy is either Block (the deletion waits until no Stream references
+	// the stream) or Cascade (the Streams referencing the stream are deleted), defaults
+	// to Block
+	// +kubebuilder:validation:Enum=Block;Cascade
+	// +optional
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
 }
 
 // ClusterStreamStatus defines the observed state of ClusterStream
@@ -38,10 +137,31 @@ type ClusterStreamStatus struct {
 	Provider string `json:"provider"`
 	Servers  string `json:"servers"`
 	Status   string `json:"status"`
+	// TLS reports whether the connections to the brokers use TLS
+	// +optional
+	TLS bool `json:"tls,omitempty"`
+	// Conditions are the Valid and Reachable conditions of the stream, and
+	// DeletionBlocked while it is deleted
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastProbeTime is the time of the last connectivity probe
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// LastProbeError is the error of the last connectivity probe, empty if it succeeded
+	// +optional
+	LastProbeError string `json:"lastProbeError,omitempty"`
+	// Namespaces where the pubsub component of the stream is projected
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Protocol",type=string,JSONPath=`.spec.protocol`
+//+kubebuilder:printcolumn:name="TLS",type=boolean,JSONPath=`.status.tls`
+//+kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+//+kubebuilder:printcolumn:name="Reachable",type=string,JSONPath=`.status.conditions[?(@.type=="Reachable")].status`
 
 // ClusterStream is the Schema for the clusterstreams API
 type ClusterStream struct {
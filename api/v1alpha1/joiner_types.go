@@ -28,10 +28,48 @@ type JoinerSpec struct {
 	Stream      *StreamSetup `json:"stream"`
 	// +optional
 	Container *corev1.Container `json:"container"`
+	// +optional
+	Emit *JoinerEmit `json:"emit,omitempty"`
+	// Partitioning scales the joiner horizontally: events are partitioned by their
+	// join key and each replica joins the events of its partition
+	// +optional
+	Partitioning *JoinerPartitioning `json:"partitioning,omitempty"`
+}
+
+// JoinerPartitioning splits the events joined by a joiner across its replicas
+type JoinerPartitioning struct {
+	// Replicas is the number of replicas, each owning one partition of the join keys
+	// +kubebuilder:validation:Minimum=1
+	Replicas int32 `json:"replicas"`
+	// Keys are the expressions computing the join key of the events of each
+	// stream in stream.from (in the same order). Events joined together must have
+	// the same key.
+	// +kubebuilder:validation:MinItems=2
+	// +kubebuilder:validation:MaxItems=2
+	Keys []string `json:"keys"`
+}
+
+// JoinerEmit specifies how the results of a window are emitted
+type JoinerEmit struct {
+	// Mode is either batch (a list of the joined results of a window is emitted
+	// as one event) or each (an event is emitted for each joined result),
+	// defaults to batch
+	// +optional
+	// +kubebuilder:validation:Enum=batch;each
+	Mode string `json:"mode,omitempty"`
+	// MaxBatchSize splits, in batch mode, the joined results of a window into
+	// lists of at most MaxBatchSize results, defaults to no limit
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxBatchSize int `json:"maxBatchSize,omitempty"`
 }
 
 // JoinerStatus defines the observed state of Joiner
-type JoinerStatus struct{}
+type JoinerStatus struct {
+	// Conditions include ReferencesResolved, false when referenced objects are not found
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PipelineConditionValid reports whether the pipeline graph is valid
+	PipelineConditionValid = "Valid"
+	// PipelineConditionReady reports whether all the pipeline nodes are ready
+	PipelineConditionReady = "Ready"
+)
+
+// PipelineSpec defines the desired state of Pipeline. The nodes of the pipeline
+// are created as child resources named after the nodes. The edges of the graph
+// are the references between the nodes: the recipients of a Stream, the streams
+// a component consumes (stream.from or from) and the pubsub/topic streams it sends
+// to (stream.to, routes or target). ClusterStreams are cluster-scoped, they are
+// referenced by the pipeline streams but are not nodes of the pipeline.
+type PipelineSpec struct {
+	// +optional
+	Streams []PipelineStream `json:"streams,omitempty"`
+	// +optional
+	Channels []PipelineChannel `json:"channels,omitempty"`
+	// +optional
+	Joiners []PipelineJoiner `json:"joiners,omitempty"`
+	// +optional
+	Processors []PipelineProcessor `json:"processors,omitempty"`
+}
+
+// PipelineStream is a Stream node of a pipeline
+type PipelineStream struct {
+	Name string     `json:"name"`
+	Spec StreamSpec `json:"spec"`
+}
+
+// PipelineChannel is a Channel node of a pipeline
+type PipelineChannel struct {
+	Name string      `json:"name"`
+	Spec ChannelSpec `json:"spec"`
+}
+
+// PipelineJoiner is a Joiner node of a pipeline
+type PipelineJoiner struct {
+	Name string     `json:"name"`
+	Spec JoinerSpec `json:"spec"`
+}
+
+// PipelineProcessor is a Processor node of a pipeline
+type PipelineProcessor struct {
+	Name string        `json:"name"`
+	Spec ProcessorSpec `json:"spec"`
+}
+
+// PipelineNodeStatus is the observed state of a pipeline node
+type PipelineNodeStatus struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	// Message explains why the node is not ready
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// PipelineStatus defines the observed state of Pipeline
+type PipelineStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Valid and Ready conditions of the pipeline
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Nodes is the status of each node of the pipeline
+	// +optional
+	Nodes []PipelineNodeStatus `json:"nodes,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// Pipeline is the Schema for the pipelines API
+type Pipeline struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PipelineSpec   `json:"spec,omitempty"`
+	Status PipelineStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PipelineList contains a list of Pipeline
+type PipelineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Pipeline `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Pipeline{}, &PipelineList{})
+}
@@ -28,12 +28,23 @@ type ProcessorSpec struct {
 	Container   corev1.Container `json:"container"`
 	// +optional
 	ServiceRoute string `json:"serviceRoute"`
+	// From are the names of the Streams delivered to the processor service route
+	// +optional
+	From []string `json:"from,omitempty"`
+	// Target where the processor results are forwarded, specified as
+	// stream:pubsub/topic or component:component/route (a target without prefix is a component)
 	// +optional
 	Target string `json:"target"`
+	// Autoscaling scales the processor with the load of its From streams, replacing Replicas
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
 }
 
 // ProcessorStatus defines the observed state of Processor
 type ProcessorStatus struct {
+	// Conditions include ReferencesResolved, false when referenced objects are not found
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -20,6 +20,21 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// StreamConditionTopicProvisioned reports whether the topic of a stream is created
+	// with the partitions, replication factor, retention and compaction of the stream
+	StreamConditionTopicProvisioned = "TopicProvisioned"
+)
+
+// Reclaim policies of the topic of a Stream
+const (
+	// TopicReclaimRetain keeps the topic when the Stream is deleted
+	TopicReclaimRetain = "Retain"
+	// TopicReclaimDelete deletes the topic when the Stream is deleted, if the topic was
+	// created by the controller
+	TopicReclaimDelete = "Delete"
+)
+
 // StreamSpec defines the desired state of Stream
 type StreamSpec struct {
 	ClusterStream string `json:"clusterStream"`
@@ -31,12 +46,71 @@ type StreamSpec struct {
 	Route string `json:"route,omitempty"`
 	// +optional
 	Properties map[string]string `json:"properties,omitempty"`
+	// +optional
+	Codec *StreamCodec `json:"codec,omitempty"` // encoding of stream data, defaults to JSON
+	// +optional
+	Schema *StreamSchema `json:"schema,omitempty"` // schema used to validate stream events
+
+	// The topic is provisioned, created or updated, when one of the following fields is
+	// set. The fields not set use the defaults of the brokers.
+
+	// Partitions of the topic, they can only be increased
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Partitions *int32 `json:"partitions,omitempty"`
+	// ReplicationFactor of the topic, it can not be changed once the topic is created
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ReplicationFactor *int32 `json:"replicationFactor,omitempty"`
+	// Retention of the events of the topic
+	// +optional
+	Retention *metav1.Duration `json:"retention,omitempty"`
+	// Compaction keeps the last event of each key, instead of deleting events after the
+	// retention
+	// +optional
+	Compaction *bool `json:"compaction,omitempty"`
+	// TopicReclaimPolicy is either Retain (the topic is kept when the stream is
+	// deleted) or Delete (the topic, if created by the controller, is deleted with the
+	// stream), defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	TopicReclaimPolicy string `json:"topicReclaimPolicy,omitempty"`
+}
+
+// TopicStatus is the actual configuration of a provisioned topic
+type TopicStatus struct {
+	Partitions        int32 `json:"partitions"`
+	ReplicationFactor int32 `json:"replicationFactor"`
+	// Retention of the events, not set when unlimited
+	// +optional
+	Retention  *metav1.Duration `json:"retention,omitempty"`
+	Compaction bool             `json:"compaction"`
+	// Created is true when the topic was created by the controller, rather than
+	// provisioned from an existing topic. Only created topics are deleted by the
+	// Delete reclaim policy.
+	// +optional
+	Created bool `json:"created,omitempty"`
+}
+
+// StreamSchema declares the schema of stream events
+type StreamSchema struct {
+	// Type of schema, jsonschema (default) or avro
+	// +optional
+	Type         string `json:"type,omitempty"`
+	SchemaSource `json:",inline"`
+	// +optional
+	Violations *OutputTarget `json:"violations,omitempty"` // where invalid events are sent, if not set they are dropped
 }
 
 // StreamStatus defines the observed state of Stream
 type StreamStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions include ReferencesResolved, false when referenced objects are not found,
+	// and TopicProvisioned
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Topic is the actual configuration of the topic, when it is provisioned
+	// +optional
+	Topic *TopicStatus `json:"topic,omitempty"`
 }
 
 //+kubebuilder:object:root=true
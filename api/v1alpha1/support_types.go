@@ -1,5 +1,15 @@
 package v1alpha1
 
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ConditionReferencesResolved reports whether the objects referenced by a resource
+	// (i.e. the Streams consumed by a component) are found
+	ConditionReferencesResolved = "ReferencesResolved"
+)
+
 // StreamSetup defines stream data selection, composition, filter and output
 type StreamSetup struct {
 	From []string       `json:"from"`
@@ -8,6 +18,15 @@ type StreamSetup struct {
 	Select string `json:"select"`
 	// +optional
 	Where string `json:"where"`
+	// LibVersion pins the version of the function library available
+	// to expressions, defaults to the latest version
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	LibVersion int `json:"libVersion,omitempty"`
+	// IndexField, when select returns a list (where each element is emitted
+	// as an event), is the name of the field set with the element index
+	// +optional
+	IndexField string `json:"indexField,omitempty"`
 }
 
 type OutputTarget struct {
@@ -15,4 +34,52 @@ type OutputTarget struct {
 	Stream string `json:"stream"`
 	// +optional
 	Component string `json:"component"`
+	// +optional
+	Codec *StreamCodec `json:"codec,omitempty"` // encoding of output data, defaults to JSON
+}
+
+// StreamCodec specifies how stream payloads are encoded
+type StreamCodec struct {
+	// ContentType of the payload, i.e. application/json, application/avro,
+	// application/x-protobuf, application/msgpack, or text/csv
+	ContentType string `json:"contentType"`
+	// +optional
+	Schema *SchemaSource `json:"schema,omitempty"`
+	// +optional
+	MessageType string `json:"messageType,omitempty"` // fully-qualified protobuf message name
+}
+
+// SchemaSource specifies where a schema is loaded from
+type SchemaSource struct {
+	// +optional
+	Inline string `json:"inline,omitempty"`
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// +optional
+	File string `json:"file,omitempty"` // path of schema file in the component container
+}
+
+// Autoscaling scales the replicas of a component with the load of its input streams
+type Autoscaling struct {
+	// MinReplicas is the lower limit of replicas, defaults to 1
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the upper limit of replicas
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// Scaler is the autoscaler rendered by the controller: keda (a KEDA ScaledObject
+	// triggered by the stream lag) or hpa (a HorizontalPodAutoscaler on the events
+	// received per second), defaults to keda
+	// +optional
+	// +kubebuilder:validation:Enum=keda;hpa
+	Scaler string `json:"scaler,omitempty"`
+	// TargetLag is the number of pending stream events per replica (keda)
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TargetLag int64 `json:"targetLag,omitempty"`
+	// TargetEventsPerSecond is the number of events received per second per replica (hpa)
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TargetEventsPerSecond int64 `json:"targetEventsPerSecond,omitempty"`
 }
@@ -2,273 +2,485 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"reflect"
+	"strconv"
+	"sync"
+	"time"
 
-	dapr "github.com/dapr/go-sdk/client"
-	"github.com/dapr/go-sdk/service/common"
-	daprd "github.com/dapr/go-sdk/service/http"
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/vladimirvivien/streaming-runtime/components/support"
-	"google.golang.org/protobuf/types/known/structpb"
 )
 
-var (
-	servicePort          = os.Getenv("CHANNEL_SERVICE_PORT")        // service port
-	modeEnv              = os.Getenv("CHANNEL_MODE")                // channel mode, valid values = {stream | aggregate}
-	streamFromEnv        = os.Getenv("CHANNEL_STREAM_FROM")         // name of topic or service route to stream from
-	streamToStreamEnv    = os.Getenv("CHANNEL_STREAM_TO_STREAM")    // "pubsub/topic" path where to route result
-	streamToComponentEnv = os.Getenv("CHANNEL_STREAM_TO_COMPONENT") // "component/route" path where to send result
-	streamFilterExprEnv  = os.Getenv("CHANNEL_STREAM_WHERE")        // expression used to filter data from stream
-	streamSelectExprEnv  = os.Getenv("CHANNEL_STREAM_SELECT")       // expression used to generate data output from streams
+// stateUpdateAttempts is the number of times the state of a key is reloaded
+// and updated when the key is modified concurrently by another replica
+const stateUpdateAttempts = 5
 
-	triggerExprEnv = os.Getenv("CHANNEL_AGGREGATE_TRIGGER") // expression to trigger aggregation
-	counter        = 0
+// config is the channel configuration, loaded from env variables
+type config struct {
+	ServicePort string `env:"CHANNEL_SERVICE_PORT" default:":8080"` // service port
+	Mode        string `env:"CHANNEL_MODE" default:"stream"`        // channel mode, valid values = {stream | aggregate}
+	StreamFrom  string `env:"CHANNEL_STREAM_FROM"`                  // name of topic or service route to stream from
+	ToStream    string `env:"CHANNEL_STREAM_TO_STREAM"`             // "pubsub/topic" path where to route result
+	ToComponent string `env:"CHANNEL_STREAM_TO_COMPONENT"`          // "component/route" path where to send result
+	Where       string `env:"CHANNEL_STREAM_WHERE"`                 // expression used to filter data from stream
+	Select      string `env:"CHANNEL_STREAM_SELECT"`                // expression used to generate data output from streams
+	LibVersion  int    `env:"CHANNEL_STREAM_LIB_VERSION"`           // version of the expression function library (default latest)
+	IndexField  string `env:"CHANNEL_STREAM_INDEX_FIELD"`           // field set with the element index, when select returns a list
+	Routes      int    `env:"CHANNEL_ROUTES"`                       // number of routes
+	RouteMatch  string `env:"CHANNEL_ROUTE_MATCH" default:"first"`  // route matching, valid values = {first | all}
+	Trigger     string `env:"CHANNEL_AGGREGATE_TRIGGER"`            // expression releasing the aggregated events, in aggregate mode
+	State       stateConfig
+	Dedup       dedupConfig
+}
 
-	inputChan  chan *common.InvocationEvent
-	outputChan chan []byte
+// stateConfig configures keyed state
+type stateConfig struct {
+	Store   string `env:"CHANNEL_STATE_STORE"`   // name of the state store for keyed state
+	Key     string `env:"CHANNEL_STATE_KEY"`     // expression used to compute the state key of events
+	Initial string `env:"CHANNEL_STATE_INITIAL"` // expression used to compute the state of new keys
+	Update  string `env:"CHANNEL_STATE_UPDATE"`  // expression used to compute the new state of keys
+}
 
-	filterProg  cel.Program
-	dataProg    cel.Program
-	triggerProg cel.Program
-)
+// dedupConfig configures event deduplication
+type dedupConfig struct {
+	Enabled bool          `env:"CHANNEL_DEDUP_ENABLED"` // if true, duplicate events are dropped
+	ID      string        `env:"CHANNEL_DEDUP_ID"`      // expression used to compute event ids (default CloudEvent id)
+	TTL     time.Duration `env:"CHANNEL_DEDUP_TTL"`     // how long event ids are remembered (default 10m)
+	Size    int           `env:"CHANNEL_DEDUP_SIZE"`    // max number of event ids remembered in memory
+	Store   string        `env:"CHANNEL_DEDUP_STORE"`   // state store used to share event ids across replicas
+}
 
-func main() {
-	if servicePort == "" {
-		servicePort = ":8080"
+// Validate validates the channel configuration
+func (c *config) Validate() error {
+	if c.ToStream == "" && c.ToComponent == "" && c.Routes == 0 {
+		return fmt.Errorf("env CHANNEL_STREAM_TO_STREAM and/or CHANNEL_STREAM_TO_COMPONENT must be provided")
+	}
+	if err := support.OneOf("CHANNEL_MODE", c.Mode, "stream", "aggregate"); err != nil {
+		return err
 	}
-	if streamFromEnv == "" {
-		streamFromEnv = support.SanitizeIdentifier(os.Getenv("APP_ID"))
+	if err := support.OneOf("CHANNEL_ROUTE_MATCH", c.RouteMatch, "first", "all"); err != nil {
+		return err
 	}
-	if modeEnv == "" {
-		modeEnv = "stream"
+	if c.Mode == "aggregate" && c.Trigger == "" {
+		return fmt.Errorf("env CHANNEL_AGGREGATE_TRIGGER must be provided in aggregate mode")
 	}
-	if streamToStreamEnv == "" && streamToComponentEnv == "" {
-		log.Fatalf("channel: env CHANNEL_STREAM_TO_STREAM and/or CHANNEL_STREAM_TO_COMPONENT must be provided")
+	if c.State.Store != "" && (c.State.Key == "" || c.State.Update == "") {
+		return fmt.Errorf("state: env CHANNEL_STATE_KEY and CHANNEL_STATE_UPDATE must be provided")
 	}
+	return nil
+}
 
-	log.Printf("channel: service-port: %s [stream-source=%s], filterExpr: (%s), dataExpr: (%s), mode: %s ==> target: stream(%s) component(%s)",
-		servicePort, streamFromEnv, streamFilterExprEnv, streamSelectExprEnv, modeEnv, streamToStreamEnv, streamToComponentEnv)
+// route sends events, matching its predicate, to its targets
+type route struct {
+	whenProg   cel.Program // if nil, the route is a default route
+	selectProg cel.Program // if nil, stream select expression is used
+	targets    []*support.Target
+}
+
+// aggregation buffers the events of an aggregate channel until its trigger expression is true
+type aggregation struct {
+	sync.Mutex
+	events []*support.Event // buffered output events
+	count  int              // number of stream events buffered
+	start  time.Time        // arrival of the first stream event buffered
+}
+
+// channel processes the events of a stream
+type channel struct {
+	cfg config
+
+	streamVar   support.StreamVar // CEL variable of the source stream, typed if the stream has a schema
+	whereProg   cel.Program
+	selectProg  cel.Program
+	triggerProg cel.Program  // releases the aggregated events, if nil, events are not aggregated
+	aggregation *aggregation // events buffered until the trigger expression is true
 
-	// setup internal channels for data processing
-	inputChan = make(chan *common.InvocationEvent, 1024)
-	outputChan = make(chan []byte, 1024)
+	keyedState *support.KeyedState // per-key state, if nil, events are processed statelessly
 
-	ctx := context.Background()
+	dedup       *support.Deduplicator // drops duplicate events, if nil, events are not deduplicated
+	dedupIDProg cel.Program           // computes event ids, if nil, the CloudEvent id is used
 
-	// setup client
-	client, err := dapr.NewClient()
+	streamTarget *support.Target // target of the stream, if nil, events are only sent to routes
+	routes       []*route        // content-based routes, if empty, events are sent to the stream target
+}
+
+func main() {
+	var cfg config
+	if err := support.LoadConfig(&cfg); err != nil {
+		log.Fatalf("channel: %s", err)
+	}
+	if cfg.StreamFrom == "" {
+		cfg.StreamFrom = support.SanitizeIdentifier(os.Getenv("APP_ID"))
+	}
+
+	log.Printf("channel: service-port: %s [stream-source=%s], filterExpr: (%s), dataExpr: (%s), mode: %s ==> target: stream(%s) component(%s)",
+		cfg.ServicePort, cfg.StreamFrom, cfg.Where, cfg.Select, cfg.Mode, cfg.ToStream, cfg.ToComponent)
+
+	rt, err := support.NewRuntime("channel", cfg.ServicePort)
 	if err != nil {
-		log.Fatalf("channel: client failed: %s", err)
+		log.Fatal(err)
 	}
-	defer client.Close()
 
-	targetStreamParts, err := support.GetTargetParts(streamToStreamEnv)
+	// setup codec and schema validation of stream input
+	inputCodec, err := support.CodecFromEnv("CHANNEL_STREAM_FROM")
+	if err != nil {
+		log.Fatalf("channel: stream codec: %s", err)
+	}
+	validator, err := support.SchemaValidatorFromEnv("CHANNEL_STREAM_FROM")
 	if err != nil {
-		log.Fatalf("channel: stream target: %s", err)
+		log.Fatalf("channel: stream schema: %s", err)
 	}
-	targetComponentParts, err := support.GetTargetParts(streamToComponentEnv)
+	violationTarget, err := support.TargetFromEnv("CHANNEL_STREAM_FROM_SCHEMA_VIOLATIONS_TO")
 	if err != nil {
-		log.Fatalf("channel: component target: %s", err)
+		log.Fatalf("channel: schema violations: %s", err)
+	}
+
+	c, err := newChannel(cfg, inputCodec, rt.Client)
+	if err != nil {
+		log.Fatalf("channel: %s", err)
+	}
+
+	pipeline := c.newPipeline(rt.Client, inputCodec, validator, violationTarget)
+	if err := rt.Service.AddServiceInvocationHandler(cfg.StreamFrom, pipeline.InvocationHandler()); err != nil {
+		log.Fatalf("channel: service route: %s: failed: %s", cfg.StreamFrom, err)
+	}
+	rt.Go(pipeline.Run)
+
+	if err := rt.Run(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	// start service
-	svc := daprd.NewService(servicePort)
-	if err := svc.AddServiceInvocationHandler(streamFromEnv, invocationHandler); err != nil {
-		log.Fatalf("channel: service route: %s: failed: %s", streamFromEnv, err)
+// newChannel sets up the targets and compiles the expressions of the channel
+func newChannel(cfg config, inputCodec support.Codec, store support.StateStore) (*channel, error) {
+	c := &channel{cfg: cfg}
+	var err error
+	if cfg.ToStream != "" || cfg.ToComponent != "" {
+		codec, err := support.CodecFromEnv("CHANNEL_STREAM_TO")
+		if err != nil {
+			return nil, fmt.Errorf("target codec: %s", err)
+		}
+		if c.streamTarget, err = support.NewTarget(cfg.ToStream, cfg.ToComponent, codec); err != nil {
+			return nil, err
+		}
 	}
 
 	// setup common expression lang (cel) programs
 	// for data selection and data filtering
-	if streamFilterExprEnv != "" {
-		prog, err := support.CompileCELProg(streamFilterExprEnv, decls.NewVar(streamFromEnv, decls.NewMapType(decls.String, decls.Dyn)))
-		if err != nil {
-			log.Fatalf("channel: filter expression: %s", err)
+	if c.streamVar, err = support.StreamVarFromEnv("CHANNEL_STREAM_FROM", cfg.StreamFrom, inputCodec); err != nil {
+		return nil, fmt.Errorf("stream type: %s", err)
+	}
+	if cfg.Dedup.Enabled {
+		if err := c.setupDedup(store); err != nil {
+			return nil, err
 		}
-		filterProg = prog
 	}
-	if streamSelectExprEnv != "" {
-		prog, err := support.CompileCELProg(streamSelectExprEnv, decls.NewVar(streamFromEnv, decls.NewMapType(decls.String, decls.Dyn)))
-		if err != nil {
-			log.Fatalf("channel: data selection expression: %s", err)
+	exprVars := []support.StreamVar{c.streamVar}
+	if cfg.State.Store != "" {
+		if err := c.setupKeyedState(store); err != nil {
+			return nil, err
+		}
+		exprVars = append(exprVars, support.StreamVar{Name: support.StateVarName})
+	}
+	if cfg.Where != "" {
+		if c.whereProg, err = support.CompileWhereProg(cfg.Where, cfg.LibVersion, exprVars...); err != nil {
+			return nil, fmt.Errorf("filter expression: %s", err)
+		}
+	}
+	if cfg.Select != "" {
+		if c.selectProg, err = support.CompileSelectProg(cfg.Select, cfg.LibVersion, exprVars...); err != nil {
+			return nil, fmt.Errorf("data selection expression: %s", err)
 		}
-		dataProg = prog
 	}
-	if triggerExprEnv != "" {
-		prog, err := support.CompileCELProg(triggerExprEnv,
-			decls.NewVar(streamFromEnv, decls.NewMapType(decls.String, decls.Dyn)),
+	if cfg.Routes > 0 {
+		if c.routes, err = newRoutes(cfg, exprVars); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Mode == "aggregate" {
+		c.triggerProg, err = support.CompileCELProg(cfg.Trigger,
 			decls.NewVar("count", decls.Int),
-			decls.NewVar("duration", decls.String),
+			decls.NewVar("duration", decls.Duration),
 		)
 		if err != nil {
-			log.Fatalf("channel: data selection expression: %s", err)
+			return nil, fmt.Errorf("trigger expression: %s", err)
 		}
-		dataProg = prog
-	}
-
-	// setup event processors
-	if err := startProcessingLoop(ctx, inputChan, outputChan); err != nil {
-		log.Fatalf("channel: input loop: %s", err)
-	}
-	if err := startOutputLoop(ctx, client, outputChan, targetStreamParts, targetComponentParts); err != nil {
-		log.Fatalf("channel: ouptut loop: %s", err)
+		c.aggregation = new(aggregation)
 	}
+	return c, nil
+}
 
-	// start dapr services
-	log.Println("channel: starting on port ", servicePort)
-	if err := svc.Start(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("channel: starting failed: %v", err)
-	}
+// newPipeline returns the pipeline processing the events of the channel
+func (c *channel) newPipeline(client support.Dispatcher, inputCodec support.Codec, validator support.SchemaValidator, violationTarget *support.Target) *support.Pipeline {
+	return support.NewPipeline("channel", support.TargetSink(client, c.streamTarget),
+		c.stages(inputCodec, validator, support.TargetSink(client, violationTarget))...,
+	)
 }
 
-func invocationHandler(ctx context.Context, e *common.InvocationEvent) (out *common.Content, err error) {
-	log.Printf("event received: content-type: %s, content-url: %s, qury: %s data(%s) ", e.ContentType, e.DataTypeURL, e.QueryString, string(e.Data))
-	inputChan <- e
-	return &common.Content{
-		Data:        e.Data,
-		ContentType: e.ContentType,
-		DataTypeURL: e.DataTypeURL,
-	}, nil
+// stages returns the processing stages of the channel: events are decoded, validated,
+// deduplicated, filtered and selected for the stream or route targets
+func (c *channel) stages(inputCodec support.Codec, validator support.SchemaValidator, violations support.Sink) []support.Stage {
+	return []support.Stage{
+		support.Decode(inputCodec),
+		support.Validate(validator, violations),
+		support.Bind(c.streamVar),
+		c.deduplicate,
+		c.filter,
+		c.route,
+	}
 }
 
-func startProcessingLoop(ctx context.Context, input chan *common.InvocationEvent, output chan []byte) error {
-	go func() {
-		for {
-			select {
-			case data := <-input:
-				shouldCollect, err := shouldCollect(data, filterProg)
-				if err != nil {
-					log.Printf("channel: %s", err)
-					continue
-				}
-				if shouldCollect {
-					event, err := collectData(data, dataProg)
-					if err != nil {
-						log.Printf("channel: event collection: %s", err)
-					}
-
-					counter++
-					output <- event
-				}
-			case <-ctx.Done():
-				log.Println("channel: input channel shutdown")
-				break
+// newRoutes compiles the routes configured with CHANNEL_ROUTE_<i>_* env variables
+func newRoutes(cfg config, vars []support.StreamVar) ([]*route, error) {
+	var result []*route
+	for i := 0; i < cfg.Routes; i++ {
+		prefix := fmt.Sprintf("CHANNEL_ROUTE_%d", i)
+		r := new(route)
+		var err error
+		if when := os.Getenv(prefix + "_WHEN"); when != "" {
+			if r.whenProg, err = support.CompileWhereProg(when, cfg.LibVersion, vars...); err != nil {
+				return nil, fmt.Errorf("route %d: when expression: %s", i, err)
+			}
+		}
+		if sel := os.Getenv(prefix + "_SELECT"); sel != "" {
+			if r.selectProg, err = support.CompileSelectProg(sel, cfg.LibVersion, vars...); err != nil {
+				return nil, fmt.Errorf("route %d: select expression: %s", i, err)
 			}
 		}
-	}()
+		targets, err := strconv.Atoi(os.Getenv(prefix + "_TARGETS"))
+		if err != nil {
+			return nil, fmt.Errorf("route %d: targets: %s", i, err)
+		}
+		for j := 0; j < targets; j++ {
+			t, err := support.TargetFromEnv(fmt.Sprintf("%s_TO_%d", prefix, j))
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %s", i, err)
+			}
+			if t == nil {
+				return nil, fmt.Errorf("route %d: target %d: stream and/or component must be provided", i, j)
+			}
+			r.targets = append(r.targets, t)
+		}
+		result = append(result, r)
+	}
+	log.Printf("channel: %d routes (match %s)", len(result), cfg.RouteMatch)
+	return result, nil
+}
 
+// setupDedup sets up the deduplication of events
+func (c *channel) setupDedup(store support.StateStore) error {
+	cfg := c.cfg.Dedup
+	if cfg.ID != "" {
+		prog, err := support.CompileKeyProg(cfg.ID, c.cfg.LibVersion, c.streamVar)
+		if err != nil {
+			return fmt.Errorf("dedup id expression: %s", err)
+		}
+		c.dedupIDProg = prog
+	}
+	d, err := support.NewDeduplicator(cfg.Size, cfg.TTL, store, cfg.Store)
+	if err != nil {
+		return err
+	}
+	c.dedup = d
+	log.Printf("channel: dedup: id: (%s), ttl: %s, store: %s", cfg.ID, cfg.TTL, cfg.Store)
 	return nil
 }
 
-func startOutputLoop(ctx context.Context, client dapr.Client, output chan []byte, targetStreamParts, targetComponentParts []string) error {
-	go func() {
-		for {
-			select {
-			case data := <-output:
-				shouldTrigger, err := shouldTrigger(triggerProg)
-				if err != nil {
-					log.Printf("channel: should trigger: %s", err)
-					continue
-				}
-				if shouldTrigger {
-					if len(targetStreamParts) > 0 {
-						pubsub, topic := targetStreamParts[0], targetStreamParts[1]
-						if err := client.PublishEvent(ctx, pubsub, topic, data, dapr.PublishEventWithContentType("application/json")); err != nil {
-							log.Printf("channel: target pubsub/stream: %s", err)
-						} else {
-							log.Printf("channel: target pubsub/stream: output: %s", string(data))
-						}
-					}
-
-					if len(targetComponentParts) > 0 {
-						content := &dapr.DataContent{
-							Data:        data,
-							ContentType: "application/json",
-						}
-						componentId, route := targetComponentParts[0], targetComponentParts[1]
-						if _, err := client.InvokeMethodWithContent(ctx, componentId, route, http.MethodPost, content); err != nil {
-							log.Printf("channel: target component service: %s", err)
-						} else {
-							log.Printf("channel: target component service: output: %s", string(content.Data))
-						}
-					}
-				}
-			case <-ctx.Done():
-				log.Println("channel: output loop shutting down!")
-				break
-			}
+// setupKeyedState compiles the state expressions. The key and initial state expressions can
+// reference the stream, and the update expression can also reference the current state.
+func (c *channel) setupKeyedState(store support.StateStore) error {
+	cfg := c.cfg.State
+	keyProg, err := support.CompileKeyProg(cfg.Key, c.cfg.LibVersion, c.streamVar)
+	if err != nil {
+		return fmt.Errorf("state key expression: %s", err)
+	}
+	var initialProg cel.Program
+	if cfg.Initial != "" {
+		if initialProg, err = support.CompileMapProg(cfg.Initial, c.cfg.LibVersion, c.streamVar); err != nil {
+			return fmt.Errorf("state initial expression: %s", err)
 		}
-	}()
+	}
+	updateProg, err := support.CompileMapProg(cfg.Update, c.cfg.LibVersion, c.streamVar, support.StreamVar{Name: support.StateVarName})
+	if err != nil {
+		return fmt.Errorf("state update expression: %s", err)
+	}
+	log.Printf("channel: keyed state: store: %s, key: (%s), update: (%s)", cfg.Store, cfg.Key, cfg.Update)
+	c.keyedState = support.NewKeyedState(store, cfg.Store, keyProg, initialProg, updateProg)
 	return nil
 }
 
-// shouldCollect applies filtering expression (if any) to determine if that
-// event should be collected for downstream propagation
-func shouldCollect(event *common.InvocationEvent, prog cel.Program) (bool, error) {
-	if prog != nil {
-		jsonData, err := support.ExtractJSONFromInvocation(event)
+// deduplicate drops events whose id was already seen
+func (c *channel) deduplicate(ctx context.Context, e *support.Event) ([]*support.Event, error) {
+	if c.dedup == nil {
+		return []*support.Event{e}, nil
+	}
+	// topic events carry the CloudEvent id, invocations may send a CloudEvent
+	id := e.ID
+	if id == "" {
+		id = support.CloudEventID(e.Data)
+	}
+	if c.dedupIDProg != nil {
+		result, _, err := c.dedupIDProg.Eval(e.Activation)
 		if err != nil {
-			return false, fmt.Errorf("filter expression: marshal data: %s", err)
+			return nil, fmt.Errorf("dedup id expression: %s", err)
 		}
-		dataMap := map[string]interface{}{
-			streamFromEnv: jsonData,
+		id = fmt.Sprint(result.Value())
+	}
+	seen, err := c.dedup.Seen(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if seen {
+		log.Printf("channel: dedup: dropping duplicate event")
+		return nil, nil
+	}
+	return []*support.Event{e}, nil
+}
+
+// filter applies the where expression to the event. With keyed state, the where
+// expression sees the state of the event key before the event, and the state is
+// updated with the events that are kept.
+func (c *channel) filter(ctx context.Context, e *support.Event) ([]*support.Event, error) {
+	if c.keyedState == nil {
+		ok, err := support.EvalWhere(c.whereProg, e.Activation)
+		if err != nil || !ok {
+			return nil, err
 		}
+		return []*support.Event{e}, nil
+	}
 
-		filterResult, _, err := prog.Eval(dataMap)
+	// the where expression and the update are evaluated again with the reloaded
+	// state when another replica modified the key concurrently
+	for attempt := 1; ; attempt++ {
+		state, err := c.keyedState.Get(ctx, e.Activation)
 		if err != nil {
-			return false, fmt.Errorf("filter expression: evaluation:%s", err)
+			return nil, err
 		}
-		if filterResult.Type().TypeName() != "bool" {
-			return false, fmt.Errorf("filter expression: must return a boolean")
+		e.Activation[support.StateVarName] = state.Value
+
+		ok, err := support.EvalWhere(c.whereProg, e.Activation)
+		if err != nil || !ok {
+			return nil, err
 		}
-		shouldCollect := filterResult.Value().(bool)
-		return shouldCollect, nil
-	}
-	return true, nil // always collect if no program provided.
-}
 
-// shouldTrigger returns true if the trigger expression (time duration or
-// event count) evaluates to true or if the expression is not provided.
-func shouldTrigger(prog cel.Program) (bool, error) {
-	//if prog != nil {
-	//	dataMap := map[string]interface{}{
-	//		"count": counter,
-	//	}
-	//
-	//}
-	return true, nil
+		// the select expression sees the state updated with the event
+		err = c.keyedState.Update(ctx, state, e.Activation)
+		if errors.Is(err, support.ErrStateConflict) && attempt < stateUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.Activation[support.StateVarName] = state.Value
+		return []*support.Event{e}, nil
+	}
 }
 
-// collectData applies data collection expression (if any) and returns
-// the collected event (original or synthetic) for downstream propagation.
-func collectData(event *common.InvocationEvent, prog cel.Program) ([]byte, error) {
-	if prog != nil {
-		data, err := support.ExtractJSONFromInvocation(event)
+// route applies the select expressions to the event for the targets of the
+// stream or of the matching routes. When a select expression returns a list,
+// each element is sent as a separate event. In aggregate mode, the events are
+// released when the trigger expression is true.
+func (c *channel) route(_ context.Context, e *support.Event) ([]*support.Event, error) {
+	if len(c.routes) == 0 {
+		events, err := support.SelectEvents(e, c.selectProg, c.cfg.IndexField, c.streamTarget)
 		if err != nil {
-			return nil, fmt.Errorf("data collection: marshal data: %s", err)
+			return nil, err
+		}
+		return c.aggregate(events)
+	}
+	matched, err := c.matchRoutes(e.Activation)
+	if err != nil {
+		return nil, err
+	}
+	var events []*support.Event
+	for _, r := range matched {
+		prog := r.selectProg
+		if prog == nil {
+			prog = c.selectProg
 		}
-		dataMap := map[string]interface{}{
-			streamFromEnv: data,
+		for _, t := range r.targets {
+			selected, err := support.SelectEvents(e, prog, c.cfg.IndexField, t)
+			if err != nil {
+				log.Printf("channel: event collection: %s", err)
+				continue
+			}
+			events = append(events, selected...)
 		}
+	}
+	return c.aggregate(events)
+}
 
-		result, _, err := prog.Eval(dataMap)
-		if err != nil {
-			return nil, fmt.Errorf("data collection: failed to evaluate filter expression: %s", err)
+// aggregate buffers the output events of a stream event and returns the buffered
+// events when the trigger expression is true. Without trigger, the events are returned.
+func (c *channel) aggregate(events []*support.Event) ([]*support.Event, error) {
+	if c.triggerProg == nil {
+		return events, nil
+	}
+	c.aggregation.Lock()
+	defer c.aggregation.Unlock()
+	now := time.Now()
+	if c.aggregation.count == 0 {
+		c.aggregation.start = now
+	}
+	c.aggregation.count++
+	c.aggregation.events = append(c.aggregation.events, events...)
+	trigger, err := shouldTrigger(c.triggerProg, c.aggregation.count, now.Sub(c.aggregation.start))
+	if err != nil || !trigger {
+		return nil, err
+	}
+	released := c.aggregation.events
+	c.aggregation.events, c.aggregation.count = nil, 0
+	return released, nil
+}
+
+// matchRoutes returns the routes matching the event: the first or all matching routes (depending on the
+// route matching mode), or the default routes if none match. If no default route is declared, the stream
+// target (if any) is used as default route.
+func (c *channel) matchRoutes(activation map[string]interface{}) ([]*route, error) {
+	var matched, defaults []*route
+	for i, r := range c.routes {
+		if r.whenProg == nil {
+			defaults = append(defaults, r)
+			continue
 		}
-		conv, err := result.ConvertToNative(reflect.TypeOf(&structpb.Struct{}))
+		match, err := support.EvalWhere(r.whenProg, activation)
 		if err != nil {
-			return nil, fmt.Errorf("data collection: failed to convert to native: %s", err)
+			return nil, fmt.Errorf("route %d: %s", i, err)
 		}
-		jsonData, err := conv.(*structpb.Struct).MarshalJSON()
-		if err != nil {
-			return nil, fmt.Errorf("data collection: failed marshal to JSON: %s", err)
+		if match {
+			matched = append(matched, r)
+			if c.cfg.RouteMatch == "first" {
+				break
+			}
 		}
-		return jsonData, nil
 	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	if len(defaults) == 0 && c.streamTarget != nil {
+		defaults = append(defaults, &route{targets: []*support.Target{c.streamTarget}})
+	}
+	return defaults, nil
+}
 
-	return event.Data, nil
+// shouldTrigger evaluates the trigger expression with the number of stream events
+// aggregated (count) and the time since the first one (duration). It returns true
+// if the expression is not provided.
+func shouldTrigger(prog cel.Program, count int, duration time.Duration) (bool, error) {
+	if prog == nil {
+		return true, nil
+	}
+	result, _, err := prog.Eval(map[string]interface{}{"count": count, "duration": duration})
+	if err != nil {
+		return false, fmt.Errorf("trigger expression: %s", err)
+	}
+	trigger, ok := result.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("trigger expression: must return a boolean")
+	}
+	return trigger, nil
 }
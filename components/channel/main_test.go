@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+	"github.com/vladimirvivien/streaming-runtime/components/support/daprtest"
+)
+
+// process runs the events through the channel stages and returns the outputs
+func process(t *testing.T, c *channel, events ...string) []*support.Event {
+	t.Helper()
+	stage := support.Chain(c.stages(nil, nil, nil)...)
+	var outputs []*support.Event
+	for _, data := range events {
+		result, err := stage(context.Background(), &support.Event{Data: []byte(data), ContentType: support.ContentTypeJSON})
+		if err != nil {
+			t.Fatalf("event %s: %s", data, err)
+		}
+		outputs = append(outputs, result...)
+	}
+	return outputs
+}
+
+// outputData returns the outputs data decoded as JSON
+func outputData(t *testing.T, outputs []*support.Event) []map[string]interface{} {
+	t.Helper()
+	var result []map[string]interface{}
+	for _, out := range outputs {
+		var data map[string]interface{}
+		if err := json.Unmarshal(out.Data, &data); err != nil {
+			t.Fatalf("output %s: %s", out.Data, err)
+		}
+		result = append(result, data)
+	}
+	return result
+}
+
+func compile(t *testing.T, compileFn func(string, int, ...support.StreamVar) (cel.Program, error), expr string) cel.Program {
+	t.Helper()
+	prog, err := compileFn(expr, 0, support.StreamVar{Name: "orders"})
+	if err != nil {
+		t.Fatalf("compile %s: %s", expr, err)
+	}
+	return prog
+}
+
+func TestChannelFilterAndSelect(t *testing.T) {
+	events := []string{`{"id":"a","qty":2}`, `{"id":"b","qty":20}`, `{"id":"c","qty":30,"items":["x","y"]}`}
+	tests := []struct {
+		name string
+		cfg  config
+		want []map[string]interface{}
+	}{
+		{
+			name: "pass through",
+			cfg:  config{},
+			want: []map[string]interface{}{{"id": "a", "qty": 2.0}, {"id": "b", "qty": 20.0}, {"id": "c", "qty": 30.0, "items": []interface{}{"x", "y"}}},
+		},
+		{
+			name: "where",
+			cfg:  config{Where: "orders.qty > 10.0"},
+			want: []map[string]interface{}{{"id": "b", "qty": 20.0}, {"id": "c", "qty": 30.0, "items": []interface{}{"x", "y"}}},
+		},
+		{
+			name: "where and select",
+			cfg:  config{Where: "orders.qty > 10.0", Select: "{'order': orders.id}"},
+			want: []map[string]interface{}{{"order": "b"}, {"order": "c"}},
+		},
+		{
+			name: "select list",
+			cfg:  config{Where: "has(orders.items)", Select: "orders.items.map(i, {'order': orders.id, 'item': i})", IndexField: "n"},
+			want: []map[string]interface{}{{"order": "c", "item": "x", "n": 0.0}, {"order": "c", "item": "y", "n": 1.0}},
+		},
+		{
+			name: "no match",
+			cfg:  config{Where: "orders.qty > 100.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.cfg.StreamFrom = "orders"
+			test.cfg.ToStream = "pubsub/out"
+			c, err := newChannel(test.cfg, nil, daprtest.NewClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := outputData(t, process(t, c, events...))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelRoutes(t *testing.T) {
+	big := &support.Target{StreamParts: []string{"pubsub", "big"}}
+	priority := &support.Target{StreamParts: []string{"pubsub", "priority"}}
+	other := &support.Target{StreamParts: []string{"pubsub", "other"}}
+	stream := &support.Target{StreamParts: []string{"pubsub", "orders"}}
+	event := `{"id":"a","qty":20,"priority":true}`
+	small := `{"id":"b","qty":2,"priority":false}`
+	tests := []struct {
+		name         string
+		match        string
+		withDefault  bool
+		streamTarget *support.Target
+		event        string
+		want         []*support.Target
+	}{
+		{name: "first match", match: "first", event: event, want: []*support.Target{big}},
+		{name: "all matches", match: "all", event: event, want: []*support.Target{big, priority}},
+		{name: "default route", match: "first", withDefault: true, event: small, want: []*support.Target{other}},
+		{name: "stream target as default", match: "first", streamTarget: stream, event: small, want: []*support.Target{stream}},
+		{name: "no route", match: "all", event: small},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := newChannel(config{StreamFrom: "orders", RouteMatch: test.match}, nil, daprtest.NewClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.streamTarget = test.streamTarget
+			c.routes = []*route{
+				{whenProg: compile(t, support.CompileWhereProg, "orders.qty > 10.0"), targets: []*support.Target{big}},
+				{whenProg: compile(t, support.CompileWhereProg, "orders.priority"), selectProg: compile(t, support.CompileSelectProg, "{'id': orders.id}"), targets: []*support.Target{priority}},
+			}
+			if test.withDefault {
+				c.routes = append(c.routes, &route{targets: []*support.Target{other}})
+			}
+
+			outputs := process(t, c, test.event)
+			if len(outputs) != len(test.want) {
+				t.Fatalf("got %d outputs, want %d", len(outputs), len(test.want))
+			}
+			for i, out := range outputs {
+				if out.Target != test.want[i] {
+					t.Errorf("output %d: got target %s, want %s", i, out.Target, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChannelKeyedState(t *testing.T) {
+	cfg := config{
+		StreamFrom: "orders",
+		ToStream:   "pubsub/out",
+		Where:      "getOrDefault(state, 'total', 0.0) < 10.0",
+		Select:     "{'user': orders.user, 'total': state.total}",
+		State: stateConfig{
+			Store:  "state",
+			Key:    "orders.user",
+			Update: "{'total': getOrDefault(state, 'total', 0.0) + orders.qty}",
+		},
+	}
+	c, err := newChannel(cfg, nil, daprtest.NewClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := outputData(t, process(t, c,
+		`{"user":"a","qty":5}`,
+		`{"user":"a","qty":6}`,
+		`{"user":"b","qty":1}`,
+		`{"user":"a","qty":1}`, // total of a is 11 before the event: filtered
+	))
+	want := []map[string]interface{}{
+		{"user": "a", "total": 5.0},
+		{"user": "a", "total": 11.0},
+		{"user": "b", "total": 1.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// racingStore saves a state for the key after the first load of the key, as
+// another replica would do between the load and the update of the channel
+type racingStore struct {
+	*daprtest.Client
+	raced bool
+	value string
+}
+
+func (s *racingStore) GetState(ctx context.Context, storeName, key string) (*dapr.StateItem, error) {
+	item, err := s.Client.GetState(ctx, storeName, key)
+	if err != nil || s.raced {
+		return item, err
+	}
+	s.raced = true
+	var etag *dapr.ETag
+	if item.Etag != "" {
+		etag = &dapr.ETag{Value: item.Etag}
+	}
+	return item, s.Client.SaveBulkState(ctx, storeName, &dapr.SetStateItem{Key: key, Value: []byte(s.value), Etag: etag})
+}
+
+func TestChannelKeyedStateConflict(t *testing.T) {
+	cfg := config{
+		StreamFrom: "orders",
+		ToStream:   "pubsub/out",
+		Where:      "getOrDefault(state, 'total', 0.0) < 10.0",
+		Select:     "{'user': orders.user, 'total': state.total}",
+		State: stateConfig{
+			Store:  "state",
+			Key:    "orders.user",
+			Update: "{'total': getOrDefault(state, 'total', 0.0) + orders.qty}",
+		},
+	}
+	tests := []struct {
+		name  string
+		value string
+		want  []map[string]interface{}
+	}{
+		{name: "update with reloaded state", value: `{"total":4}`, want: []map[string]interface{}{{"user": "a", "total": 9.0}}},
+		{name: "filter with reloaded state", value: `{"total":12}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &racingStore{Client: daprtest.NewClient(), value: test.value}
+			// the key must exist for the update to be conditional on its etag
+			if err := store.Client.SaveBulkState(context.Background(), "state", &dapr.SetStateItem{Key: "a", Value: []byte(`{"total":1}`)}); err != nil {
+				t.Fatal(err)
+			}
+			c, err := newChannel(cfg, nil, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := outputData(t, process(t, c, `{"user":"a","qty":5}`))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelDedup(t *testing.T) {
+	cfg := config{StreamFrom: "orders", ToStream: "pubsub/out", Dedup: dedupConfig{Enabled: true, ID: "orders.id"}}
+	c, err := newChannel(cfg, nil, daprtest.NewClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := process(t, c, `{"id":"a"}`, `{"id":"b"}`, `{"id":"a"}`)
+	if len(outputs) != 2 {
+		t.Errorf("got %d outputs, want 2", len(outputs))
+	}
+}
+
+func TestChannelDedupEventID(t *testing.T) {
+	c, err := newChannel(config{StreamFrom: "orders", ToStream: "pubsub/out", Dedup: dedupConfig{Enabled: true}}, nil, daprtest.NewClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage := support.Chain(c.stages(nil, nil, nil)...)
+	tests := []struct {
+		name string
+		id   string
+		data string
+		want bool
+	}{
+		{name: "topic event", id: "1", data: `{"qty":1}`, want: true},
+		{name: "same data, new id", id: "2", data: `{"qty":1}`, want: true},
+		{name: "redelivered id", id: "1", data: `{"qty":2}`},
+		{name: "invocation", data: `{"qty":3}`, want: true},
+		{name: "repeated invocation", data: `{"qty":3}`},
+		{name: "invocation of a CloudEvent", data: `{"specversion":"1.0","id":"1","data":{}}`},
+	}
+	for _, test := range tests {
+		e := &support.Event{Data: []byte(test.data), ContentType: support.ContentTypeJSON, ID: test.id}
+		if test.id != "" {
+			e.Topic = "orders"
+		}
+		outputs, err := stage(context.Background(), e)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if got := len(outputs) > 0; got != test.want {
+			t.Errorf("%s: got kept %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestShouldTrigger(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		count    int
+		duration time.Duration
+		want     bool
+		wantErr  string
+	}{
+		{name: "no trigger expression", want: true},
+		{name: "count below", expr: "count >= 3", count: 2},
+		{name: "count reached", expr: "count >= 3", count: 3, want: true},
+		{name: "duration below", expr: "duration > duration('1s')", duration: time.Millisecond},
+		{name: "duration reached", expr: "duration > duration('1s')", duration: 2 * time.Second, want: true},
+		{name: "count or duration", expr: "count >= 100 || duration > duration('1s')", count: 1, duration: 2 * time.Second, want: true},
+		{name: "not a bool", expr: "count", count: 1, wantErr: "must return a boolean"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var prog cel.Program
+			if test.expr != "" {
+				var err error
+				if prog, err = support.CompileCELProg(test.expr, decls.NewVar("count", decls.Int), decls.NewVar("duration", decls.Duration)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := shouldTrigger(prog, test.count, test.duration)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelAggregate(t *testing.T) {
+	cfg := config{StreamFrom: "orders", ToStream: "pubsub/out", Mode: "aggregate", Trigger: "count == 3", Where: "orders.qty > 0.0"}
+	c, err := newChannel(cfg, nil, daprtest.NewClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the filtered out events are not aggregated
+	if outputs := process(t, c, `{"id":"a","qty":1}`, `{"id":"b","qty":0}`, `{"id":"c","qty":2}`); len(outputs) != 0 {
+		t.Fatalf("got %d outputs before the trigger, want 0", len(outputs))
+	}
+	outputs := process(t, c, `{"id":"d","qty":3}`)
+	want := []map[string]interface{}{{"id": "a", "qty": 1.0}, {"id": "c", "qty": 2.0}, {"id": "d", "qty": 3.0}}
+	if got := outputData(t, outputs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the aggregation restarts once released
+	if outputs := process(t, c, `{"id":"e","qty":1}`); len(outputs) != 0 {
+		t.Errorf("got %d outputs after the trigger, want 0", len(outputs))
+	}
+}
+
+func TestChannelAggregateConfig(t *testing.T) {
+	cfg := config{StreamFrom: "orders", ToStream: "pubsub/out", Mode: "aggregate", RouteMatch: "first"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "CHANNEL_AGGREGATE_TRIGGER") {
+		t.Errorf("got error %v, want a missing trigger error", err)
+	}
+	cfg.Trigger = "orders.qty > 10"
+	if _, err := newChannel(cfg, nil, daprtest.NewClient()); err == nil {
+		t.Error("expected an error for a trigger expression of an unknown variable")
+	}
+}
+
+func TestChannelPipeline(t *testing.T) {
+	client := daprtest.NewClient()
+	cfg := config{StreamFrom: "orders", ToStream: "pubsub/out", Where: "orders.qty > 10.0"}
+	c, err := newChannel(cfg, nil, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := c.newPipeline(client, nil, nil, nil)
+	if err := client.AddServiceInvocationHandler(cfg.StreamFrom, pipeline.InvocationHandler()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pipeline.Run(ctx)
+
+	for _, data := range []string{`{"qty":1}`, `{"qty":11}`, `{"qty":12}`} {
+		content := &dapr.DataContent{Data: []byte(data), ContentType: support.ContentTypeJSON}
+		if _, err := client.InvokeMethodWithContent(ctx, "channel", cfg.StreamFrom, http.MethodPost, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.Published()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	published := client.Published()
+	if len(published) != 2 {
+		t.Fatalf("got %d published events, want 2", len(published))
+	}
+	for _, p := range published {
+		if p.Pubsub != "pubsub" || p.Topic != "out" {
+			t.Errorf("published to %s/%s", p.Pubsub, p.Topic)
+		}
+	}
+}
@@ -4,257 +4,383 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"reflect"
 	"strings"
 	"sync"
 	"time"
 
-	dapr "github.com/dapr/go-sdk/client"
 	"github.com/dapr/go-sdk/service/common"
-	daprd "github.com/dapr/go-sdk/service/http"
 	"github.com/google/cel-go/cel"
-	"github.com/google/cel-go/checker/decls"
-	commontypes "github.com/google/cel-go/common/types/ref"
 	"github.com/vladimirvivien/streaming-runtime/components/support"
-	exprv1alpha1 "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// config is the joiner configuration, loaded from env variables
+type config struct {
+	ServicePort      string        `env:"JOINER_SERVICE_PORT" default:":8080"`  // service port
+	StreamFrom0      string        `env:"JOINER_STREAM_FROM_0" required:"true"` // a |-separated list of info for stream 0
+	StreamFrom1      string        `env:"JOINER_STREAM_FROM_1" required:"true"` // a |-separated list of info for stream 1
+	ToComponent      string        `env:"JOINER_STREAM_TO_COMPONENT"`           // component[/path] to route result
+	ToStream         string        `env:"JOINER_STREAM_TO_STREAM"`              // pubsub[/topic] where to route result
+	Where            string        `env:"JOINER_STREAM_WHERE"`                  // expression used to filter data from stream
+	Select           string        `env:"JOINER_STREAM_SELECT"`                 // expression used to generate data output from streams
+	Window           time.Duration `env:"JOINER_WINDOW_SIZE" default:"10ms"`    // window size formatted as Go duration   (i.e. 1m, 3ms, etc)
+	LibVersion       int           `env:"JOINER_STREAM_LIB_VERSION"`            // version of the expression function library (default latest)
+	IndexField       string        `env:"JOINER_STREAM_INDEX_FIELD"`            // field set with the element index, when select returns a list
+	EmitMode         string        `env:"JOINER_EMIT_MODE" default:"batch"`     // how window results are emitted, valid values = {batch | each}
+	EmitMaxBatchSize int           `env:"JOINER_EMIT_MAX_BATCH_SIZE"`           // max number of results per batch (default no limit)
+	Partitions       int           `env:"JOINER_PARTITIONS" default:"1"`        // number of partitions, one per replica
+	PodName          string        `env:"JOINER_POD_NAME"`                      // name of the replica pod, its ordinal is the owned partition
+	PeerURL          string        `env:"JOINER_PARTITION_PEER_URL"`            // URL of the replicas, where {partition} is the replica ordinal
+}
+
+// Validate validates the joiner configuration
+func (c *config) Validate() error {
+	if c.ToStream == "" && c.ToComponent == "" {
+		return fmt.Errorf("env JOINER_STREAM_TO_STREAM and/or JOINER_STREAM_TO_COMPONENT must be provided")
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("env JOINER_WINDOW_SIZE must be positive")
+	}
+	if c.Partitions > 1 && (c.PodName == "" || c.PeerURL == "") {
+		return fmt.Errorf("env JOINER_POD_NAME and JOINER_PARTITION_PEER_URL must be provided with JOINER_PARTITIONS")
+	}
+	return support.OneOf("JOINER_EMIT_MODE", c.EmitMode, "batch", "each")
+}
+
 type eventStore struct {
 	sync.RWMutex
-	streams map[string][]*common.TopicEvent
+	streams map[string][]*support.Event
 }
 
-var (
-	servicePort          = os.Getenv("JOINER_SERVICE_PORT")        // service port
-	streamFrom0Env       = os.Getenv("JOINER_STREAM_FROM_0")       // a |-separated list of info for stream 0
-	streamFrom1Env       = os.Getenv("JOINER_STREAM_FROM_1")       // a |-separated list of info for stream 1
-	streamToComponentEnv = os.Getenv("JOINER_STREAM_TO_COMPONENT") // component[/path] to route result
-	streamToStreamEnv    = os.Getenv("JOINER_STREAM_TO_STREAM")    // pubsub[/topic] where to route result
-	streamFilterExprEnv  = os.Getenv("JOINER_STREAM_FILTER")       // expression used to filter data from stream
-	streamSelectExprEnv  = os.Getenv("JOINER_STREAM_SELECT")       // expression used to generate data output from streams
-	windowSizeEnv        = os.Getenv("JOINER_WINDOW_SIZE")         // window size formatted as Go duration   (i.e. 1m, 3ms, etc)
-	topics               []string                                  // names of known topics
-
-	inputChan  chan *common.TopicEvent
-	outputChan chan []byte
-
-	store  *eventStore
-	window *time.Ticker
-
-	filterProg cel.Program
-	dataProg   cel.Program
-)
+func (s *eventStore) add(e *support.Event) {
+	s.Lock()
+	defer s.Unlock()
+	s.streams[e.Topic] = append(s.streams[e.Topic], e)
+}
 
 func (s *eventStore) reset() {
 	s.Lock()
 	defer s.Unlock()
-	for _, topic := range topics {
-		if s.streams[topic] != nil {
-			s.streams[topic] = nil
-		}
-	}
-	s.streams = nil
-	s.streams = make(map[string][]*common.TopicEvent)
+	s.streams = make(map[string][]*support.Event)
 }
 
-func main() {
-	if servicePort == "" {
-		servicePort = ":8080"
-	}
-	if streamFrom0Env == "" || streamFrom1Env == "" {
-		log.Fatalf("joiner: env JOINER_STREAM_FROM_0 or JOINER_STREAM_FROM_1 missing")
-	}
-	if streamToComponentEnv == "" {
-		log.Fatalf("joiner: env JOINER_STREAM_TO_COMPONENT not provided")
-	}
-	if windowSizeEnv == "" {
-		windowSizeEnv = "10ms"
-	}
-	log.Printf("joiner: service-port: %s, streams: (%s;%s) filter: (%s) ==> target: %s (every %s)",
-		servicePort, streamFrom0Env, streamFrom1Env, streamFilterExprEnv, streamToComponentEnv, windowSizeEnv)
-	// setup internal channels for data processing
-	inputChan = make(chan *common.TopicEvent)
-	outputChan = make(chan []byte, 1024)
+// joiner joins the events of two streams within a time window
+type joiner struct {
+	cfg    config
+	topics []string // names of the joined topics
 
-	ctx := context.Background()
+	store *eventStore
 
-	// setup client
-	client, err := dapr.NewClient()
-	if err != nil {
-		log.Fatalf("joiner: client failed: %s", err)
-	}
-	defer client.Close()
+	whereProg  cel.Program
+	selectProg cel.Program
 
-	targetComponentParts, err := support.GetTargetParts(streamToComponentEnv)
-	if err != nil {
-		log.Fatalf("joiner: stream.To component: %s", err)
+	streamTarget *support.Target // stream and/or component where joined data is sent
+
+	flushes chan chan int // flush requests of the open window, answered with the number of events flushed
+}
+
+func main() {
+	var cfg config
+	if err := support.LoadConfig(&cfg); err != nil {
+		log.Fatalf("joiner: %s", err)
 	}
-	targetStreamParts, err := support.GetTargetParts(streamToStreamEnv)
+	log.Printf("joiner: service-port: %s, streams: (%s;%s) filter: (%s) ==> target: stream(%s) component(%s) (every %s)",
+		cfg.ServicePort, cfg.StreamFrom0, cfg.StreamFrom1, cfg.Where, cfg.ToStream, cfg.ToComponent, cfg.Window)
+
+	rt, err := support.NewRuntime("joiner", cfg.ServicePort)
 	if err != nil {
-		log.Fatalf("joiner: stream.To stream: %s", err)
+		log.Fatal(err)
 	}
 
-	// setup time window
-	winDur, err := time.ParseDuration(windowSizeEnv)
-	if err != nil {
-		log.Fatalf("joiner: time window: %s", err)
+	j := &joiner{cfg: cfg, store: &eventStore{streams: make(map[string][]*support.Event)}, flushes: make(chan chan int)}
+	if j.streamTarget, err = support.TargetFromEnv("JOINER_STREAM_TO"); err != nil {
+		log.Fatalf("joiner: stream.To: %s", err)
 	}
-	window = time.NewTicker(winDur)
-	store = &eventStore{streams: make(map[string][]*common.TopicEvent)}
 
-	// setup service handlers
-	svc := daprd.NewService(servicePort)
-	streamsInfo := []string{streamFrom0Env, streamFrom1Env}
+	// with partitions, each replica joins the events of the keys of its partition
+	var partitioner *support.Partitioner
+	if cfg.Partitions > 1 {
+		self, err := support.Ordinal(cfg.PodName)
+		if err != nil {
+			log.Fatalf("joiner: partitions: %s", err)
+		}
+		partitioner = &support.Partitioner{
+			Partitions: cfg.Partitions,
+			Self:       self,
+			Forwarder:  support.NewHTTPForwarder(cfg.PeerURL, nil),
+		}
+		log.Printf("joiner: partition %d of %d", self, cfg.Partitions)
+	}
 
-	// setup topic handler for each subscription
-	for _, stream := range streamsInfo {
+	// setup decoding, validation and binding of events for each subscription
+	var variables []support.StreamVar
+	topicStages := make(map[string]support.Stage)
+	var subs []*common.Subscription
+	for i, stream := range []string{cfg.StreamFrom0, cfg.StreamFrom1} {
 		sub, err := getSubscription(stream)
 		if err != nil {
 			log.Fatalf("joiner: failed to get subscription: %s", err)
 		}
-		topics = append(topics, sub.Topic)
-		store.streams[sub.Topic] = nil
+		subs = append(subs, sub)
+		j.topics = append(j.topics, sub.Topic)
 
-		if err := svc.AddTopicEventHandler(sub, makeEventHandler(inputChan)); err != nil {
-			log.Fatalf("joiner: pubsub: %s: failed: %s", sub.PubsubName, err)
+		prefix := fmt.Sprintf("JOINER_STREAM_FROM_%d", i)
+		codec, err := support.CodecFromEnv(prefix)
+		if err != nil {
+			log.Fatalf("joiner: stream codec: %s", err)
 		}
-	}
-
-	// CEL program variables
-	variables := []*exprv1alpha1.Decl{
-		decls.NewVar(topics[0], decls.NewMapType(decls.String, decls.Dyn)),
-		decls.NewVar(topics[1], decls.NewMapType(decls.String, decls.Dyn)),
+		streamVar, err := support.StreamVarFromEnv(prefix, sub.Topic, codec)
+		if err != nil {
+			log.Fatalf("joiner: stream type: %s", err)
+		}
+		variables = append(variables, streamVar)
+		validator, err := support.SchemaValidatorFromEnv(prefix)
+		if err != nil {
+			log.Fatalf("joiner: stream schema: %s", err)
+		}
+		violationTarget, err := support.TargetFromEnv(prefix + "_SCHEMA_VIOLATIONS_TO")
+		if err != nil {
+			log.Fatalf("joiner: schema violations: %s", err)
+		}
+		stages := []support.Stage{
+			support.Decode(codec),
+			support.Validate(validator, support.TargetSink(rt.Client, violationTarget)),
+			support.Bind(streamVar),
+		}
+		if partitioner != nil {
+			keyExpr := os.Getenv(prefix + "_PARTITION_KEY")
+			if keyExpr == "" {
+				log.Fatalf("joiner: partitions: env %s_PARTITION_KEY must be provided", prefix)
+			}
+			keyProg, err := support.CompileKeyProg(keyExpr, cfg.LibVersion, streamVar)
+			if err != nil {
+				log.Fatalf("joiner: partition key expression: %s", err)
+			}
+			stages = append(stages, support.Partition(keyProg, partitioner))
+		}
+		topicStages[sub.Topic] = support.Chain(stages...)
 	}
 
 	// setup common expression lang (cel) programs
 	// for data selection and data filtering
-	if streamFilterExprEnv != "" {
-
-		prog, err := support.CompileCELProg(streamFilterExprEnv, variables...)
-		if err != nil {
+	if cfg.Where != "" {
+		if j.whereProg, err = support.CompileWhereProg(cfg.Where, cfg.LibVersion, variables...); err != nil {
 			log.Fatalf("joiner: filter expression: %s", err)
 		}
-		filterProg = prog
 	}
-	if streamSelectExprEnv != "" {
-		prog, err := support.CompileCELProg(streamSelectExprEnv, variables...)
-		if err != nil {
+	if cfg.Select != "" {
+		if j.selectProg, err = support.CompileSelectProg(cfg.Select, cfg.LibVersion, variables...); err != nil {
 			log.Fatalf("joiner: data selection expression: %s", err)
 		}
-		dataProg = prog
 	}
 
-	// setup event processors
-	if err := startInputLoop(ctx, window, inputChan, outputChan); err != nil {
-		log.Fatalf("joiner: input loop: %s", err)
+	pipeline := j.newPipeline(rt.Client, topicStages)
+	for _, sub := range subs {
+		if err := rt.Service.AddTopicEventHandler(sub, pipeline.TopicEventHandler()); err != nil {
+			log.Fatalf("joiner: pubsub: %s: failed: %s", sub.PubsubName, err)
+		}
+		if partitioner != nil {
+			if err := rt.Service.AddServiceInvocationHandler(support.PartitionRoute(sub.Topic), pipeline.PartitionHandler(sub.Topic)); err != nil {
+				log.Fatalf("joiner: partition handler: %s", err)
+			}
+		}
 	}
-	if err := startOutputLoop(ctx, client, outputChan, targetComponentParts, targetStreamParts); err != nil {
-		log.Fatalf("joiner: target invoker: %s", err)
+	// the open window is flushed on demand through Dapr service invocation
+	if err := rt.Service.AddServiceInvocationHandler(flushRoute, j.flushHandler()); err != nil {
+		log.Fatalf("joiner: flush handler: %s", err)
 	}
+	rt.Go(pipeline.Run)
+	rt.Go(func(ctx context.Context) {
+		j.runWindow(ctx, pipeline)
+	})
 
-	// start dapr services
-	log.Println("joiner: starting on port ", servicePort)
-	if err := svc.Start(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("joiner: starting failed: %v", err)
+	if err := rt.Run(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	log.Println("joiner: started on port ", servicePort)
+// newPipeline returns the pipeline processing the events of the joined streams: events
+// are processed by the stages of their topic and stored until the window closes
+func (j *joiner) newPipeline(client support.Dispatcher, topicStages map[string]support.Stage) *support.Pipeline {
+	return support.NewPipeline("joiner", support.TargetSink(client, j.streamTarget),
+		func(ctx context.Context, e *support.Event) ([]*support.Event, error) {
+			stage, ok := topicStages[e.Topic]
+			if !ok {
+				return nil, fmt.Errorf("unknown topic %s", e.Topic)
+			}
+			return stage(ctx, e)
+		},
+		j.collect,
+	)
 }
 
-func makeEventHandler(inputChan chan *common.TopicEvent) common.TopicEventHandler {
-	return func(ctx context.Context, e *common.TopicEvent) (retry bool, err error) {
-		inputChan <- e
-		return false, nil
-	}
+// collect stores the event until its window closes
+func (j *joiner) collect(_ context.Context, e *support.Event) ([]*support.Event, error) {
+	log.Printf("joiner: received data: topic=%s, data=%v", e.Topic, e.Value)
+	j.store.add(e)
+	return nil, nil
 }
 
-// startInputLoop reads incoming stream from eventChan and either:
-//  - Store stream for aggregation, or
-//  - Check time window, if closed: aggregate stored data, and
-//  - Send aggregated data to outputChan for further processing
-func startInputLoop(ctx context.Context, window *time.Ticker, input chan *common.TopicEvent, output chan []byte) error {
-	log.Print("joiner: starting input loop")
-	go func() {
-		for {
-			select {
-			case e := <-input: // store stream while window is opened
-				store.Lock()
-				log.Printf("joiner: received data: topic=%s, data=%v", e.Topic, e.Data)
-				store.streams[e.Topic] = append(store.streams[e.Topic], e)
-				store.Unlock()
-			case <-window.C: // aggregate stream, when window closes
-				events, err := aggregateEvents(store)
-				if err != nil {
-					log.Printf("joiner: failed to aggregate events: %s", err)
-					continue
-				}
-				jsonData, err := events.MarshalJSON()
-				if err != nil {
-					log.Println("joiner: failed to marshal json data")
-					continue
-				}
-				output <- jsonData
-				store.reset()
-			case <-ctx.Done():
-				log.Println("joiner: event processor done!")
-				return
+const (
+	// flushRoute is the route closing the open window and flushing its results
+	flushRoute = "window/flush"
+	// flushTimeout bounds the time spent flushing the open window on shutdown
+	flushTimeout = 10 * time.Second
+)
+
+// runWindow joins the events stored during a time window, when the window
+// closes, and emits the results to the pipeline sink. The open window is closed
+// early, and its results flushed to the sink, on flush requests and on shutdown.
+// When a window is not joined (i.e. a stream has no event), its events are kept
+// and the window extends to the next window boundary.
+func (j *joiner) runWindow(ctx context.Context, pipeline *support.Pipeline) {
+	first := time.Now()
+	windowStart := first
+	window := time.NewTicker(j.cfg.Window)
+	defer window.Stop()
+	for {
+		select {
+		case tick := <-window.C:
+			windowEnd := windowBoundary(first, j.cfg.Window, tick)
+			events, err := j.closeWindow(windowStart, windowEnd)
+			if err != nil {
+				log.Printf("joiner: %s", err)
+				continue
+			}
+			windowStart = windowEnd
+			for _, e := range events {
+				pipeline.Emit(e)
+			}
+		case flushed := <-j.flushes:
+			windowEnd := time.Now()
+			count, err := j.flushWindow(ctx, pipeline, windowStart, windowEnd)
+			if err == nil {
+				windowStart = windowEnd
 			}
+			flushed <- count
+		case <-ctx.Done():
+			// the pipeline is done, the results are sent with a new context
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			j.flushWindow(flushCtx, pipeline, windowStart, time.Now())
+			cancel()
+			log.Println("joiner: window done!")
+			return
 		}
-	}()
+	}
+}
 
-	return nil
+// windowBoundary returns the last window boundary, a whole number of window sizes
+// after the first window start, at the tick time. Ticks delayed by slow joins are
+// dropped by the ticker, the boundaries they missed are skipped.
+func windowBoundary(first time.Time, size time.Duration, tick time.Time) time.Time {
+	return first.Add(tick.Sub(first).Truncate(size))
 }
 
-// startOutputLoop does the followings:
-//  - Reads aggregated data (from dataChan)
-//  - Encodes as json
-//  - Send
-func startOutputLoop(ctx context.Context, client dapr.Client, outChan chan []byte, targetComponentParts, targetStreamParts []string) error {
-	log.Print("joiner: starting output loop")
-	go func() {
-		for {
-			select {
-			case data := <-outChan:
-				if len(data) == 0 {
-					log.Print("joiner: data output is zero")
-					continue
-				}
+// flushWindow closes the open window and sends its results to the pipeline sink,
+// it returns the number of events sent
+func (j *joiner) flushWindow(ctx context.Context, pipeline *support.Pipeline, windowStart, windowEnd time.Time) (int, error) {
+	events, err := j.closeWindow(windowStart, windowEnd)
+	if err != nil {
+		log.Printf("joiner: open window not flushed: %s", err)
+		return 0, err
+	}
+	pipeline.Flush(ctx, events...)
+	log.Printf("joiner: open window flushed: %d events", len(events))
+	return len(events), nil
+}
 
-				if len(targetStreamParts) > 0 {
-					pubsub, topic := targetStreamParts[0], targetStreamParts[1]
-					if err := client.PublishEvent(ctx, pubsub, topic, data, dapr.PublishEventWithContentType("application/json")); err != nil {
-						log.Printf("joiner: target pubsub/stream: %s", err)
-					} else {
-						log.Printf("joiner: data sent pubsub/stream %s: %s", targetStreamParts, string(data))
-					}
-				}
+// flushHandler returns the invocation handler flushing the open window
+func (j *joiner) flushHandler() common.ServiceInvocationHandler {
+	return func(ctx context.Context, _ *common.InvocationEvent) (*common.Content, error) {
+		flushed := make(chan int, 1)
+		select {
+		case j.flushes <- flushed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		data := []byte(fmt.Sprintf(`{"flushed":%d}`, <-flushed))
+		return &common.Content{Data: data, ContentType: support.ContentTypeJSON}, nil
+	}
+}
 
-				if len(targetComponentParts) > 0 {
-					content := &dapr.DataContent{
-						Data:        data,
-						ContentType: "application/json",
-					}
-					componentId, route := targetComponentParts[0], targetComponentParts[1]
-					if _, err := client.InvokeMethodWithContent(ctx, componentId, route, http.MethodPost, content); err != nil {
-						log.Printf("joiner: target component service: %s", err)
-					} else {
-						log.Printf("joiner: data sent to %s: %s", targetComponentParts, string(content.Data))
-					}
-				}
-			case <-ctx.Done():
-				log.Println("joiner: output invoker done!")
-				break
+// closeWindow joins the events stored during the window and returns the output
+// events, carrying the window bounds as metadata. The store is reset once the
+// join succeeds: if a stream has no event, the events are kept for the next window.
+func (j *joiner) closeWindow(windowStart, windowEnd time.Time) ([]*support.Event, error) {
+	metadata := map[string]string{
+		"windowStart": windowStart.UTC().Format(time.RFC3339Nano),
+		"windowEnd":   windowEnd.UTC().Format(time.RFC3339Nano),
+	}
+	joined, err := j.aggregateEvents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate events: %s", err)
+	}
+	outputs, err := j.emitEvents(joined)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %s", err)
+	}
+	var events []*support.Event
+	for _, data := range outputs {
+		events = append(events, &support.Event{
+			Data:        data,
+			ContentType: j.streamTarget.ContentType(),
+			Metadata:    metadata,
+			Target:      j.streamTarget,
+		})
+	}
+	j.store.reset()
+	return events, nil
+}
+
+// emitEvents encodes the joined events of a window according to the emit mode:
+// one batch (split in batches of maxBatchSize, if set) or one output per event.
+func (j *joiner) emitEvents(events *structpb.ListValue) ([][]byte, error) {
+	var outputs [][]byte
+	if j.cfg.EmitMode == "each" {
+		for _, event := range events.GetValues() {
+			data, err := j.encodeEvents(event)
+			if err != nil {
+				return nil, err
 			}
+			outputs = append(outputs, data)
+		}
+		return outputs, nil
+	}
+
+	values := events.GetValues()
+	size := len(values)
+	if j.cfg.EmitMaxBatchSize > 0 {
+		size = j.cfg.EmitMaxBatchSize
+	}
+	for start := 0; start < len(values); start += size {
+		end := start + size
+		if end > len(values) {
+			end = len(values)
+		}
+		data, err := j.encodeEvents(structpb.NewListValue(&structpb.ListValue{Values: values[start:end]}))
+		if err != nil {
+			return nil, err
 		}
-	}()
-	return nil
+		outputs = append(outputs, data)
+	}
+	return outputs, nil
+}
+
+// encodeEvents encodes joined events (a list or a single event) using the
+// target codec or JSON if none is configured
+func (j *joiner) encodeEvents(events *structpb.Value) ([]byte, error) {
+	if j.streamTarget.Codec == nil {
+		return events.MarshalJSON()
+	}
+	return j.streamTarget.Codec.Encode(events.AsInterface())
 }
 
 func getSubscription(streamInfo string) (*common.Subscription, error) {
 	streamPart := strings.Split(streamInfo, "|")
+	if len(streamPart) < 3 {
+		return nil, fmt.Errorf("malformed stream info: %s", streamInfo)
+	}
 
 	return &common.Subscription{
 		PubsubName: streamPart[0],
@@ -265,28 +391,31 @@ func getSubscription(streamInfo string) (*common.Subscription, error) {
 }
 
 // aggregatedEvents applies left join semantics to select and filter data
-func aggregateEvents(store *eventStore) (*structpb.ListValue, error) {
-	store.RLock()
-	defer store.RUnlock()
+func (j *joiner) aggregateEvents() (*structpb.ListValue, error) {
+	j.store.RLock()
+	defer j.store.RUnlock()
 
 	var bucket []interface{}
-	topicA, topicB := topics[0], topics[1]
-	if len(store.streams[topicA]) == 0 || len(store.streams[topicB]) == 0 {
+	topicA, topicB := j.topics[0], j.topics[1]
+	if len(j.store.streams[topicA]) == 0 || len(j.store.streams[topicB]) == 0 {
 		return nil, fmt.Errorf("empty stream(s): join will be empty")
 	}
-	for _, eventA := range store.streams[topicA] {
-		for _, eventB := range store.streams[topicB] {
+	for _, eventA := range j.store.streams[topicA] {
+		for _, eventB := range j.store.streams[topicB] {
 			// 1) apply filter expression 2) if ok, apply data join expression 3) send to output
-			shouldCollect, err := shouldCollect(eventA, eventB, filterProg)
+			activation := joinActivation(eventA, eventB)
+			shouldCollect, err := support.EvalWhere(j.whereProg, activation)
 			if err != nil {
 				return nil, fmt.Errorf("shouldCollect check failed: %s", err)
 			}
 			if shouldCollect {
-				data, err := collectData(eventA, eventB, dataProg)
+				data, err := j.collectData(eventA, eventB, activation)
 				if err != nil {
 					return nil, fmt.Errorf("failed to collect: %s", err)
 				}
-				bucket = append(bucket, data.AsMap())
+				for _, value := range data {
+					bucket = append(bucket, value.AsMap())
+				}
 			}
 		}
 	}
@@ -302,58 +431,38 @@ func aggregateEvents(store *eventStore) (*structpb.ListValue, error) {
 	return list, nil
 }
 
-func collectData(eventA, eventB *common.TopicEvent, prog cel.Program) (*structpb.Struct, error) {
-	dataMap := map[string]interface{}{
-		eventA.Topic: eventA.Data,
-		eventB.Topic: eventB.Data,
-	}
-	if prog != nil {
-		result, _, err := prog.Eval(dataMap)
+// collectData applies the data selection expression (if any) to joined events. If the
+// expression returns a list, each element is collected.
+func (j *joiner) collectData(eventA, eventB *support.Event, activation map[string]interface{}) ([]*structpb.Struct, error) {
+	if j.selectProg != nil {
+		result, _, err := j.selectProg.Eval(activation)
 		if err != nil {
 			return nil, err
 		}
-		conv, err := result.ConvertToNative(reflect.TypeOf(&structpb.Struct{}))
+		conv, err := support.ResultStructs(result, j.cfg.IndexField)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert to native: %s", err)
 		}
-		return conv.(*structpb.Struct), nil
+		return conv, nil
 	}
 
-	result, err := structpb.NewStruct(dataMap)
+	result, err := structpb.NewStruct(map[string]interface{}{
+		eventA.Topic: eventA.Value,
+		eventB.Topic: eventB.Value,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("new structpb Value failed: %s", err)
 	}
-	return result, nil
+	return []*structpb.Struct{result}, nil
 }
 
-func shouldCollect(eventA, eventB *common.TopicEvent, prog cel.Program) (bool, error) {
-	if filterProg != nil {
-		dataMap := map[string]interface{}{
-			eventA.Topic: eventA.Data,
-			eventB.Topic: eventB.Data,
-		}
-		filterResult, _, err := prog.Eval(dataMap)
-		if err != nil {
-			return false, err
-		}
-		if filterResult.Type().TypeName() != "bool" {
-			return false, fmt.Errorf("select: filter expression: must return a boolean")
+// joinActivation merges the stream variables bound to joined events
+func joinActivation(events ...*support.Event) map[string]interface{} {
+	activation := make(map[string]interface{})
+	for _, e := range events {
+		for name, value := range e.Activation {
+			activation[name] = value
 		}
-		shouldCollect := filterResult.Value().(bool)
-		return shouldCollect, nil
-	} else {
-		return true, nil // always collect if no program provided.
-	}
-}
-
-func marshalJSON(value commontypes.Val) ([]byte, error) {
-	conv, err := value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
-	if err != nil {
-		return nil, err
-	}
-	jsonData, err := conv.(*structpb.Value).MarshalJSON()
-	if err != nil {
-		return nil, err
 	}
-	return jsonData, nil
+	return activation
 }
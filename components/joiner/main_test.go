@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+// newTestJoiner returns a joiner of the streams a and b
+func newTestJoiner(t *testing.T, cfg config, where, sel string) *joiner {
+	t.Helper()
+	vars := []support.StreamVar{{Name: "a"}, {Name: "b"}}
+	j := &joiner{
+		cfg:          cfg,
+		topics:       []string{"a", "b"},
+		store:        &eventStore{streams: make(map[string][]*support.Event)},
+		streamTarget: &support.Target{StreamParts: []string{"pubsub", "out"}},
+		flushes:      make(chan chan int),
+	}
+	var err error
+	if where != "" {
+		if j.whereProg, err = support.CompileWhereProg(where, 0, vars...); err != nil {
+			t.Fatalf("compile %s: %s", where, err)
+		}
+	}
+	if sel != "" {
+		if j.selectProg, err = support.CompileSelectProg(sel, 0, vars...); err != nil {
+			t.Fatalf("compile %s: %s", sel, err)
+		}
+	}
+	return j
+}
+
+// add decodes the JSON data and stores it as an event of the topic
+func add(t *testing.T, j *joiner, topic string, data ...string) {
+	t.Helper()
+	stage := support.Chain(support.Decode(nil), support.Bind(support.StreamVar{Name: topic}), j.collect)
+	for _, d := range data {
+		e := &support.Event{Data: []byte(d), ContentType: support.ContentTypeJSON, Topic: topic}
+		if _, err := stage(context.Background(), e); err != nil {
+			t.Fatalf("add %s: %s", d, err)
+		}
+	}
+}
+
+// decode decodes the JSON data of the output events
+func decode(t *testing.T, events []*support.Event) []interface{} {
+	t.Helper()
+	var result []interface{}
+	for _, e := range events {
+		var data interface{}
+		if err := json.Unmarshal(e.Data, &data); err != nil {
+			t.Fatalf("output %s: %s", e.Data, err)
+		}
+		result = append(result, data)
+	}
+	return result
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name    string
+		where   string
+		sel     string
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name: "cross join",
+			want: []interface{}{[]interface{}{
+				map[string]interface{}{"a": map[string]interface{}{"id": 1.0}, "b": map[string]interface{}{"id": 1.0, "v": "x"}},
+				map[string]interface{}{"a": map[string]interface{}{"id": 1.0}, "b": map[string]interface{}{"id": 2.0, "v": "y"}},
+				map[string]interface{}{"a": map[string]interface{}{"id": 2.0}, "b": map[string]interface{}{"id": 1.0, "v": "x"}},
+				map[string]interface{}{"a": map[string]interface{}{"id": 2.0}, "b": map[string]interface{}{"id": 2.0, "v": "y"}},
+			}},
+		},
+		{
+			name:  "where and select",
+			where: "a.id == b.id",
+			sel:   "{'id': a.id, 'v': b.v}",
+			want: []interface{}{[]interface{}{
+				map[string]interface{}{"id": 1.0, "v": "x"},
+				map[string]interface{}{"id": 2.0, "v": "y"},
+			}},
+		},
+		{
+			name:  "select list",
+			where: "a.id == 1.0 && b.id == 1.0",
+			sel:   "[{'id': a.id}, {'v': b.v}]",
+			want:  []interface{}{[]interface{}{map[string]interface{}{"id": 1.0}, map[string]interface{}{"v": "x"}}},
+		},
+		{name: "no match", where: "a.id > 10.0", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := newTestJoiner(t, config{EmitMode: "batch"}, test.where, test.sel)
+			add(t, j, "a", `{"id":1}`, `{"id":2}`)
+			add(t, j, "b", `{"id":1,"v":"x"}`, `{"id":2,"v":"y"}`)
+			events, err := j.closeWindow(time.Now(), time.Now())
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decode(t, events); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEmitModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		maxBatch int
+		want     []int // number of joined results in each output, 0 for a single result
+	}{
+		{name: "batch", mode: "batch", want: []int{4}},
+		{name: "batch with max size", mode: "batch", maxBatch: 3, want: []int{3, 1}},
+		{name: "each", mode: "each", want: []int{0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := newTestJoiner(t, config{EmitMode: test.mode, EmitMaxBatchSize: test.maxBatch}, "", "{'a': a.id, 'b': b.id}")
+			add(t, j, "a", `{"id":1}`, `{"id":2}`)
+			add(t, j, "b", `{"id":3}`, `{"id":4}`)
+			events, err := j.closeWindow(time.Now(), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := decode(t, events)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d outputs, want %d", len(got), len(test.want))
+			}
+			for i, out := range got {
+				list, isList := out.([]interface{})
+				if test.want[i] == 0 && isList {
+					t.Errorf("output %d: got list %v, want single result", i, out)
+				}
+				if test.want[i] > 0 && len(list) != test.want[i] {
+					t.Errorf("output %d: got %v, want %d results", i, out, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCloseWindow(t *testing.T) {
+	j := newTestJoiner(t, config{EmitMode: "batch"}, "", "")
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+
+	// a stream without events: the window is empty and events are kept
+	add(t, j, "a", `{"id":1}`)
+	if _, err := j.closeWindow(start, end); err == nil {
+		t.Fatal("expected error for empty stream")
+	}
+	if len(j.store.streams["a"]) != 1 {
+		t.Fatalf("got %d events in stream a, want 1 carried over", len(j.store.streams["a"]))
+	}
+
+	add(t, j, "b", `{"id":2}`)
+	events, err := j.closeWindow(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Metadata["windowStart"] != "2022-01-01T00:00:00Z" || e.Metadata["windowEnd"] != "2022-01-01T00:01:00Z" {
+		t.Errorf("got metadata %v", e.Metadata)
+	}
+	if e.Target != j.streamTarget || e.ContentType != support.ContentTypeJSON {
+		t.Errorf("got target %s (%s)", e.Target, e.ContentType)
+	}
+	if len(j.store.streams["a"]) != 0 || len(j.store.streams["b"]) != 0 {
+		t.Error("store not reset after the window closed")
+	}
+}
+
+func TestWindowFlush(t *testing.T) {
+	// a window longer than the test: the results are only sent when flushed
+	j := newTestJoiner(t, config{EmitMode: "each", Window: time.Hour}, "", "")
+	var sent []*support.Event
+	sink := support.SinkFunc(func(_ context.Context, e *support.Event) error {
+		sent = append(sent, e)
+		return nil
+	})
+	pipeline := support.NewPipeline("test", sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.runWindow(ctx, pipeline)
+		close(done)
+	}()
+
+	// flush request
+	add(t, j, "a", `{"id":1}`)
+	add(t, j, "b", `{"id":2}`, `{"id":3}`)
+	out, err := j.flushHandler()(context.Background(), &common.InvocationEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Data) != `{"flushed":2}` || len(sent) != 2 {
+		t.Fatalf("got %s, %d events sent, want 2 flushed", out.Data, len(sent))
+	}
+
+	// shutdown
+	add(t, j, "a", `{"id":4}`)
+	add(t, j, "b", `{"id":5}`)
+	cancel()
+	<-done
+	if len(sent) != 3 {
+		t.Fatalf("got %d events sent, want 1 flushed on shutdown", len(sent)-2)
+	}
+	if len(j.store.streams["a"]) != 0 || len(j.store.streams["b"]) != 0 {
+		t.Error("store not reset after the window was flushed")
+	}
+}
+
+func TestWindowFlushKeptEvents(t *testing.T) {
+	j := newTestJoiner(t, config{EmitMode: "each", Window: time.Hour}, "", "")
+	var sent []*support.Event
+	sink := support.SinkFunc(func(_ context.Context, e *support.Event) error {
+		sent = append(sent, e)
+		return nil
+	})
+	pipeline := support.NewPipeline("test", sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := time.Now()
+	go j.runWindow(ctx, pipeline)
+	flush := func(want string) {
+		t.Helper()
+		if out, err := j.flushHandler()(context.Background(), &common.InvocationEvent{}); err != nil || string(out.Data) != want {
+			t.Fatalf("got %v (%v), want %s", out, err, want)
+		}
+	}
+
+	// streams without events: the window is not flushed and keeps its start
+	flush(`{"flushed":0}`)
+	flushed := time.Now()
+	add(t, j, "a", `{"id":1}`)
+	flush(`{"flushed":0}`)
+	add(t, j, "b", `{"id":2}`)
+	flush(`{"flushed":1}`)
+	windowStart, err := time.Parse(time.RFC3339Nano, sent[0].Metadata["windowStart"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windowStart.Before(started) || !windowStart.Before(flushed) {
+		t.Errorf("got window start %s, want the start of the window of the kept events, in [%s, %s)", windowStart, started, flushed)
+	}
+}
+
+func TestWindowBoundary(t *testing.T) {
+	first := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		tick time.Duration // after first
+		want time.Duration // after first
+	}{
+		{name: "tick", tick: time.Minute + time.Millisecond, want: time.Minute},
+		{name: "late tick", tick: 2*time.Minute + 30*time.Second, want: 2 * time.Minute},
+		{name: "tick on the boundary", tick: 3 * time.Minute, want: 3 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := windowBoundary(first, time.Minute, first.Add(test.tick))
+			if want := first.Add(test.want); !got.Equal(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPartitionedJoin(t *testing.T) {
+	// two replicas receive a share of the events of each stream: events are
+	// forwarded to the replica owning the partition of their key
+	replicas := make([]*joiner, 2)
+	stages := make([]map[string]support.Stage, 2)
+	for i := range replicas {
+		replicas[i] = newTestJoiner(t, config{EmitMode: "each"}, "a.id == b.id", "{'id': a.id, 'v': b.v}")
+	}
+	for i, j := range replicas {
+		partitioner := &support.Partitioner{Partitions: 2, Self: i, Forwarder: support.ForwarderFunc(func(ctx context.Context, partition int, e *support.Event) error {
+			e.Metadata = map[string]string{support.ForwardedMetadata: "true"}
+			_, err := stages[partition][e.Topic](ctx, e)
+			return err
+		})}
+		stages[i] = make(map[string]support.Stage)
+		for _, topic := range j.topics {
+			stream := support.StreamVar{Name: topic}
+			keyProg, err := support.CompileKeyProg(topic+".id", 0, stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stages[i][topic] = support.Chain(support.Decode(nil), support.Bind(stream), support.Partition(keyProg, partitioner), j.collect)
+		}
+	}
+
+	send := func(replica int, topic, data string) {
+		e := &support.Event{Data: []byte(data), ContentType: support.ContentTypeJSON, Topic: topic}
+		if _, err := stages[replica][topic](context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 0; id < 10; id++ {
+		// events of a key are received by different replicas
+		send(id%2, "a", fmt.Sprintf(`{"id":%d}`, id))
+		send((id+1)%2, "b", fmt.Sprintf(`{"id":%d,"v":"v%d"}`, id, id))
+	}
+
+	got := make(map[float64]bool)
+	for i, j := range replicas {
+		events, err := j.closeWindow(time.Now(), time.Now())
+		if err != nil {
+			t.Fatalf("replica %d: %s", i, err)
+		}
+		for _, out := range decode(t, events) {
+			result := out.(map[string]interface{})
+			id := result["id"].(float64)
+			if result["v"] != fmt.Sprintf("v%d", int(id)) || got[id] {
+				t.Errorf("replica %d: unexpected result %v", i, result)
+			}
+			got[id] = true
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("got %d joined ids, want 10", len(got))
+	}
+}
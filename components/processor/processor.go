@@ -0,0 +1,289 @@
+// Package processor is the SDK used to write Processor components: user functions
+// receiving the events of a stream and returning the events forwarded downstream.
+//
+// A processor registers a Func and runs it with Run:
+//
+//	func main() {
+//		err := processor.Run(func(ctx context.Context, e processor.Event) ([]processor.Event, error) {
+//			return []processor.Event{e}, nil
+//		})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// The package serves the route configured by the Processor controller, decodes the
+// received events, forwards the results to the processor target (a stream and/or
+// a component), reports metrics and shuts down gracefully on SIGINT or SIGTERM.
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+// Event is an event received or emitted by a processor function
+type Event struct {
+	Data        []byte
+	ContentType string
+	// Value is the decoded data of received events. Emitted events without
+	// Data have their Value encoded for the target (JSON by default).
+	Value    map[string]interface{}
+	Metadata map[string]string
+}
+
+// Func processes an event and returns the events to forward to the processor target
+type Func func(ctx context.Context, e Event) ([]Event, error)
+
+// config is the processor configuration, set by the Processor controller
+type config struct {
+	ServicePort  string `env:"PROC_SERVICE_PORT" default:":8080"` // service port
+	ServiceRoute string `env:"PROC_SERVICE_ROUTE"`                // route invoked with events (default APP_ID)
+	Target       string `env:"PROC_TARGET"`                       // component/route where results are sent, if PROC_TARGET_{STREAM,COMPONENT} are not set
+	MetricsPort  string `env:"PROC_METRICS_PORT" default:":9090"` // port serving /metrics, disabled if "-"
+}
+
+// Processor runs a Func on the events received by the service
+type Processor struct {
+	fn      Func
+	codec   support.Codec
+	sink    support.Sink
+	target  *support.Target
+	metrics *metrics
+}
+
+// New returns a processor running fn. Results are sent to the target with the
+// client, or returned to the caller if target is nil. Events are decoded with
+// codec, or according to their content type if codec is nil.
+func New(fn Func, client support.Dispatcher, target *support.Target, codec support.Codec) *Processor {
+	return &Processor{
+		fn:      fn,
+		codec:   codec,
+		sink:    support.TargetSink(client, target),
+		target:  target,
+		metrics: newMetrics(),
+	}
+}
+
+// Run runs fn as a processor configured by the environment (see the Processor
+// controller) and blocks until the process is stopped.
+func Run(fn Func) error {
+	var cfg config
+	if err := support.LoadConfig(&cfg); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+	if cfg.ServiceRoute == "" {
+		cfg.ServiceRoute = os.Getenv("APP_ID")
+	}
+	if cfg.ServiceRoute == "" {
+		return fmt.Errorf("processor: env PROC_SERVICE_ROUTE or APP_ID must be provided")
+	}
+	codec, err := support.CodecFromEnv("PROC")
+	if err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+	target, err := support.TargetFromEnv("PROC_TARGET")
+	if err != nil {
+		return fmt.Errorf("processor: target: %s", err)
+	}
+	if target == nil && cfg.Target != "" {
+		if target, err = support.NewTarget("", cfg.Target, nil); err != nil {
+			return fmt.Errorf("processor: target: %s", err)
+		}
+	}
+	log.Printf("processor: service-port: %s, route: %s, target: %v", cfg.ServicePort, cfg.ServiceRoute, target)
+
+	rt, err := support.NewRuntime("processor", cfg.ServicePort)
+	if err != nil {
+		return err
+	}
+	p := New(fn, rt.Client, target, codec)
+	if err := rt.Service.AddServiceInvocationHandler(cfg.ServiceRoute, p.InvocationHandler()); err != nil {
+		return fmt.Errorf("processor: invocation handler: %s", err)
+	}
+	if cfg.MetricsPort != "-" {
+		rt.Go(func(ctx context.Context) {
+			p.serveMetrics(ctx, cfg.MetricsPort)
+		})
+	}
+	return rt.Run()
+}
+
+// InvocationHandler returns the handler processing service invocations
+func (p *Processor) InvocationHandler() common.ServiceInvocationHandler {
+	return func(ctx context.Context, in *common.InvocationEvent) (*common.Content, error) {
+		if in == nil {
+			return nil, fmt.Errorf("invocation parameter required")
+		}
+		return p.Handle(ctx, support.NewInvocationEvent(in))
+	}
+}
+
+// Handle processes the event and forwards the results to the target. If the
+// processor has no target, the results are returned as content: a single result
+// as is, several results as a JSON array.
+func (p *Processor) Handle(ctx context.Context, in *support.Event) (*common.Content, error) {
+	p.metrics.received.Inc()
+	start := time.Now()
+	defer func() {
+		p.metrics.duration.Observe(time.Since(start).Seconds())
+	}()
+
+	event, err := p.decode(ctx, in)
+	if err != nil {
+		p.metrics.errors.WithLabelValues("decode").Inc()
+		return nil, fmt.Errorf("processor: %s", err)
+	}
+	results, err := p.fn(ctx, event)
+	if err != nil {
+		p.metrics.errors.WithLabelValues("process").Inc()
+		return nil, fmt.Errorf("processor: %s", err)
+	}
+	outputs, err := p.encode(results)
+	if err != nil {
+		p.metrics.errors.WithLabelValues("encode").Inc()
+		return nil, fmt.Errorf("processor: %s", err)
+	}
+
+	if p.target == nil {
+		return p.respond(outputs)
+	}
+	for _, out := range outputs {
+		if err := p.sink.Send(ctx, out); err != nil {
+			p.metrics.errors.WithLabelValues("forward").Inc()
+			return nil, fmt.Errorf("processor: forward: %s", err)
+		}
+		p.metrics.emitted.Inc()
+	}
+	return nil, nil
+}
+
+// decode returns the event passed to the processor function. Data with a
+// content type not supported by the codecs is passed as is, without value.
+func (p *Processor) decode(ctx context.Context, in *support.Event) (Event, error) {
+	event := Event{Data: in.Data, ContentType: in.ContentType, Metadata: in.Metadata}
+	if len(in.Data) == 0 {
+		return event, nil
+	}
+	if p.codec == nil && in.ContentType != support.ContentTypeCloudEvent {
+		if _, err := support.NewCodec(support.CodecConfig{ContentType: in.ContentType}); err != nil {
+			return event, nil
+		}
+	}
+	decoded, err := support.Decode(p.codec)(ctx, in)
+	if err != nil {
+		return event, err
+	}
+	event.Value = decoded[0].Value
+	return event, nil
+}
+
+// encode returns the output events of the processor function results
+func (p *Processor) encode(results []Event) ([]*support.Event, error) {
+	var outputs []*support.Event
+	for _, result := range results {
+		out := &support.Event{Data: result.Data, ContentType: result.ContentType, Metadata: result.Metadata}
+		if out.Data == nil && result.Value != nil {
+			target := p.target
+			if target == nil {
+				target = &support.Target{}
+			}
+			data, err := target.Encode(result.Value)
+			if err != nil {
+				return nil, fmt.Errorf("encode result: %s", err)
+			}
+			out.Data, out.ContentType = data, target.ContentType()
+		}
+		if out.ContentType == "" {
+			out.ContentType = support.ContentTypeJSON
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
+
+// respond returns the outputs as the content of the invocation response
+func (p *Processor) respond(outputs []*support.Event) (*common.Content, error) {
+	switch len(outputs) {
+	case 0:
+		return nil, nil
+	case 1:
+		p.metrics.emitted.Inc()
+		return &common.Content{Data: outputs[0].Data, ContentType: outputs[0].ContentType}, nil
+	}
+	list := make([]json.RawMessage, len(outputs))
+	for i, out := range outputs {
+		if !json.Valid(out.Data) {
+			p.metrics.errors.WithLabelValues("encode").Inc()
+			return nil, fmt.Errorf("processor: result %d: several results are returned as a JSON array, got %s", i, out.ContentType)
+		}
+		list[i] = out.Data
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		p.metrics.errors.WithLabelValues("encode").Inc()
+		return nil, fmt.Errorf("processor: %s", err)
+	}
+	p.metrics.emitted.Add(float64(len(outputs)))
+	return &common.Content{Data: data, ContentType: support.ContentTypeJSON}, nil
+}
+
+// serveMetrics serves the processor metrics on port until ctx is done
+func (p *Processor) serveMetrics(ctx context.Context, port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("processor: metrics: %s", err)
+	}
+}
+
+// metrics are the processor metrics
+type metrics struct {
+	registry *prometheus.Registry
+	received prometheus.Counter
+	emitted  prometheus.Counter
+	errors   *prometheus.CounterVec
+	duration prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "processor_events_received_total",
+			Help: "Number of events received by the processor.",
+		}),
+		emitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "processor_events_emitted_total",
+			Help: "Number of events forwarded or returned by the processor.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "processor_errors_total",
+			Help: "Number of events failed by the processor, by stage (decode, process, encode, forward).",
+		}, []string{"stage"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "processor_processing_seconds",
+			Help:    "Time spent processing an event, including forwarding.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	m.registry.MustRegister(m.received, m.emitted, m.errors, m.duration)
+	return m
+}
@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+	"github.com/vladimirvivien/streaming-runtime/components/support/daprtest"
+)
+
+// double emits the event, with its qty doubled, once per copy
+func double(_ context.Context, e Event) ([]Event, error) {
+	if e.Value == nil {
+		return nil, errors.New("no value")
+	}
+	var results []Event
+	copies, _ := e.Value["copies"].(float64)
+	for i := 0; i < int(copies); i++ {
+		results = append(results, Event{Value: map[string]interface{}{"qty": e.Value["qty"].(float64) * 2}})
+	}
+	return results, nil
+}
+
+func TestHandle(t *testing.T) {
+	target := &support.Target{ComponentParts: []string{"next", "orders"}}
+	stream := &support.Target{StreamParts: []string{"pubsub", "orders"}}
+	tests := []struct {
+		name            string
+		target          *support.Target
+		data            string
+		contentType     string
+		wantContent     string
+		wantInvocations []string
+		wantPublished   []string
+		wantErr         bool
+	}{
+		{name: "forward", target: target, data: `{"qty":2,"copies":2}`, wantInvocations: []string{`{"qty":4}`, `{"qty":4}`}},
+		{name: "forward to stream", target: stream, data: `{"qty":2,"copies":1}`, wantPublished: []string{`{"qty":4}`}},
+		{name: "forward nothing", target: target, data: `{"qty":2,"copies":0}`},
+		{name: "respond single result", data: `{"qty":2,"copies":1}`, wantContent: `{"qty":4}`},
+		{name: "respond several results", data: `{"qty":1,"copies":2}`, wantContent: `[{"qty":2},{"qty":2}]`},
+		{
+			name:        "cloudevent",
+			target:      target,
+			data:        `{"specversion":"1.0","id":"1","datacontenttype":"application/json","data":{"qty":3,"copies":1}}`,
+			contentType: support.ContentTypeCloudEvent, wantInvocations: []string{`{"qty":6}`},
+		},
+		{name: "malformed data", data: `{"qty":`, wantErr: true},
+		{name: "no value for unsupported content type", data: `qty`, contentType: "text/plain", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := daprtest.NewClient()
+			p := New(double, client, test.target, nil)
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = support.ContentTypeJSON
+			}
+			content, err := p.Handle(context.Background(), &support.Event{Data: []byte(test.data), ContentType: contentType})
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			if content != nil {
+				got = string(content.Data)
+			}
+			if got != test.wantContent {
+				t.Errorf("got content %s, want %s", got, test.wantContent)
+			}
+			var invoked []string
+			for _, inv := range client.Invocations() {
+				if inv.AppID != "next" || inv.Method != "orders" || inv.ContentType != support.ContentTypeJSON {
+					t.Errorf("invoked %s/%s (%s)", inv.AppID, inv.Method, inv.ContentType)
+				}
+				invoked = append(invoked, string(inv.Data))
+			}
+			if !reflect.DeepEqual(invoked, test.wantInvocations) {
+				t.Errorf("got invocations %v, want %v", invoked, test.wantInvocations)
+			}
+			var published []string
+			for _, pub := range client.Published() {
+				if pub.Pubsub != "pubsub" || pub.Topic != "orders" {
+					t.Errorf("published to %s/%s", pub.Pubsub, pub.Topic)
+				}
+				published = append(published, string(pub.Data))
+			}
+			if !reflect.DeepEqual(published, test.wantPublished) {
+				t.Errorf("got published %v, want %v", published, test.wantPublished)
+			}
+		})
+	}
+}
+
+func TestHandleRawData(t *testing.T) {
+	upper := func(_ context.Context, e Event) ([]Event, error) {
+		return []Event{{Data: []byte(strings.ToUpper(string(e.Data))), ContentType: e.ContentType}}, nil
+	}
+	p := New(upper, daprtest.NewClient(), nil, nil)
+	content, err := p.Handle(context.Background(), &support.Event{Data: []byte("abc"), ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content.Data) != "ABC" || content.ContentType != "text/plain" {
+		t.Errorf("got %s (%s)", content.Data, content.ContentType)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	client := daprtest.NewClient()
+	p := New(double, client, &support.Target{StreamParts: []string{"pubsub", "out"}}, nil)
+	ctx := context.Background()
+	for _, data := range []string{`{"qty":1,"copies":2}`, `{"qty":`, `{"qty":1,"copies":1}`} {
+		p.Handle(ctx, &support.Event{Data: []byte(data), ContentType: support.ContentTypeJSON})
+	}
+	client.Err = errors.New("unavailable")
+	p.Handle(ctx, &support.Event{Data: []byte(`{"qty":1,"copies":1}`), ContentType: support.ContentTypeJSON})
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "received", got: testutil.ToFloat64(p.metrics.received), want: 4},
+		{name: "emitted", got: testutil.ToFloat64(p.metrics.emitted), want: 3},
+		{name: "decode errors", got: testutil.ToFloat64(p.metrics.errors.WithLabelValues("decode")), want: 1},
+		{name: "forward errors", got: testutil.ToFloat64(p.metrics.errors.WithLabelValues("forward")), want: 1},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestInvocationHandler(t *testing.T) {
+	client := daprtest.NewClient()
+	p := New(double, client, nil, nil)
+	if err := client.AddServiceInvocationHandler("orders", p.InvocationHandler()); err != nil {
+		t.Fatal(err)
+	}
+	content := &dapr.DataContent{Data: []byte(`{"qty":5,"copies":1}`), ContentType: support.ContentTypeJSON}
+	out, err := client.InvokeMethodWithContent(context.Background(), "proc", "orders", http.MethodPost, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"qty":10}` {
+		t.Errorf("got %s", out)
+	}
+	if _, err := p.InvocationHandler()(context.Background(), nil); err == nil {
+		t.Error("expected error for nil invocation")
+	}
+}
@@ -0,0 +1,795 @@
+package support
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter/functions"
+	"github.com/google/uuid"
+	"github.com/spaolacci/murmur3"
+	exprv1alpha1 "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// CELLibVersion is the latest version of the stream function library.
+//
+// Functions are only added in new versions and their behavior is frozen once
+// released, so expressions pinned to a version evaluate the same way across
+// component upgrades.
+//
+// Version 1:
+//   - strings: charAt, indexOf, lastIndexOf, lowerAscii, upperAscii, replace, split, substring, trim
+//   - encoders: base64.encode(bytes), base64.decode(string)
+//   - math.abs(int|double), math.ceil(double), math.floor(double), math.round(double),
+//     math.pow(double, double), math.sqrt(double), math.min(list), math.max(list)
+//   - time.now(), time.parse(string, layout), time.format(timestamp, layout), time.unix(int),
+//     time.formatDuration(duration)
+//   - json.parse(string), json.stringify(dyn)
+//   - hash.sha256(string|bytes) (hex string), hash.murmur3(string|bytes) (32-bit int)
+//   - uuid.new()
+//   - regex.extract(string, pattern), regex.extractAll(string, pattern)
+//   - getOrDefault(dyn, path, default): resolves a dot-separated path, returns default if not set
+const CELLibVersion = 1
+
+// StreamLib returns the stream function library at the specified version.
+// A version of 0 selects the latest version.
+func StreamLib(version int) (cel.EnvOption, error) {
+	if version == 0 {
+		version = CELLibVersion
+	}
+	if version < 0 || version > CELLibVersion {
+		return nil, fmt.Errorf("cel library: unsupported version %d (latest is %d)", version, CELLibVersion)
+	}
+	return cel.Lib(&streamLib{version: version}), nil
+}
+
+// libFunction is a library function available since a library version
+type libFunction struct {
+	since int
+	decl  *exprv1alpha1.Decl
+	impl  *functions.Overload
+}
+
+// The string and encoder functions have the declarations and the behavior of the
+// cel-go extensions (ext.Strings and ext.Encoders at cel-go v0.9.0), implemented by
+// the library so that cel-go upgrades do not change them.
+var libFunctions = []libFunction{
+	// strings
+	{
+		since: 1,
+		decl: decls.NewFunction("charAt",
+			decls.NewInstanceOverload("string_char_at_int", []*exprv1alpha1.Type{decls.String, decls.Int}, decls.String)),
+		impl: &functions.Overload{Operator: "charAt", Binary: stringsCharAt},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("indexOf",
+			decls.NewInstanceOverload("string_index_of_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.Int),
+			decls.NewInstanceOverload("string_index_of_string_int", []*exprv1alpha1.Type{decls.String, decls.String, decls.Int}, decls.Int)),
+		impl: &functions.Overload{Operator: "indexOf", Binary: binaryOf(stringsIndexOf), Function: stringsIndexOf},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("lastIndexOf",
+			decls.NewInstanceOverload("string_last_index_of_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.Int),
+			decls.NewInstanceOverload("string_last_index_of_string_int", []*exprv1alpha1.Type{decls.String, decls.String, decls.Int}, decls.Int)),
+		impl: &functions.Overload{Operator: "lastIndexOf", Binary: binaryOf(stringsLastIndexOf), Function: stringsLastIndexOf},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("lowerAscii",
+			decls.NewInstanceOverload("string_lower_ascii", []*exprv1alpha1.Type{decls.String}, decls.String)),
+		impl: &functions.Overload{Operator: "lowerAscii", Unary: stringFunc(func(s string) string { return mapASCII(s, unicode.ToLower) })},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("upperAscii",
+			decls.NewInstanceOverload("string_upper_ascii", []*exprv1alpha1.Type{decls.String}, decls.String)),
+		impl: &functions.Overload{Operator: "upperAscii", Unary: stringFunc(func(s string) string { return mapASCII(s, unicode.ToUpper) })},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("replace",
+			decls.NewInstanceOverload("string_replace_string_string", []*exprv1alpha1.Type{decls.String, decls.String, decls.String}, decls.String),
+			decls.NewInstanceOverload("string_replace_string_string_int", []*exprv1alpha1.Type{decls.String, decls.String, decls.String, decls.Int}, decls.String)),
+		impl: &functions.Overload{Operator: "replace", Function: stringsReplace},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("split",
+			decls.NewInstanceOverload("string_split_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.NewListType(decls.String)),
+			decls.NewInstanceOverload("string_split_string_int", []*exprv1alpha1.Type{decls.String, decls.String, decls.Int}, decls.NewListType(decls.String))),
+		impl: &functions.Overload{Operator: "split", Binary: binaryOf(stringsSplit), Function: stringsSplit},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("substring",
+			decls.NewInstanceOverload("string_substring_int", []*exprv1alpha1.Type{decls.String, decls.Int}, decls.String),
+			decls.NewInstanceOverload("string_substring_int_int", []*exprv1alpha1.Type{decls.String, decls.Int, decls.Int}, decls.String)),
+		impl: &functions.Overload{Operator: "substring", Binary: binaryOf(stringsSubstring), Function: stringsSubstring},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("trim", decls.NewInstanceOverload("string_trim", []*exprv1alpha1.Type{decls.String}, decls.String)),
+		impl:  &functions.Overload{Operator: "trim", Unary: stringFunc(strings.TrimSpace)},
+	},
+
+	// encoders
+	{
+		since: 1,
+		decl:  decls.NewFunction("base64.encode", decls.NewOverload("base64_encode_bytes", []*exprv1alpha1.Type{decls.Bytes}, decls.String)),
+		impl:  &functions.Overload{Operator: "base64.encode", Unary: base64Encode},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("base64.decode", decls.NewOverload("base64_decode_string", []*exprv1alpha1.Type{decls.String}, decls.Bytes)),
+		impl:  &functions.Overload{Operator: "base64.decode", Unary: base64Decode},
+	},
+
+	// math
+	{
+		since: 1,
+		decl: decls.NewFunction("math.abs",
+			decls.NewOverload("math_abs_int", []*exprv1alpha1.Type{decls.Int}, decls.Int),
+			decls.NewOverload("math_abs_double", []*exprv1alpha1.Type{decls.Double}, decls.Double)),
+		impl: &functions.Overload{Operator: "math.abs", Unary: mathAbs},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.ceil", decls.NewOverload("math_ceil_double", []*exprv1alpha1.Type{decls.Double}, decls.Double)),
+		impl:  &functions.Overload{Operator: "math.ceil", Unary: doubleFunc(math.Ceil)},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.floor", decls.NewOverload("math_floor_double", []*exprv1alpha1.Type{decls.Double}, decls.Double)),
+		impl:  &functions.Overload{Operator: "math.floor", Unary: doubleFunc(math.Floor)},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.round", decls.NewOverload("math_round_double", []*exprv1alpha1.Type{decls.Double}, decls.Double)),
+		impl:  &functions.Overload{Operator: "math.round", Unary: doubleFunc(math.Round)},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.sqrt", decls.NewOverload("math_sqrt_double", []*exprv1alpha1.Type{decls.Double}, decls.Double)),
+		impl:  &functions.Overload{Operator: "math.sqrt", Unary: doubleFunc(math.Sqrt)},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("math.pow",
+			decls.NewOverload("math_pow_double_double", []*exprv1alpha1.Type{decls.Double, decls.Double}, decls.Double)),
+		impl: &functions.Overload{Operator: "math.pow", Binary: mathPow},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.min", decls.NewOverload("math_min_list", []*exprv1alpha1.Type{decls.NewListType(decls.Dyn)}, decls.Dyn)),
+		impl:  &functions.Overload{Operator: "math.min", Unary: mathBound(types.IntNegOne)},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("math.max", decls.NewOverload("math_max_list", []*exprv1alpha1.Type{decls.NewListType(decls.Dyn)}, decls.Dyn)),
+		impl:  &functions.Overload{Operator: "math.max", Unary: mathBound(types.IntOne)},
+	},
+
+	// time
+	{
+		since: 1,
+		decl:  decls.NewFunction("time.now", decls.NewOverload("time_now", []*exprv1alpha1.Type{}, decls.Timestamp)),
+		impl:  &functions.Overload{Operator: "time.now", Function: timeNow},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("time.parse",
+			decls.NewOverload("time_parse_string_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.Timestamp)),
+		impl: &functions.Overload{Operator: "time.parse", Binary: timeParse},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("time.format",
+			decls.NewOverload("time_format_timestamp_string", []*exprv1alpha1.Type{decls.Timestamp, decls.String}, decls.String)),
+		impl: &functions.Overload{Operator: "time.format", Binary: timeFormat},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("time.unix", decls.NewOverload("time_unix_int", []*exprv1alpha1.Type{decls.Int}, decls.Timestamp)),
+		impl:  &functions.Overload{Operator: "time.unix", Unary: timeUnix},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("time.formatDuration",
+			decls.NewOverload("time_format_duration", []*exprv1alpha1.Type{decls.Duration}, decls.String)),
+		impl: &functions.Overload{Operator: "time.formatDuration", Unary: timeFormatDuration},
+	},
+
+	// json
+	{
+		since: 1,
+		decl:  decls.NewFunction("json.parse", decls.NewOverload("json_parse_string", []*exprv1alpha1.Type{decls.String}, decls.Dyn)),
+		impl:  &functions.Overload{Operator: "json.parse", Unary: jsonParse},
+	},
+	{
+		since: 1,
+		decl:  decls.NewFunction("json.stringify", decls.NewOverload("json_stringify_dyn", []*exprv1alpha1.Type{decls.Dyn}, decls.String)),
+		impl:  &functions.Overload{Operator: "json.stringify", Unary: jsonStringify},
+	},
+
+	// hashing
+	{
+		since: 1,
+		decl: decls.NewFunction("hash.sha256",
+			decls.NewOverload("hash_sha256_string", []*exprv1alpha1.Type{decls.String}, decls.String),
+			decls.NewOverload("hash_sha256_bytes", []*exprv1alpha1.Type{decls.Bytes}, decls.String)),
+		impl: &functions.Overload{Operator: "hash.sha256", Unary: hashSHA256},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("hash.murmur3",
+			decls.NewOverload("hash_murmur3_string", []*exprv1alpha1.Type{decls.String}, decls.Int),
+			decls.NewOverload("hash_murmur3_bytes", []*exprv1alpha1.Type{decls.Bytes}, decls.Int)),
+		impl: &functions.Overload{Operator: "hash.murmur3", Unary: hashMurmur3},
+	},
+
+	// uuid
+	{
+		since: 1,
+		decl:  decls.NewFunction("uuid.new", decls.NewOverload("uuid_new", []*exprv1alpha1.Type{}, decls.String)),
+		impl:  &functions.Overload{Operator: "uuid.new", Function: uuidNew},
+	},
+
+	// regex
+	{
+		since: 1,
+		decl: decls.NewFunction("regex.extract",
+			decls.NewOverload("regex_extract_string_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.String)),
+		impl: &functions.Overload{Operator: "regex.extract", Binary: regexExtract},
+	},
+	{
+		since: 1,
+		decl: decls.NewFunction("regex.extractAll",
+			decls.NewOverload("regex_extract_all_string_string", []*exprv1alpha1.Type{decls.String, decls.String}, decls.NewListType(decls.String))),
+		impl: &functions.Overload{Operator: "regex.extractAll", Binary: regexExtractAll},
+	},
+
+	// safe navigation
+	{
+		since: 1,
+		decl: decls.NewFunction("getOrDefault",
+			decls.NewOverload("get_or_default_dyn_string_dyn", []*exprv1alpha1.Type{decls.Dyn, decls.String, decls.Dyn}, decls.Dyn)),
+		impl: &functions.Overload{Operator: "getOrDefault", Function: getOrDefault},
+	},
+}
+
+type streamLib struct {
+	version int
+}
+
+func (l *streamLib) CompileOptions() []cel.EnvOption {
+	var fnDecls []*exprv1alpha1.Decl
+	for _, fn := range libFunctions {
+		if fn.since <= l.version {
+			fnDecls = append(fnDecls, fn.decl)
+		}
+	}
+	return []cel.EnvOption{cel.Declarations(fnDecls...)}
+}
+
+func (l *streamLib) ProgramOptions() []cel.ProgramOption {
+	var impls []*functions.Overload
+	for _, fn := range libFunctions {
+		if fn.since <= l.version {
+			impls = append(impls, fn.impl)
+		}
+	}
+	return []cel.ProgramOption{cel.Functions(impls...)}
+}
+
+// binaryOf adapts a function of variable arguments to a binary function
+func binaryOf(fn functions.FunctionOp) functions.BinaryOp {
+	return func(lhs, rhs ref.Val) ref.Val {
+		return fn(lhs, rhs)
+	}
+}
+
+// stringArgs converts the arguments of a string function: count strings followed by ints
+func stringArgs(values []ref.Val, count int) ([]string, []int64, ref.Val) {
+	var strs []string
+	var ints []int64
+	for i, value := range values {
+		if i < count {
+			s, ok := value.(types.String)
+			if !ok {
+				return nil, nil, types.MaybeNoSuchOverloadErr(value)
+			}
+			strs = append(strs, string(s))
+			continue
+		}
+		n, ok := value.(types.Int)
+		if !ok {
+			return nil, nil, types.MaybeNoSuchOverloadErr(value)
+		}
+		ints = append(ints, int64(n))
+	}
+	if len(strs) < count {
+		return nil, nil, types.NoSuchOverloadErr()
+	}
+	return strs, ints, nil
+}
+
+func stringFunc(fn func(string) string) functions.UnaryOp {
+	return func(val ref.Val) ref.Val {
+		s, ok := val.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(val)
+		}
+		return types.String(fn(string(s)))
+	}
+}
+
+// mapASCII maps the ASCII characters of a string, other characters are unchanged
+func mapASCII(s string, mapping func(rune) rune) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if r <= unicode.MaxASCII {
+			runes[i] = mapping(r)
+		}
+	}
+	return string(runes)
+}
+
+func stringsCharAt(str, index ref.Val) ref.Val {
+	strs, ints, err := stringArgs([]ref.Val{str, index}, 1)
+	if err != nil {
+		return err
+	}
+	runes, i := []rune(strs[0]), ints[0]
+	if i < 0 || i > int64(len(runes)) {
+		return types.NewErr("index out of range: %d", i)
+	}
+	if i == int64(len(runes)) {
+		return types.String("")
+	}
+	return types.String(runes[i])
+}
+
+// stringsIndexOf returns the index of the first occurrence of a substring, from an
+// optional offset, or -1
+func stringsIndexOf(values ...ref.Val) ref.Val {
+	strs, ints, err := stringArgs(values, 2)
+	if err != nil {
+		return err
+	}
+	if len(ints) > 1 {
+		return types.NoSuchOverloadErr()
+	}
+	var offset int64
+	if len(ints) == 1 {
+		offset = ints[0]
+	}
+	if strs[1] == "" {
+		return types.Int(offset)
+	}
+	runes, sub := []rune(strs[0]), []rune(strs[1])
+	if offset < 0 || offset >= int64(len(runes)) {
+		return types.NewErr("index out of range: %d", offset)
+	}
+	for i := int(offset); i < len(runes)-(len(sub)-1); i++ {
+		if runesAt(runes, sub, i) {
+			return types.Int(i)
+		}
+	}
+	return types.IntNegOne
+}
+
+// stringsLastIndexOf returns the index of the last occurrence of a substring, before an
+// optional offset, or -1
+func stringsLastIndexOf(values ...ref.Val) ref.Val {
+	strs, ints, err := stringArgs(values, 2)
+	if err != nil {
+		return err
+	}
+	if len(ints) > 1 {
+		return types.NoSuchOverloadErr()
+	}
+	runes, sub := []rune(strs[0]), []rune(strs[1])
+	offset := int64(len(runes) - 1)
+	if len(ints) == 1 {
+		offset = ints[0]
+	} else if len(sub) == 0 {
+		return types.Int(len(runes))
+	}
+	if len(sub) == 0 {
+		return types.Int(offset)
+	}
+	if offset < 0 || offset >= int64(len(runes)) {
+		return types.NewErr("index out of range: %d", offset)
+	}
+	i := int(offset)
+	if i > len(runes)-len(sub) {
+		i = len(runes) - len(sub)
+	}
+	for ; i >= 0; i-- {
+		if runesAt(runes, sub, i) {
+			return types.Int(i)
+		}
+	}
+	return types.IntNegOne
+}
+
+// runesAt returns whether sub occurs in runes at index i
+func runesAt(runes, sub []rune, i int) bool {
+	for j := range sub {
+		if runes[i+j] != sub[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// stringsReplace replaces all occurrences of a string, or the first n with an int argument
+func stringsReplace(values ...ref.Val) ref.Val {
+	strs, ints, err := stringArgs(values, 3)
+	if err != nil {
+		return err
+	}
+	switch len(ints) {
+	case 0:
+		return types.String(strings.ReplaceAll(strs[0], strs[1], strs[2]))
+	case 1:
+		return types.String(strings.Replace(strs[0], strs[1], strs[2], int(ints[0])))
+	}
+	return types.NoSuchOverloadErr()
+}
+
+// stringsSplit splits a string around a separator, in at most n parts with an int argument
+func stringsSplit(values ...ref.Val) ref.Val {
+	strs, ints, err := stringArgs(values, 2)
+	if err != nil {
+		return err
+	}
+	var parts []string
+	switch len(ints) {
+	case 0:
+		parts = strings.Split(strs[0], strs[1])
+	case 1:
+		parts = strings.SplitN(strs[0], strs[1], int(ints[0]))
+	default:
+		return types.NoSuchOverloadErr()
+	}
+	return types.DefaultTypeAdapter.NativeToValue(parts)
+}
+
+// stringsSubstring returns the substring from a start index, to an optional end index
+func stringsSubstring(values ...ref.Val) ref.Val {
+	strs, ints, err := stringArgs(values, 1)
+	if err != nil {
+		return err
+	}
+	runes := []rune(strs[0])
+	if len(ints) == 0 || len(ints) > 2 {
+		return types.NoSuchOverloadErr()
+	}
+	start, end := ints[0], int64(len(runes))
+	if len(ints) == 2 {
+		end = ints[1]
+		if start > end {
+			return types.NewErr("invalid substring range. start: %d, end: %d", start, end)
+		}
+	}
+	if start < 0 || start > int64(len(runes)) {
+		return types.NewErr("index out of range: %d", start)
+	}
+	if end < 0 || end > int64(len(runes)) {
+		return types.NewErr("index out of range: %d", end)
+	}
+	return types.String(runes[start:end])
+}
+
+func base64Encode(val ref.Val) ref.Val {
+	b, ok := val.(types.Bytes)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	return types.String(base64.StdEncoding.EncodeToString(b))
+}
+
+func base64Decode(val ref.Val) ref.Val {
+	s, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(s))
+	if err != nil {
+		return types.NewErr("%s", err)
+	}
+	return types.Bytes(b)
+}
+
+func mathAbs(val ref.Val) ref.Val {
+	switch v := val.(type) {
+	case types.Int:
+		if v < 0 {
+			return -v
+		}
+		return v
+	case types.Double:
+		return types.Double(math.Abs(float64(v)))
+	}
+	return types.MaybeNoSuchOverloadErr(val)
+}
+
+func doubleFunc(fn func(float64) float64) functions.UnaryOp {
+	return func(val ref.Val) ref.Val {
+		v, ok := val.(types.Double)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(val)
+		}
+		return types.Double(fn(float64(v)))
+	}
+}
+
+func mathPow(base, exp ref.Val) ref.Val {
+	b, ok := base.(types.Double)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(base)
+	}
+	e, ok := exp.(types.Double)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(exp)
+	}
+	return types.Double(math.Pow(float64(b), float64(e)))
+}
+
+// mathBound returns the element of a list that compares to all others with the provided order
+func mathBound(order types.Int) functions.UnaryOp {
+	return func(val ref.Val) ref.Val {
+		list, ok := val.(traits.Lister)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(val)
+		}
+		if list.Size() == types.IntZero {
+			return types.NewErr("math: empty list")
+		}
+		var bound ref.Val
+		it := list.Iterator()
+		for it.HasNext() == types.True {
+			elem := it.Next()
+			if bound == nil {
+				bound = elem
+				continue
+			}
+			cmp, ok := elem.(traits.Comparer)
+			if !ok {
+				return types.MaybeNoSuchOverloadErr(elem)
+			}
+			result := cmp.Compare(bound)
+			if types.IsUnknownOrError(result) {
+				return result
+			}
+			if result == order {
+				bound = elem
+			}
+		}
+		return bound
+	}
+}
+
+func timeNow(_ ...ref.Val) ref.Val {
+	return types.Timestamp{Time: time.Now().UTC()}
+}
+
+func timeParse(value, layout ref.Val) ref.Val {
+	v, ok := value.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	l, ok := layout.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(layout)
+	}
+	t, err := time.Parse(string(l), string(v))
+	if err != nil {
+		return types.NewErr("time.parse: %s", err)
+	}
+	return types.Timestamp{Time: t}
+}
+
+func timeFormat(value, layout ref.Val) ref.Val {
+	v, ok := value.(types.Timestamp)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	l, ok := layout.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(layout)
+	}
+	return types.String(v.Time.Format(string(l)))
+}
+
+func timeUnix(val ref.Val) ref.Val {
+	v, ok := val.(types.Int)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	return types.Timestamp{Time: time.Unix(int64(v), 0).UTC()}
+}
+
+func timeFormatDuration(val ref.Val) ref.Val {
+	v, ok := val.(types.Duration)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	return types.String(v.Duration.String())
+}
+
+func jsonParse(val ref.Val) ref.Val {
+	v, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(v), &doc); err != nil {
+		return types.NewErr("json.parse: %s", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(doc)
+}
+
+func jsonStringify(val ref.Val) ref.Val {
+	conv, err := val.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return types.NewErr("json.stringify: %s", err)
+	}
+	// encoding/json output is stable (sorted keys, no extra spaces), unlike protojson
+	data, err := json.Marshal(conv.(*structpb.Value).AsInterface())
+	if err != nil {
+		return types.NewErr("json.stringify: %s", err)
+	}
+	return types.String(data)
+}
+
+// hashInput returns the bytes of a string or bytes value
+func hashInput(val ref.Val) ([]byte, bool) {
+	switch v := val.(type) {
+	case types.String:
+		return []byte(v), true
+	case types.Bytes:
+		return v, true
+	}
+	return nil, false
+}
+
+func hashSHA256(val ref.Val) ref.Val {
+	data, ok := hashInput(val)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	sum := sha256.Sum256(data)
+	return types.String(hex.EncodeToString(sum[:]))
+}
+
+func hashMurmur3(val ref.Val) ref.Val {
+	data, ok := hashInput(val)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	return types.Int(murmur3.Sum32(data))
+}
+
+func uuidNew(_ ...ref.Val) ref.Val {
+	return types.String(uuid.NewString())
+}
+
+var regexCache sync.Map // pattern -> *regexp.Regexp
+
+func compileRegex(pattern ref.Val) (*regexp.Regexp, ref.Val) {
+	p, ok := pattern.(types.String)
+	if !ok {
+		return nil, types.MaybeNoSuchOverloadErr(pattern)
+	}
+	if re, ok := regexCache.Load(string(p)); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(string(p))
+	if err != nil {
+		return nil, types.NewErr("regex: %s", err)
+	}
+	regexCache.Store(string(p), re)
+	return re, nil
+}
+
+// regexExtract returns the first match (or its first capture group), or an empty string
+func regexExtract(value, pattern ref.Val) ref.Val {
+	v, ok := value.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	re, errVal := compileRegex(pattern)
+	if errVal != nil {
+		return errVal
+	}
+	match := re.FindStringSubmatch(string(v))
+	switch {
+	case len(match) > 1:
+		return types.String(match[1])
+	case len(match) == 1:
+		return types.String(match[0])
+	}
+	return types.String("")
+}
+
+// regexExtractAll returns all matches (or their first capture groups)
+func regexExtractAll(value, pattern ref.Val) ref.Val {
+	v, ok := value.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	re, errVal := compileRegex(pattern)
+	if errVal != nil {
+		return errVal
+	}
+	result := []string{}
+	for _, match := range re.FindAllStringSubmatch(string(v), -1) {
+		if len(match) > 1 {
+			result = append(result, match[1])
+			continue
+		}
+		result = append(result, match[0])
+	}
+	return types.NewStringList(types.DefaultTypeAdapter, result)
+}
+
+// getOrDefault resolves a dot-separated path (map keys, message fields or list indexes)
+// from a value and returns the default value if any path element is not set.
+func getOrDefault(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NewErr("getOrDefault: expecting 3 arguments")
+	}
+	path, ok := args[1].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[1])
+	}
+	current, def := args[0], args[2]
+	for _, elem := range strings.Split(string(path), ".") {
+		switch container := current.(type) {
+		case traits.Mapper:
+			value, found := container.Find(types.String(elem))
+			if !found {
+				return def
+			}
+			current = value
+		case traits.Lister:
+			idx, err := strconv.Atoi(elem)
+			if err != nil || idx < 0 || types.Int(idx) >= container.Size().(types.Int) {
+				return def
+			}
+			current = container.Get(types.Int(idx))
+		case traits.FieldTester:
+			indexer, ok := current.(traits.Indexer)
+			if !ok || container.IsSet(types.String(elem)) != types.True {
+				return def
+			}
+			current = indexer.Get(types.String(elem))
+		default:
+			return def
+		}
+		if types.IsUnknownOrError(current) || current == types.NullValue {
+			return def
+		}
+	}
+	return current
+}
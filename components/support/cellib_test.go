@@ -0,0 +1,80 @@
+package support
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+)
+
+func TestStreamLibStrings(t *testing.T) {
+	lib, err := StreamLib(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := cel.NewEnv(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr    string
+		want    interface{}
+		wantErr string
+	}{
+		{expr: `"héllo".charAt(1)`, want: "é"},
+		{expr: `"hello".charAt(5)`, want: ""},
+		{expr: `"hello".charAt(6)`, wantErr: "index out of range: 6"},
+		{expr: `"hello".indexOf("l")`, want: int64(2)},
+		{expr: `"hello".indexOf("l", 3)`, want: int64(3)},
+		{expr: `"hello".indexOf("")`, want: int64(0)},
+		{expr: `"hello".indexOf("x")`, want: int64(-1)},
+		{expr: `"hello".indexOf("l", 5)`, wantErr: "index out of range: 5"},
+		{expr: `"hello".lastIndexOf("l")`, want: int64(3)},
+		{expr: `"hello".lastIndexOf("l", 2)`, want: int64(2)},
+		{expr: `"hello".lastIndexOf("")`, want: int64(5)},
+		{expr: `"hello".lastIndexOf("x")`, want: int64(-1)},
+		{expr: `"HéLLo".lowerAscii()`, want: "héllo"},
+		{expr: `"héllo".upperAscii()`, want: "HéLLO"},
+		{expr: `"a-b-c".replace("-", "+")`, want: "a+b+c"},
+		{expr: `"a-b-c".replace("-", "+", 1)`, want: "a+b-c"},
+		{expr: `"a,b,c".split(",")`, want: []string{"a", "b", "c"}},
+		{expr: `"a,b,c".split(",", 2)`, want: []string{"a", "b,c"}},
+		{expr: `"héllo".substring(1)`, want: "éllo"},
+		{expr: `"héllo".substring(1, 3)`, want: "él"},
+		{expr: `"hello".substring(3, 1)`, wantErr: "invalid substring range"},
+		{expr: `"  hello ".trim()`, want: "hello"},
+		{expr: `base64.encode(b"hello")`, want: "aGVsbG8="},
+		{expr: `base64.decode("aGVsbG8=")`, want: []byte("hello")},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			ast, issues := env.Compile(test.expr)
+			if issues != nil && issues.Err() != nil {
+				t.Fatal(issues.Err())
+			}
+			prog, err := env.Program(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, _, err := prog.Eval(cel.NoVars())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := out.ConvertToNative(reflect.TypeOf(test.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package support
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	exprv1alpha1 "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	schemaPackagePrefix = "streaming.schemas"
+	schemaRootMessage   = "Record"
+	structTypeName      = ".google.protobuf.Struct"
+	valueTypeName       = ".google.protobuf.Value"
+)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// StreamVar is a stream variable declared in CEL expressions.
+// If Type is nil, the variable is declared as map(string, dyn).
+type StreamVar struct {
+	Name string
+	Type protoreflect.MessageDescriptor
+}
+
+// SchemaMessageType returns a message descriptor, describing the records of
+// a stream schema (jsonschema or avro), which is used to type-check CEL expressions.
+func SchemaMessageType(name, schemaType string, schema []byte) (protoreflect.MessageDescriptor, error) {
+	var doc interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return nil, fmt.Errorf("schema types: %s", err)
+	}
+
+	pkg := fmt.Sprintf("%s.%s", schemaPackagePrefix, SanitizeIdentifier(name))
+	builder := &descriptorBuilder{pkg: pkg, named: make(map[string]string)}
+	switch schemaType {
+	case SchemaTypeJSON, "":
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema types: jsonschema: expecting an object")
+		}
+		builder.jsonSchemaMessage(schemaRootMessage, obj)
+	case SchemaTypeAvro:
+		if _, err := builder.avroType(doc); err != nil {
+			return nil, fmt.Errorf("schema types: avro: %s", err)
+		}
+		if len(builder.messages) == 0 {
+			return nil, fmt.Errorf("schema types: avro: expecting a record schema")
+		}
+	default:
+		return nil, fmt.Errorf("schema types: unsupported type: %s", schemaType)
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String(strings.ReplaceAll(pkg, ".", "/") + ".proto"),
+		Package:     proto.String(pkg),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"google/protobuf/struct.proto"},
+		MessageType: builder.messages,
+	}
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("schema types: %s", err)
+	}
+	// the root message is the first message declared
+	return fd.Messages().Get(0), nil
+}
+
+// StreamVarFromEnv returns the stream variable, named name, for a stream configured with
+// <prefix>_SCHEMA_TYPE and <prefix>_SCHEMA or <prefix>_SCHEMA_FILE. If no schema is
+// configured, the message type of the stream codec (if any) is used.
+func StreamVarFromEnv(prefix, name string, codec Codec) (StreamVar, error) {
+	schemaType, schema, err := SchemaFromEnv(prefix)
+	if err != nil {
+		return StreamVar{}, err
+	}
+	if schema != nil {
+		msgType, err := SchemaMessageType(name, schemaType, schema)
+		if err != nil {
+			return StreamVar{}, err
+		}
+		return StreamVar{Name: name, Type: msgType}, nil
+	}
+	if typed, ok := codec.(TypedCodec); ok {
+		return StreamVar{Name: name, Type: typed.MessageType()}, nil
+	}
+	return StreamVar{Name: name}, nil
+}
+
+// CheckWhereExpr type-checks a filter expression which must return a bool.
+// The expression can use the functions of the library at libVersion (0 for latest).
+func CheckWhereExpr(expr string, libVersion int, vars ...StreamVar) error {
+	_, _, err := checkStreamExpr(expr, isBoolResult, "bool", libVersion, vars...)
+	return err
+}
+
+// CheckSelectExpr type-checks a data selection expression which must return a map, a message, or a list of them.
+// The expression can use the functions of the library at libVersion (0 for latest).
+func CheckSelectExpr(expr string, libVersion int, vars ...StreamVar) error {
+	_, _, err := checkStreamExpr(expr, isSelectResult, "map or a list of maps", libVersion, vars...)
+	return err
+}
+
+// CompileWhereProg compiles a filter expression, which must return a bool, into a program
+func CompileWhereProg(expr string, libVersion int, vars ...StreamVar) (cel.Program, error) {
+	env, ast, err := checkStreamExpr(expr, isBoolResult, "bool", libVersion, vars...)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast)
+}
+
+// CompileSelectProg compiles a data selection expression, which must return a map
+// or a list of maps, into a program
+func CompileSelectProg(expr string, libVersion int, vars ...StreamVar) (cel.Program, error) {
+	env, ast, err := checkStreamExpr(expr, isSelectResult, "map or a list of maps", libVersion, vars...)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast)
+}
+
+// CheckMapExpr type-checks an expression which must return a map or a message
+func CheckMapExpr(expr string, libVersion int, vars ...StreamVar) error {
+	_, _, err := checkStreamExpr(expr, isMapResult, "map", libVersion, vars...)
+	return err
+}
+
+// CompileMapProg compiles an expression, which must return a map or a message, into a program
+func CompileMapProg(expr string, libVersion int, vars ...StreamVar) (cel.Program, error) {
+	env, ast, err := checkStreamExpr(expr, isMapResult, "map", libVersion, vars...)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast)
+}
+
+// CheckKeyExpr type-checks a key expression which must return a string or an int
+func CheckKeyExpr(expr string, libVersion int, vars ...StreamVar) error {
+	_, _, err := checkStreamExpr(expr, isKeyResult, "string or int", libVersion, vars...)
+	return err
+}
+
+// CompileKeyProg compiles a key expression, which must return a string or an int, into a program
+func CompileKeyProg(expr string, libVersion int, vars ...StreamVar) (cel.Program, error) {
+	env, ast, err := checkStreamExpr(expr, isKeyResult, "string or int", libVersion, vars...)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast)
+}
+
+// StreamValue returns the value of a stream variable used to evaluate programs.
+// For typed variables, the data is converted into a message of the variable type,
+// after unwrapping the avro union values decoded as {"type": value}.
+func StreamValue(v StreamVar, data map[string]interface{}) (interface{}, error) {
+	if v.Type == nil {
+		return data, nil
+	}
+	jsonData, err := json.Marshal(unwrapUnions(v.Type, data))
+	if err != nil {
+		return nil, fmt.Errorf("stream %s: %s", v.Name, err)
+	}
+	msg := dynamicpb.NewMessage(v.Type)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(jsonData, msg); err != nil {
+		return nil, fmt.Errorf("stream %s: %s", v.Name, err)
+	}
+	return msg, nil
+}
+
+// unwrapUnions returns the data of a schema message where the values of avro unions are
+// unwrapped. A union value is a single entry map, in a union (optional) field, or in a
+// scalar or repeated field which can not hold a map. The data is not modified.
+func unwrapUnions(desc protoreflect.MessageDescriptor, data map[string]interface{}) map[string]interface{} {
+	if !strings.HasPrefix(string(desc.FullName()), schemaPackagePrefix+".") {
+		return data
+	}
+	result := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		result[k] = v
+	}
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := fd.JSONName()
+		value, ok := result[name]
+		if !ok {
+			name = string(fd.Name())
+			if value, ok = result[name]; !ok {
+				continue
+			}
+		}
+		if !fd.IsList() {
+			result[name] = unwrapUnion(fd, value, fd.HasOptionalKeyword())
+			continue
+		}
+		value = unwrapUnion(fd, value, true)
+		if list, ok := value.([]interface{}); ok {
+			elems := make([]interface{}, len(list))
+			for i, elem := range list {
+				elems[i] = unwrapUnion(fd, elem, false)
+			}
+			value = elems
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func unwrapUnion(fd protoreflect.FieldDescriptor, value interface{}, union bool) interface{} {
+	if m, ok := value.(map[string]interface{}); ok && len(m) == 1 && (union || fd.Message() == nil) {
+		for _, v := range m {
+			value = v
+		}
+	}
+	if m, ok := value.(map[string]interface{}); ok && fd.Message() != nil {
+		return unwrapUnions(fd.Message(), m)
+	}
+	return value
+}
+
+// ResultStruct converts the result of a data selection program (a map or a message) into a Struct
+func ResultStruct(result ref.Val) (*structpb.Struct, error) {
+	conv, err := result.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, err
+	}
+	value := conv.(*structpb.Value).GetStructValue()
+	if value == nil {
+		return nil, fmt.Errorf("expecting a map, got %s", result.Type().TypeName())
+	}
+	return value, nil
+}
+
+// ResultStructs converts the result of a data selection program into a list of Structs.
+// If the result is a list, each of its elements is converted and, if indexField is
+// not empty, the index of the element is set as field indexField.
+func ResultStructs(result ref.Val, indexField string) ([]*structpb.Struct, error) {
+	list, ok := result.(traits.Lister)
+	if !ok {
+		value, err := ResultStruct(result)
+		if err != nil {
+			return nil, err
+		}
+		return []*structpb.Struct{value}, nil
+	}
+
+	var values []*structpb.Struct
+	it := list.Iterator()
+	for i := 0; it.HasNext() == types.True; i++ {
+		value, err := ResultStruct(it.Next())
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %s", i, err)
+		}
+		if indexField != "" {
+			value.Fields[indexField] = structpb.NewNumberValue(float64(i))
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func checkStreamExpr(expr string, isValid func(*exprv1alpha1.Type) bool, want string, libVersion int, vars ...StreamVar) (*cel.Env, *cel.Ast, error) {
+	env, err := NewStreamCELEnv(libVersion, vars...)
+	if err != nil {
+		return nil, nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, nil, iss.Err()
+	}
+	if !isValid(ast.ResultType()) {
+		return nil, nil, fmt.Errorf("expression must return a %s, got %s", want, typeString(ast.ResultType()))
+	}
+	return env, ast, nil
+}
+
+func isBoolResult(t *exprv1alpha1.Type) bool {
+	return t.GetPrimitive() == exprv1alpha1.Type_BOOL || t.GetDyn() != nil
+}
+
+func isKeyResult(t *exprv1alpha1.Type) bool {
+	switch t.GetPrimitive() {
+	case exprv1alpha1.Type_STRING, exprv1alpha1.Type_INT64, exprv1alpha1.Type_UINT64:
+		return true
+	}
+	return t.GetDyn() != nil
+}
+
+func isMapResult(t *exprv1alpha1.Type) bool {
+	return t.GetMapType() != nil || t.GetMessageType() != "" || t.GetDyn() != nil
+}
+
+// isSelectResult returns true for maps, messages, and lists of them
+func isSelectResult(t *exprv1alpha1.Type) bool {
+	if list := t.GetListType(); list != nil {
+		return isMapResult(list.GetElemType())
+	}
+	return isMapResult(t)
+}
+
+func typeString(t *exprv1alpha1.Type) string {
+	switch {
+	case t.GetPrimitive() != exprv1alpha1.Type_PRIMITIVE_TYPE_UNSPECIFIED:
+		return strings.ToLower(t.GetPrimitive().String())
+	case t.GetListType() != nil:
+		return "list"
+	case t.GetMessageType() != "":
+		return t.GetMessageType()
+	case t.GetWellKnown() != exprv1alpha1.Type_WELL_KNOWN_TYPE_UNSPECIFIED:
+		return strings.ToLower(t.GetWellKnown().String())
+	}
+	return t.String()
+}
+
+// NewStreamCELEnv returns a CEL environment, with the stream function library at libVersion,
+// where stream variables with a message type are declared as objects of that type.
+func NewStreamCELEnv(libVersion int, vars ...StreamVar) (*cel.Env, error) {
+	lib, err := StreamLib(libVersion)
+	if err != nil {
+		return nil, err
+	}
+	var varDecls []*exprv1alpha1.Decl
+	opts := []cel.EnvOption{lib}
+	for _, v := range vars {
+		if v.Type == nil {
+			varDecls = append(varDecls, decls.NewVar(v.Name, decls.NewMapType(decls.String, decls.Dyn)))
+			continue
+		}
+		opts = append(opts, cel.TypeDescs(v.Type.ParentFile()))
+		varDecls = append(varDecls, decls.NewVar(v.Name, decls.NewObjectType(string(v.Type.FullName()))))
+	}
+	opts = append(opts, cel.Declarations(varDecls...))
+	return cel.NewEnv(opts...)
+}
+
+// descriptorBuilder assembles message descriptors from schema documents
+type descriptorBuilder struct {
+	pkg      string
+	messages []*descriptorpb.DescriptorProto
+	named    map[string]string // avro named types -> proto type names
+}
+
+func (b *descriptorBuilder) typeName(msgName string) string {
+	return fmt.Sprintf(".%s.%s", b.pkg, msgName)
+}
+
+// jsonSchemaMessage declares a message for a JSON schema object and returns its type name
+func (b *descriptorBuilder) jsonSchemaMessage(msgName string, obj map[string]interface{}) string {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(msgName)}
+	b.messages = append(b.messages, msg)
+
+	props, _ := obj["properties"].(map[string]interface{})
+	for _, propName := range sortedKeys(props) {
+		prop, _ := props[propName].(map[string]interface{})
+		field := newField(propName, int32(len(msg.Field)+1))
+		if field == nil {
+			continue
+		}
+		b.jsonSchemaField(msgName, field, prop)
+		msg.Field = append(msg.Field, field)
+	}
+	return b.typeName(msgName)
+}
+
+func (b *descriptorBuilder) jsonSchemaField(parent string, field *descriptorpb.FieldDescriptorProto, prop map[string]interface{}) {
+	switch jsonSchemaType(prop) {
+	case "string":
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	case "integer":
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+	case "number":
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+	case "boolean":
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+	case "object":
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		if _, ok := prop["properties"].(map[string]interface{}); ok {
+			field.TypeName = proto.String(b.jsonSchemaMessage(parent+"_"+field.GetName(), prop))
+		} else {
+			field.TypeName = proto.String(structTypeName)
+		}
+	case "array":
+		items, _ := prop["items"].(map[string]interface{})
+		if jsonSchemaType(items) == "array" {
+			// nested lists are not repeatable, fallback to dynamic values
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(valueTypeName)
+			return
+		}
+		b.jsonSchemaField(parent, field, items)
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	default:
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String(valueTypeName)
+	}
+}
+
+// jsonSchemaType returns the non-null type of a JSON schema, or "" if not determined
+func jsonSchemaType(prop map[string]interface{}) string {
+	switch t := prop["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		var types []string
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				types = append(types, name)
+			}
+		}
+		if len(types) == 1 {
+			return types[0]
+		}
+	}
+	return ""
+}
+
+// avroType resolves an avro schema into a field type. For records, a message is declared.
+func (b *descriptorBuilder) avroType(schema interface{}) (*descriptorpb.FieldDescriptorProto, error) {
+	field := &descriptorpb.FieldDescriptorProto{}
+	switch s := schema.(type) {
+	case string:
+		switch s {
+		case "boolean":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+		case "int", "long":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		case "float", "double":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+		case "bytes":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+		case "string":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		case "null":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(valueTypeName)
+		default:
+			typeName, ok := b.named[s]
+			if !ok {
+				return nil, fmt.Errorf("unknown type: %s", s)
+			}
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(typeName)
+		}
+	case []interface{}: // union
+		var types []interface{}
+		for _, t := range s {
+			if t != "null" {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 1 {
+			var err error
+			if field, err = b.avroType(types[0]); err != nil {
+				return nil, err
+			}
+		} else {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(valueTypeName)
+		}
+		// the union fields are optional, to unwrap their values in StreamValue
+		if field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			field.Proto3Optional = proto.Bool(true)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record":
+			name, _ := s["name"].(string)
+			msgName := SanitizeIdentifier(name)
+			if !identifierRegexp.MatchString(msgName) {
+				return nil, fmt.Errorf("invalid record name: %s", name)
+			}
+			typeName := b.typeName(msgName)
+			b.named[name] = typeName
+			msg := &descriptorpb.DescriptorProto{Name: proto.String(msgName)}
+			b.messages = append(b.messages, msg)
+			fields, _ := s["fields"].([]interface{})
+			for _, f := range fields {
+				fieldSchema, _ := f.(map[string]interface{})
+				fieldName, _ := fieldSchema["name"].(string)
+				fieldType, err := b.avroType(fieldSchema["type"])
+				if err != nil {
+					return nil, err
+				}
+				field := newField(fieldName, int32(len(msg.Field)+1))
+				if field == nil {
+					continue
+				}
+				field.Type, field.TypeName = fieldType.Type, fieldType.TypeName
+				if fieldType.Label != nil {
+					field.Label = fieldType.Label
+				}
+				if fieldType.GetProto3Optional() {
+					field.Proto3Optional = proto.Bool(true)
+					field.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+					msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + field.GetName())})
+				}
+				msg.Field = append(msg.Field, field)
+			}
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(typeName)
+		case "enum":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		case "fixed":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+		case "array":
+			items, err := b.avroType(s["items"])
+			if err != nil {
+				return nil, err
+			}
+			if items.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String(valueTypeName)
+				break
+			}
+			field.Type, field.TypeName = items.Type, items.TypeName
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		case "map":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(structTypeName)
+		default: // primitive type declared as {"type": "..."}
+			return b.avroType(s["type"])
+		}
+	default:
+		return nil, fmt.Errorf("unexpected schema: %v", schema)
+	}
+	return field, nil
+}
+
+// newField returns a field descriptor, or nil if the name cannot be used as a proto field
+func newField(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	fieldName := SanitizeIdentifier(name)
+	if !identifierRegexp.MatchString(fieldName) {
+		return nil
+	}
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(fieldName),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
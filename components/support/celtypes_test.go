@@ -0,0 +1,301 @@
+package support
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	ordersJSONSchema = `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "string"},
+			"amount": {"type": "integer"},
+			"price": {"type": ["number", "null"]},
+			"paid": {"type": "boolean"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"grid": {"type": "array", "items": {"type": "array"}},
+			"item": {"type": "object", "properties": {"sku-id": {"type": "string"}}},
+			"attrs": {"type": "object"},
+			"any": {}
+		}
+	}`
+	ordersAvroSchema = `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "long"},
+		{"name": "price", "type": ["null", "double"]},
+		{"name": "paid", "type": {"type": "boolean"}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "sizes", "type": {"type": "array", "items": ["null", "int"]}},
+		{"name": "codes", "type": ["null", {"type": "array", "items": "string"}]},
+		{"name": "item", "type": ["null", {"type": "record", "name": "Item", "fields": [{"name": "sku", "type": "string"}]}]},
+		{"name": "first", "type": "Item"},
+		{"name": "attrs", "type": {"type": "map", "values": "string"}},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "PAID"]}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+		{"name": "raw", "type": "bytes"},
+		{"name": "note", "type": ["null", "string", "long"]}
+	]}`
+)
+
+func TestSchemaMessageType(t *testing.T) {
+	tests := []struct {
+		schemaType string
+		schema     string
+		field      string
+		kind       protoreflect.Kind
+		message    protoreflect.FullName // type of message fields
+		list       bool
+		optional   bool
+	}{
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "id", kind: protoreflect.StringKind},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "amount", kind: protoreflect.Int64Kind},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "price", kind: protoreflect.DoubleKind},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "paid", kind: protoreflect.BoolKind},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "tags", kind: protoreflect.StringKind, list: true},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "grid", kind: protoreflect.MessageKind, message: "google.protobuf.Value"},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "item", kind: protoreflect.MessageKind, message: "streaming.schemas.orders.Record_item"},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "attrs", kind: protoreflect.MessageKind, message: "google.protobuf.Struct"},
+		{schemaType: SchemaTypeJSON, schema: ordersJSONSchema, field: "any", kind: protoreflect.MessageKind, message: "google.protobuf.Value"},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "id", kind: protoreflect.StringKind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "amount", kind: protoreflect.Int64Kind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "price", kind: protoreflect.DoubleKind, optional: true},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "paid", kind: protoreflect.BoolKind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "tags", kind: protoreflect.StringKind, list: true},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "sizes", kind: protoreflect.Int64Kind, list: true},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "codes", kind: protoreflect.StringKind, list: true},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "item", kind: protoreflect.MessageKind, message: "streaming.schemas.orders.Item", optional: true},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "first", kind: protoreflect.MessageKind, message: "streaming.schemas.orders.Item"},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "attrs", kind: protoreflect.MessageKind, message: "google.protobuf.Struct"},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "status", kind: protoreflect.StringKind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "hash", kind: protoreflect.BytesKind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "raw", kind: protoreflect.BytesKind},
+		{schemaType: SchemaTypeAvro, schema: ordersAvroSchema, field: "note", kind: protoreflect.MessageKind, message: "google.protobuf.Value", optional: true},
+	}
+	for _, test := range tests {
+		t.Run(test.schemaType+"/"+test.field, func(t *testing.T) {
+			msgType, err := SchemaMessageType("orders", test.schemaType, []byte(test.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fd := msgType.Fields().ByName(protoreflect.Name(test.field))
+			if fd == nil {
+				t.Fatalf("field %s not found in %s", test.field, msgType.FullName())
+			}
+			if fd.Kind() != test.kind {
+				t.Errorf("got kind %s, want %s", fd.Kind(), test.kind)
+			}
+			if fd.Message() != nil && fd.Message().FullName() != test.message {
+				t.Errorf("got message %s, want %s", fd.Message().FullName(), test.message)
+			}
+			if fd.IsList() != test.list {
+				t.Errorf("got list %t, want %t", fd.IsList(), test.list)
+			}
+			if fd.HasOptionalKeyword() != test.optional {
+				t.Errorf("got optional %t, want %t", fd.HasOptionalKeyword(), test.optional)
+			}
+		})
+	}
+}
+
+func TestSchemaMessageTypeNames(t *testing.T) {
+	msgType, err := SchemaMessageType("orders-v1", SchemaTypeJSON, []byte(ordersJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msgType.FullName(), protoreflect.FullName("streaming.schemas.orders_v1.Record"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// the JSON name of fields is the property name
+	fd := msgType.Fields().ByName("item").Message().Fields().ByName("sku_id")
+	if fd == nil || fd.JSONName() != "sku-id" {
+		t.Errorf("got field %v, want sku_id with JSON name sku-id", fd)
+	}
+}
+
+func TestSchemaMessageTypeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		schemaType string
+		schema     string
+		wantErr    string
+	}{
+		{name: "invalid json", schemaType: SchemaTypeJSON, schema: "{", wantErr: "schema types:"},
+		{name: "jsonschema not an object", schemaType: SchemaTypeJSON, schema: `["string"]`, wantErr: "jsonschema: expecting an object"},
+		{name: "avro not a record", schemaType: SchemaTypeAvro, schema: `"string"`, wantErr: "avro: expecting a record schema"},
+		{name: "avro unknown type", schemaType: SchemaTypeAvro, schema: `{"type": "record", "name": "R", "fields": [{"name": "a", "type": "Other"}]}`, wantErr: "unknown type: Other"},
+		{name: "avro invalid record name", schemaType: SchemaTypeAvro, schema: `{"type": "record", "name": "1R", "fields": []}`, wantErr: "invalid record name: 1R"},
+		{name: "unsupported type", schemaType: "xsd", schema: "{}", wantErr: "unsupported type: xsd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := SchemaMessageType("orders", test.schemaType, []byte(test.schema))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamValue(t *testing.T) {
+	jsonType, err := SchemaMessageType("orders", SchemaTypeJSON, []byte(ordersJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	avroType, err := SchemaMessageType("orders", SchemaTypeAvro, []byte(ordersAvroSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		v       StreamVar
+		data    map[string]interface{}
+		expr    string
+		wantErr string
+	}{
+		{name: "untyped", v: StreamVar{Name: "o"}, data: map[string]interface{}{"id": "a"}, expr: `o.id == "a"`},
+		{
+			name: "jsonschema",
+			v:    StreamVar{Name: "o", Type: jsonType},
+			data: map[string]interface{}{"id": "a", "amount": 3, "tags": []interface{}{"x"}, "item": map[string]interface{}{"sku-id": "s"}, "other": 1},
+			expr: `o.id == "a" && o.amount == 3 && o.tags[0] == "x" && o.item.sku_id == "s" && !o.paid`,
+		},
+		{
+			name: "avro",
+			v:    StreamVar{Name: "o", Type: avroType},
+			data: map[string]interface{}{"id": "a", "amount": int64(3), "first": map[string]interface{}{"sku": "f"}, "attrs": map[string]interface{}{"k": "v"}},
+			expr: `o.id == "a" && o.amount == 3 && o.first.sku == "f" && o.attrs.k == "v"`,
+		},
+		{
+			name: "avro union set",
+			v:    StreamVar{Name: "o", Type: avroType},
+			data: map[string]interface{}{"price": map[string]interface{}{"double": 1.5}, "note": map[string]interface{}{"string": "n"}},
+			expr: `has(o.price) && o.price == 1.5 && o.note == "n"`,
+		},
+		{
+			name: "avro union null",
+			v:    StreamVar{Name: "o", Type: avroType},
+			data: map[string]interface{}{"price": nil, "item": nil},
+			expr: `!has(o.price) && !has(o.item)`,
+		},
+		{
+			name: "avro union record",
+			v:    StreamVar{Name: "o", Type: avroType},
+			data: map[string]interface{}{"item": map[string]interface{}{"Item": map[string]interface{}{"sku": "s"}}},
+			expr: `o.item.sku == "s"`,
+		},
+		{
+			name: "avro union lists",
+			v:    StreamVar{Name: "o", Type: avroType},
+			data: map[string]interface{}{
+				"sizes": []interface{}{map[string]interface{}{"int": int32(1)}, map[string]interface{}{"int": int32(2)}},
+				"codes": map[string]interface{}{"array": []interface{}{"c"}},
+			},
+			expr: `o.sizes == [1, 2] && o.codes == ["c"]`,
+		},
+		{name: "invalid value", v: StreamVar{Name: "o", Type: jsonType}, data: map[string]interface{}{"amount": "x"}, wantErr: "stream o:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := StreamValue(test.v, test.data)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog, err := CompileWhereProg(test.expr, 0, test.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := EvalWhere(prog, map[string]interface{}{test.v.Name: value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("got false, want true")
+			}
+		})
+	}
+}
+
+func TestStreamValueData(t *testing.T) {
+	avroType, err := SchemaMessageType("orders", SchemaTypeAvro, []byte(ordersAvroSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	price := map[string]interface{}{"double": 1.5}
+	data := map[string]interface{}{"price": price}
+	if _, err := StreamValue(StreamVar{Name: "o", Type: avroType}, data); err != nil {
+		t.Fatal(err)
+	}
+	// the decoded data, used by the outputs, is not modified
+	if got, ok := data["price"].(map[string]interface{}); !ok || got["double"] != 1.5 {
+		t.Errorf("got price %v, want %v", data["price"], price)
+	}
+}
+
+func TestCompileExprs(t *testing.T) {
+	msgType, err := SchemaMessageType("orders", SchemaTypeJSON, []byte(ordersJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, untyped := StreamVar{Name: "o", Type: msgType}, StreamVar{Name: "u"}
+	type check func(string, int, ...StreamVar) error
+	tests := []struct {
+		name    string
+		check   check
+		expr    string
+		wantErr string
+	}{
+		{name: "where", check: CheckWhereExpr, expr: `o.amount > 1 && u.x == "y"`},
+		{name: "where dyn", check: CheckWhereExpr, expr: `u.ok`},
+		{name: "where not a bool", check: CheckWhereExpr, expr: `o.amount`, wantErr: "expression must return a bool, got int64"},
+		{name: "where unknown field", check: CheckWhereExpr, expr: `o.missing == 1`, wantErr: "undefined field 'missing'"},
+		{name: "where unknown variable", check: CheckWhereExpr, expr: `x.id == "a"`, wantErr: "undeclared reference to 'x'"},
+		{name: "where syntax", check: CheckWhereExpr, expr: `o.id ==`, wantErr: "Syntax error"},
+		{name: "select map", check: CheckSelectExpr, expr: `{"id": o.id}`},
+		{name: "select message", check: CheckSelectExpr, expr: `o.item`},
+		{name: "select list", check: CheckSelectExpr, expr: `[{"id": o.id}, {"id": u.id}]`},
+		{name: "select not a map", check: CheckSelectExpr, expr: `o.tags`, wantErr: "expression must return a map or a list of maps, got list"},
+		{name: "select string", check: CheckSelectExpr, expr: `o.id`, wantErr: "got string"},
+		{name: "map", check: CheckMapExpr, expr: `{"n": o.amount}`},
+		{name: "map list", check: CheckMapExpr, expr: `[o.item]`, wantErr: "expression must return a map, got list"},
+		{name: "key string", check: CheckKeyExpr, expr: `o.id`},
+		{name: "key int", check: CheckKeyExpr, expr: `o.amount`},
+		{name: "key dyn", check: CheckKeyExpr, expr: `u.id`},
+		{name: "key double", check: CheckKeyExpr, expr: `o.price`, wantErr: "expression must return a string or int, got double"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.check(test.expr, 0, typed, untyped)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+
+	if _, err := CompileKeyProg(`o.id`, 0, typed); err != nil {
+		t.Errorf("compile key: %s", err)
+	}
+	if _, err := CompileSelectProg(`o.id`, 0, typed); err == nil {
+		t.Error("compile select: expected an error for a string result")
+	}
+	if _, err := CompileMapProg(`{"id": o.id}`, 0, typed); err != nil {
+		t.Errorf("compile map: %s", err)
+	}
+	if _, err := CompileWhereProg(`o.id`, 0, typed); err == nil {
+		t.Error("compile where: expected an error for a string result")
+	}
+	if _, err := CompileWhereProg(`o.id == "a"`, 99, typed); err == nil {
+		t.Error("compile where: expected an error for an unknown library version")
+	}
+}
@@ -0,0 +1,43 @@
+package support
+
+import (
+	"context"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+)
+
+// Publisher publishes data to pubsub topics (implemented by dapr.Client)
+type Publisher interface {
+	PublishEvent(ctx context.Context, pubsubName, topicName string, data interface{}, opts ...dapr.PublishEventOption) error
+}
+
+// Invoker invokes the methods of components (implemented by dapr.Client)
+type Invoker interface {
+	InvokeMethodWithContent(ctx context.Context, appID, methodName, verb string, content *dapr.DataContent) ([]byte, error)
+}
+
+// Dispatcher sends data to stream and component targets
+type Dispatcher interface {
+	Publisher
+	Invoker
+}
+
+// StateStore loads and saves state (implemented by dapr.Client)
+type StateStore interface {
+	GetState(ctx context.Context, storeName, key string) (*dapr.StateItem, error)
+	SaveBulkState(ctx context.Context, storeName string, items ...*dapr.SetStateItem) error
+}
+
+// Client is the subset of the Dapr client used by components
+type Client interface {
+	Dispatcher
+	StateStore
+}
+
+// Source delivers service invocations and topic events to component
+// handlers (implemented by the Dapr service)
+type Source interface {
+	AddServiceInvocationHandler(name string, fn common.ServiceInvocationHandler) error
+	AddTopicEventHandler(sub *common.Subscription, fn common.TopicEventHandler) error
+}
@@ -0,0 +1,530 @@
+package support
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/linkedin/goavro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	ContentTypeJSON       = "application/json"
+	ContentTypeCloudEvent = "application/cloudevents+json"
+	ContentTypeAvro       = "application/avro"
+	ContentTypeProtobuf   = "application/x-protobuf"
+	ContentTypeMsgPack    = "application/msgpack"
+	ContentTypeCSV        = "text/csv"
+)
+
+// Codec decodes stream payloads into maps (which are exposed to CEL expressions)
+// and encodes component output into the payload format expected downstream.
+type Codec interface {
+	ContentType() string
+	Decode(data []byte) (map[string]interface{}, error)
+	Encode(data interface{}) ([]byte, error)
+}
+
+// TypedCodec is implemented by codecs that encode a known message type
+type TypedCodec interface {
+	MessageType() protoreflect.MessageDescriptor
+}
+
+// CodecConfig carries the information needed to create a Codec
+type CodecConfig struct {
+	ContentType string
+	Schema      []byte // schema content (Avro schema, protobuf descriptor set, or CSV header)
+	MessageType string // fully-qualified protobuf message name
+}
+
+// CodecFactory creates a Codec from its configuration
+type CodecFactory func(cfg CodecConfig) (Codec, error)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]CodecFactory{}
+)
+
+func init() {
+	RegisterCodec(ContentTypeJSON, newJSONCodec)
+	RegisterCodec("text/json", newJSONCodec)
+	RegisterCodec(ContentTypeAvro, newAvroCodec)
+	RegisterCodec("avro/binary", newAvroCodec)
+	RegisterCodec("application/x-avro", newAvroCodec)
+	RegisterCodec(ContentTypeProtobuf, newProtobufCodec)
+	RegisterCodec("application/protobuf", newProtobufCodec)
+	RegisterCodec(ContentTypeMsgPack, newMsgPackCodec)
+	RegisterCodec("application/x-msgpack", newMsgPackCodec)
+	RegisterCodec(ContentTypeCSV, newCSVCodec)
+}
+
+// RegisterCodec makes a codec factory available for the specified content type
+func RegisterCodec(contentType string, factory CodecFactory) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[normalizeContentType(contentType)] = factory
+}
+
+// NewCodec returns a Codec for the configured content type.
+// If no content type is provided, the JSON codec is returned.
+func NewCodec(cfg CodecConfig) (Codec, error) {
+	contentType := normalizeContentType(cfg.ContentType)
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	codecsMu.RLock()
+	factory, ok := codecs[contentType]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("codec: unsupported content type: %s", cfg.ContentType)
+	}
+	return factory(cfg)
+}
+
+// CodecFromEnv creates a Codec using the following environment variables:
+//   - <prefix>_CODEC: the payload content type
+//   - <prefix>_CODEC_SCHEMA: an inline schema
+//   - <prefix>_CODEC_SCHEMA_FILE: path of a schema file (i.e. mounted from a ConfigMap)
+//   - <prefix>_CODEC_MESSAGE_TYPE: protobuf message name
+//
+// It returns nil if no codec is configured.
+func CodecFromEnv(prefix string) (Codec, error) {
+	cfg := CodecConfig{
+		ContentType: os.Getenv(prefix + "_CODEC"),
+		MessageType: os.Getenv(prefix + "_CODEC_MESSAGE_TYPE"),
+	}
+	if cfg.ContentType == "" {
+		return nil, nil
+	}
+	if schema := os.Getenv(prefix + "_CODEC_SCHEMA"); schema != "" {
+		cfg.Schema = []byte(schema)
+	}
+	if schemaFile := os.Getenv(prefix + "_CODEC_SCHEMA_FILE"); schemaFile != "" {
+		schema, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("codec: schema file: %s", err)
+		}
+		cfg.Schema = schema
+	}
+	return NewCodec(cfg)
+}
+
+// DecodeInvocation decodes the data of an invocation event using the provided codec.
+// If codec is nil, one is selected using the content type of the event.
+func DecodeInvocation(e *common.InvocationEvent, codec Codec) (map[string]interface{}, error) {
+	if normalizeContentType(e.ContentType) == ContentTypeCloudEvent {
+		var cloudevent map[string]interface{}
+		if err := json.Unmarshal(e.Data, &cloudevent); err != nil {
+			return nil, err
+		}
+		return decodeCloudEventData(cloudevent, codec)
+	}
+
+	if codec == nil {
+		var err error
+		if codec, err = NewCodec(CodecConfig{ContentType: e.ContentType}); err != nil {
+			return nil, fmt.Errorf("unsupported event type: %s", e.ContentType)
+		}
+	}
+	return codec.Decode(e.Data)
+}
+
+// DecodeTopicEvent decodes the data of a topic event using the provided codec.
+// If codec is nil, one is selected using the content type of the event.
+func DecodeTopicEvent(e *common.TopicEvent, codec Codec) (map[string]interface{}, error) {
+	if data, ok := e.Data.(map[string]interface{}); ok && (codec == nil || isJSON(codec.ContentType())) {
+		return data, nil
+	}
+	if codec == nil {
+		var err error
+		if codec, err = NewCodec(CodecConfig{ContentType: e.DataContentType}); err != nil {
+			return nil, fmt.Errorf("unsupported event type: %s", e.DataContentType)
+		}
+	}
+
+	switch {
+	case e.DataBase64 != "":
+		raw, err := base64.StdEncoding.DecodeString(e.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("topic event: data_base64: %s", err)
+		}
+		return codec.Decode(raw)
+	case len(e.RawData) > 0:
+		if str, ok := e.Data.(string); ok && !isJSON(codec.ContentType()) {
+			return codec.Decode([]byte(str))
+		}
+		return codec.Decode(e.RawData)
+	}
+	return nil, fmt.Errorf("topic event: missing data")
+}
+
+// decodeCloudEventData extracts and decodes the data carried in a cloudevent envelope
+func decodeCloudEventData(cloudevent map[string]interface{}, codec Codec) (map[string]interface{}, error) {
+	if codec == nil {
+		contentType, _ := cloudevent["datacontenttype"].(string)
+		var err error
+		if codec, err = NewCodec(CodecConfig{ContentType: contentType}); err != nil {
+			return nil, fmt.Errorf("cloudevent: unsupported data content type: %s", contentType)
+		}
+	}
+
+	if encoded, ok := cloudevent["data_base64"].(string); ok {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("cloudevent: data_base64: %s", err)
+		}
+		return codec.Decode(raw)
+	}
+
+	data, ok := cloudevent["data"]
+	if !ok {
+		return nil, fmt.Errorf("cloudevent missing 'data' entry")
+	}
+	switch val := data.(type) {
+	case map[string]interface{}:
+		if isJSON(codec.ContentType()) {
+			return val, nil
+		}
+		return nil, fmt.Errorf("cloudevent data has unexpected type: %T", data)
+	case string:
+		return codec.Decode([]byte(val))
+	default:
+		return nil, fmt.Errorf("cloudevent data has unexpected type: %T", data)
+	}
+}
+
+func normalizeContentType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasSuffix(normalizeContentType(contentType), "json")
+}
+
+// jsonCodec encodes/decodes JSON payloads
+type jsonCodec struct{}
+
+func newJSONCodec(_ CodecConfig) (Codec, error) {
+	return jsonCodec{}, nil
+}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Decode(data []byte) (map[string]interface{}, error) {
+	return UnmarshalJSON(data)
+}
+
+func (jsonCodec) Encode(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// avroCodec encodes/decodes Avro binary payloads using the configured schema
+type avroCodec struct {
+	codec *goavro.Codec
+}
+
+func newAvroCodec(cfg CodecConfig) (Codec, error) {
+	if len(cfg.Schema) == 0 {
+		return nil, fmt.Errorf("avro codec: schema required")
+	}
+	codec, err := goavro.NewCodec(string(cfg.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("avro codec: %s", err)
+	}
+	return &avroCodec{codec: codec}, nil
+}
+
+func (c *avroCodec) ContentType() string {
+	return ContentTypeAvro
+}
+
+func (c *avroCodec) Decode(data []byte) (map[string]interface{}, error) {
+	native, _, err := c.codec.NativeFromBinary(data)
+	if err != nil {
+		return nil, fmt.Errorf("avro codec: decode: %s", err)
+	}
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("avro codec: decoded value is not a record: %T", native)
+	}
+	return record, nil
+}
+
+func (c *avroCodec) Encode(data interface{}) ([]byte, error) {
+	bin, err := c.codec.BinaryFromNative(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("avro codec: encode: %s", err)
+	}
+	return bin, nil
+}
+
+// protobufCodec encodes/decodes protobuf payloads using a message descriptor
+// loaded from a serialized FileDescriptorSet (i.e. protoc --descriptor_set_out)
+type protobufCodec struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func newProtobufCodec(cfg CodecConfig) (Codec, error) {
+	if len(cfg.Schema) == 0 || cfg.MessageType == "" {
+		return nil, fmt.Errorf("protobuf codec: descriptor set and message type required")
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(cfg.Schema, &fds); err != nil {
+		return nil, fmt.Errorf("protobuf codec: descriptor set: %s", err)
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: descriptor set: %s", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(cfg.MessageType))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: message type %s: %s", cfg.MessageType, err)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %s is not a message", cfg.MessageType)
+	}
+	return &protobufCodec{desc: msgDesc}, nil
+}
+
+func (c *protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+// MessageType returns the descriptor of the encoded message
+func (c *protobufCodec) MessageType() protoreflect.MessageDescriptor {
+	return c.desc
+}
+
+func (c *protobufCodec) Decode(data []byte) (map[string]interface{}, error) {
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("protobuf codec: decode: %s", err)
+	}
+	jsonData, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: decode: %s", err)
+	}
+	result, err := UnmarshalJSON(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: decode: %s", err)
+	}
+	protoIntegers(c.desc, result)
+	return result, nil
+}
+
+// protoIntegers converts the integer fields of a message decoded by protojson, which
+// are JSON strings (64 bits) or float64, into int64 and uint64 values, typed as ints
+// and uints in CEL expressions
+func protoIntegers(desc protoreflect.MessageDescriptor, data map[string]interface{}) {
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		value, ok := data[fd.TextName()]
+		if !ok {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			if entries, ok := value.(map[string]interface{}); ok {
+				for k, v := range entries {
+					entries[k] = protoInteger(fd.MapValue(), v)
+				}
+			}
+		case fd.IsList():
+			if items, ok := value.([]interface{}); ok {
+				for j, v := range items {
+					items[j] = protoInteger(fd, v)
+				}
+			}
+		default:
+			data[fd.TextName()] = protoInteger(fd, value)
+		}
+	}
+}
+
+// protoInteger converts a decoded value of a (non repeated) field
+func protoInteger(fd protoreflect.FieldDescriptor, value interface{}) interface{} {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}:
+		// messages, other than the well-known types encoded as JSON values
+		if msg := fd.Message(); msg != nil && !strings.HasPrefix(string(msg.FullName()), "google.protobuf.") {
+			protoIntegers(msg, v)
+		}
+		return value
+	default:
+		return value
+	}
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u
+		}
+	}
+	return value
+}
+
+func (c *protobufCodec) Encode(data interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: encode: %s", err)
+	}
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := protojson.Unmarshal(jsonData, msg); err != nil {
+		return nil, fmt.Errorf("protobuf codec: encode: %s", err)
+	}
+	return proto.Marshal(msg)
+}
+
+// msgPackCodec encodes/decodes MessagePack payloads
+type msgPackCodec struct{}
+
+func newMsgPackCodec(_ CodecConfig) (Codec, error) {
+	return msgPackCodec{}, nil
+}
+
+func (msgPackCodec) ContentType() string {
+	return ContentTypeMsgPack
+}
+
+func (msgPackCodec) Decode(data []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := msgpack.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("msgpack codec: decode: %s", err)
+	}
+	return result, nil
+}
+
+func (msgPackCodec) Encode(data interface{}) ([]byte, error) {
+	return msgpack.Marshal(data)
+}
+
+// csvCodec encodes/decodes CSV records. Column names are taken from the
+// schema (a single CSV header line) or, if missing, from the payload header.
+type csvCodec struct {
+	columns []string
+}
+
+func newCSVCodec(cfg CodecConfig) (Codec, error) {
+	codec := &csvCodec{}
+	if len(bytes.TrimSpace(cfg.Schema)) > 0 {
+		columns, err := csv.NewReader(bytes.NewReader(cfg.Schema)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("csv codec: schema: %s", err)
+		}
+		codec.columns = columns
+	}
+	return codec, nil
+}
+
+func (c *csvCodec) ContentType() string {
+	return ContentTypeCSV
+}
+
+func (c *csvCodec) Decode(data []byte) (map[string]interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("csv codec: decode: %s", err)
+	}
+	columns := c.columns
+	if columns == nil {
+		if len(records) < 2 {
+			return nil, fmt.Errorf("csv codec: decode: expecting header and record")
+		}
+		columns, records = records[0], records[1:]
+	}
+	if len(records) != 1 {
+		return nil, fmt.Errorf("csv codec: decode: expecting a single record, got %d", len(records))
+	}
+	if len(records[0]) != len(columns) {
+		return nil, fmt.Errorf("csv codec: decode: record has %d fields, expecting %d", len(records[0]), len(columns))
+	}
+	result := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		result[col] = records[0][i]
+	}
+	return result, nil
+}
+
+func (c *csvCodec) Encode(data interface{}) ([]byte, error) {
+	var rows []map[string]interface{}
+	switch val := data.(type) {
+	case map[string]interface{}:
+		rows = append(rows, val)
+	case []interface{}:
+		for _, item := range val {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("csv codec: encode: unexpected row type: %T", item)
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, fmt.Errorf("csv codec: encode: unexpected type: %T", data)
+	}
+
+	columns := c.columns
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if columns == nil && len(rows) > 0 {
+		for col := range rows[0] {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+		if err := writer.Write(columns); err != nil {
+			return nil, fmt.Errorf("csv codec: encode: %s", err)
+		}
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			if val, ok := row[col]; ok && val != nil {
+				record[i] = fmt.Sprintf("%v", val)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, fmt.Errorf("csv codec: encode: %s", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("csv codec: encode: %s", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,243 @@
+package support
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// orderDescriptorSet returns a serialized descriptor set declaring the message test.Order
+func orderDescriptorSet(t *testing.T) []byte {
+	t.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   kind.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	sizes := field("sizes", 5, descriptorpb.FieldDescriptorProto_TYPE_INT64, "")
+	sizes.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	counts := field("counts", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Order.CountsEntry")
+	counts.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/order.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("amount", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("seq", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, ""),
+					field("qty", 4, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					sizes,
+					field("item", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Item"),
+					counts,
+					field("price", 8, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("CountsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+			{
+				Name:  proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("n", 1, descriptorpb.FieldDescriptorProto_TYPE_SINT64, "")},
+			},
+		},
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	avroSchema := `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "long"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "note", "type": ["null", "string"]}
+	]}`
+	tests := []struct {
+		name string
+		cfg  CodecConfig
+		data map[string]interface{}
+		want map[string]interface{} // decoded data, data if nil
+	}{
+		{
+			name: "json",
+			cfg:  CodecConfig{ContentType: ContentTypeJSON},
+			data: map[string]interface{}{"id": "a", "amount": 12.5, "tags": []interface{}{"x"}},
+		},
+		{
+			name: "avro",
+			cfg:  CodecConfig{ContentType: ContentTypeAvro, Schema: []byte(avroSchema)},
+			data: map[string]interface{}{"id": "a", "amount": int64(12), "tags": []interface{}{"x"}, "note": map[string]interface{}{"string": "n"}},
+		},
+		{
+			name: "protobuf",
+			cfg:  CodecConfig{ContentType: ContentTypeProtobuf, Schema: orderDescriptorSet(t), MessageType: "test.Order"},
+			data: map[string]interface{}{
+				"id": "a", "amount": 12345678901234, "seq": 7, "qty": 3, "sizes": []interface{}{1, 2},
+				"item": map[string]interface{}{"n": -1}, "counts": map[string]interface{}{"x": 2}, "price": 1.5,
+			},
+			want: map[string]interface{}{
+				"id": "a", "amount": int64(12345678901234), "seq": uint64(7), "qty": int64(3), "sizes": []interface{}{int64(1), int64(2)},
+				"item": map[string]interface{}{"n": int64(-1)}, "counts": map[string]interface{}{"x": int64(2)}, "price": 1.5,
+			},
+		},
+		{
+			name: "msgpack",
+			cfg:  CodecConfig{ContentType: ContentTypeMsgPack},
+			data: map[string]interface{}{"id": "a", "amount": int64(12), "price": 1.5},
+		},
+		{
+			name: "csv with header",
+			cfg:  CodecConfig{ContentType: ContentTypeCSV},
+			data: map[string]interface{}{"id": "a", "amount": "12"},
+		},
+		{
+			name: "csv with schema",
+			cfg:  CodecConfig{ContentType: ContentTypeCSV, Schema: []byte("id,amount")},
+			data: map[string]interface{}{"id": "a", "amount": 12},
+			want: map[string]interface{}{"id": "a", "amount": "12"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, err := NewCodec(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := codec.Encode(test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := codec.Decode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.want
+			if want == nil {
+				want = test.data
+			}
+			if !reflect.DeepEqual(decoded, want) {
+				t.Errorf("got %#v, want %#v", decoded, want)
+			}
+		})
+	}
+}
+
+func TestProtobufCodecIntegers(t *testing.T) {
+	codec, err := NewCodec(CodecConfig{ContentType: ContentTypeProtobuf, Schema: orderDescriptorSet(t), MessageType: "test.Order"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := codec.Encode(map[string]interface{}{"amount": 250, "item": map[string]interface{}{"n": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 64 bits integers are compared with ints, in untyped expressions
+	prog, err := CompileWhereProg("o.amount > 100 && o.item.n == 2", 0, StreamVar{Name: "o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := EvalWhere(prog, map[string]interface{}{"o": decoded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("got false, want true")
+	}
+}
+
+func TestCodecFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "schema.csv")
+	if err := ioutil.WriteFile(schemaFile, []byte("a,b"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantNil bool
+		want    map[string]interface{} // decoding of "1,2"
+		wantErr string
+	}{
+		{name: "no codec", wantNil: true},
+		{name: "unsupported content type", env: map[string]string{"TEST_CODEC": "application/xml"}, wantErr: "unsupported content type: application/xml"},
+		{
+			name:    "missing schema file",
+			env:     map[string]string{"TEST_CODEC": ContentTypeCSV, "TEST_CODEC_SCHEMA_FILE": filepath.Join(dir, "missing")},
+			wantErr: "codec: schema file:",
+		},
+		{name: "avro without schema", env: map[string]string{"TEST_CODEC": ContentTypeAvro}, wantErr: "avro codec: schema required"},
+		{name: "invalid avro schema", env: map[string]string{"TEST_CODEC": ContentTypeAvro, "TEST_CODEC_SCHEMA": "{"}, wantErr: "avro codec:"},
+		{
+			name:    "protobuf without message type",
+			env:     map[string]string{"TEST_CODEC": ContentTypeProtobuf, "TEST_CODEC_SCHEMA": "x"},
+			wantErr: "protobuf codec: descriptor set and message type required",
+		},
+		{
+			name: "inline schema",
+			env:  map[string]string{"TEST_CODEC": ContentTypeCSV, "TEST_CODEC_SCHEMA": "x,y"},
+			want: map[string]interface{}{"x": "1", "y": "2"},
+		},
+		{
+			name: "schema file over inline schema",
+			env:  map[string]string{"TEST_CODEC": "text/csv; charset=utf-8", "TEST_CODEC_SCHEMA": "x,y", "TEST_CODEC_SCHEMA_FILE": schemaFile},
+			want: map[string]interface{}{"a": "1", "b": "2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			codec, err := CodecFromEnv("TEST")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantNil {
+				if codec != nil {
+					t.Fatalf("got codec %T, want nil", codec)
+				}
+				return
+			}
+			got, err := codec.Decode([]byte("1,2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package support
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigValidator is implemented by component configurations that validate
+// their values once they are loaded
+type ConfigValidator interface {
+	Validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfig populates cfg, a pointer to a struct, from environment variables
+// using the following field tags:
+//   - env: name of the environment variable
+//   - default: value used when the environment variable is not set
+//   - required: "true" if the environment variable must be set
+//
+// Supported field types are string, bool, int and time.Duration. Nested structs
+// (without env tag) are loaded recursively. If cfg implements ConfigValidator,
+// it is validated once all fields are loaded.
+func LoadConfig(cfg interface{}) error {
+	value := reflect.ValueOf(cfg)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+	var errs []string
+	loadConfigStruct(value.Elem(), &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %s", strings.Join(errs, "; "))
+	}
+	if validator, ok := cfg.(ConfigValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("config: %s", err)
+		}
+	}
+	return nil
+}
+
+func loadConfigStruct(value reflect.Value, errs *[]string) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				loadConfigStruct(value.Field(i), errs)
+			}
+			continue
+		}
+		env, set := os.LookupEnv(name)
+		if !set || env == "" {
+			if field.Tag.Get("required") == "true" {
+				*errs = append(*errs, fmt.Sprintf("env %s must be provided", name))
+				continue
+			}
+			env = field.Tag.Get("default")
+		}
+		if env == "" {
+			continue
+		}
+		if err := setConfigField(value.Field(i), env); err != nil {
+			*errs = append(*errs, fmt.Sprintf("env %s: %s", name, err))
+		}
+	}
+}
+
+func setConfigField(field reflect.Value, env string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(env)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(env)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
+
+// OneOf returns an error if value is not one of the valid values
+func OneOf(name, value string, valid ...string) error {
+	for _, v := range valid {
+		if value == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s", name, strings.Join(valid, ", "))
+}
@@ -0,0 +1,116 @@
+package support
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Port    string        `env:"TEST_CONFIG_PORT" default:":8080"`
+	Count   int           `env:"TEST_CONFIG_COUNT"`
+	Enabled bool          `env:"TEST_CONFIG_ENABLED"`
+	Window  time.Duration `env:"TEST_CONFIG_WINDOW" default:"10ms"`
+	Mode    string        `env:"TEST_CONFIG_MODE" default:"batch"`
+	Nested  struct {
+		Name string `env:"TEST_CONFIG_NAME" required:"true"`
+	}
+}
+
+func (c *testConfig) Validate() error {
+	return OneOf("TEST_CONFIG_MODE", c.Mode, "batch", "each")
+}
+
+// setEnv sets the environment variables for the duration of the test
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		old, set := os.LookupEnv(name)
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+		name := name
+		t.Cleanup(func() {
+			if set {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    testConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"TEST_CONFIG_NAME": "n"},
+			want: testConfig{Port: ":8080", Window: 10 * time.Millisecond, Mode: "batch"},
+		},
+		{
+			name: "all set",
+			env: map[string]string{
+				"TEST_CONFIG_NAME":    "n",
+				"TEST_CONFIG_PORT":    ":9090",
+				"TEST_CONFIG_COUNT":   "3",
+				"TEST_CONFIG_ENABLED": "true",
+				"TEST_CONFIG_WINDOW":  "1m",
+				"TEST_CONFIG_MODE":    "each",
+			},
+			want: testConfig{Port: ":9090", Count: 3, Enabled: true, Window: time.Minute, Mode: "each"},
+		},
+		{
+			name:    "required missing",
+			env:     map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "malformed int",
+			env:     map[string]string{"TEST_CONFIG_NAME": "n", "TEST_CONFIG_COUNT": "three"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed duration",
+			env:     map[string]string{"TEST_CONFIG_NAME": "n", "TEST_CONFIG_WINDOW": "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			env:     map[string]string{"TEST_CONFIG_NAME": "n", "TEST_CONFIG_MODE": "stream"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			var cfg testConfig
+			err := LoadConfig(&cfg)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got config %+v", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.want.Nested.Name = "n"
+			if fmt.Sprintf("%+v", cfg) != fmt.Sprintf("%+v", test.want) {
+				t.Errorf("got config %+v, want %+v", cfg, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigNotStructPointer(t *testing.T) {
+	var cfg testConfig
+	if err := LoadConfig(cfg); err == nil {
+		t.Error("expected error for non-pointer config")
+	}
+}
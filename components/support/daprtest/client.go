@@ -0,0 +1,252 @@
+// Package daprtest provides an in-memory fake of the Dapr client and service,
+// used to test components without a Dapr sidecar.
+package daprtest
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Published is an event published with the fake client
+type Published struct {
+	Pubsub      string
+	Topic       string
+	Data        []byte
+	ContentType string
+	Metadata    map[string]string
+}
+
+// Invocation is a service invocation made with the fake client
+type Invocation struct {
+	AppID       string
+	Method      string
+	Verb        string
+	Data        []byte
+	ContentType string
+	Metadata    map[string]string // gRPC metadata of the invocation
+}
+
+// Client is an in-memory Dapr client. It records published events and service
+// invocations, and keeps state in memory. It also acts as a Dapr service: events
+// published to a topic, and invocations of a method, are delivered to the handlers
+// registered for them.
+type Client struct {
+	mu          sync.Mutex
+	published   []Published
+	invocations []Invocation
+	state       map[string]map[string]*dapr.StateItem // store -> key -> item
+	etag        int
+
+	invocationHandlers map[string]common.ServiceInvocationHandler
+	topicHandlers      map[string]common.TopicEventHandler // pubsub/topic -> handler
+
+	// Err, if set, is returned by all client calls
+	Err error
+}
+
+// NewClient returns an empty fake client
+func NewClient() *Client {
+	return &Client{
+		state:              make(map[string]map[string]*dapr.StateItem),
+		invocationHandlers: make(map[string]common.ServiceInvocationHandler),
+		topicHandlers:      make(map[string]common.TopicEventHandler),
+	}
+}
+
+// Published returns the events published with the client
+func (c *Client) Published() []Published {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Published(nil), c.published...)
+}
+
+// Invocations returns the service invocations made with the client
+func (c *Client) Invocations() []Invocation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Invocation(nil), c.invocations...)
+}
+
+// PublishEvent records the event and delivers it to the handler of the topic, if any
+func (c *Client) PublishEvent(ctx context.Context, pubsubName, topicName string, data interface{}, opts ...dapr.PublishEventOption) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	req := &pb.PublishEventRequest{PubsubName: pubsubName, Topic: topicName}
+	for _, opt := range opts {
+		opt(req)
+	}
+	raw, err := toBytes(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.published = append(c.published, Published{
+		Pubsub:      pubsubName,
+		Topic:       topicName,
+		Data:        raw,
+		ContentType: req.DataContentType,
+		Metadata:    req.Metadata,
+	})
+	id := strconv.Itoa(len(c.published))
+	handler := c.topicHandlers[pubsubName+"/"+topicName]
+	c.mu.Unlock()
+
+	if handler == nil {
+		return nil
+	}
+	_, err = handler(ctx, topicEvent(id, pubsubName, topicName, raw, req.DataContentType))
+	return err
+}
+
+// InvokeMethodWithContent records the invocation and delivers it to the handler of the method, if any
+func (c *Client) InvokeMethodWithContent(ctx context.Context, appID, methodName, verb string, content *dapr.DataContent) ([]byte, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	c.mu.Lock()
+	c.invocations = append(c.invocations, Invocation{
+		AppID:       appID,
+		Method:      methodName,
+		Verb:        verb,
+		Data:        content.Data,
+		ContentType: content.ContentType,
+		Metadata:    outgoingMetadata(ctx),
+	})
+	handler := c.invocationHandlers[methodName]
+	c.mu.Unlock()
+
+	if handler == nil {
+		return nil, nil
+	}
+	out, err := handler(ctx, &common.InvocationEvent{Data: content.Data, ContentType: content.ContentType, Verb: verb})
+	if err != nil || out == nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+// GetState returns the item saved for the key. The item is empty if the key has no state.
+func (c *Client) GetState(_ context.Context, storeName, key string) (*dapr.StateItem, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.state[storeName][key]; ok {
+		copied := *item
+		return &copied, nil
+	}
+	return &dapr.StateItem{Key: key}, nil
+}
+
+// SaveBulkState saves the items. Saving an item with an etag fails if the
+// etag does not match the current etag of the item (first write wins). An
+// empty etag matches a key without state.
+func (c *Client) SaveBulkState(_ context.Context, storeName string, items ...*dapr.SetStateItem) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	store, ok := c.state[storeName]
+	if !ok {
+		store = make(map[string]*dapr.StateItem)
+		c.state[storeName] = store
+	}
+	for _, item := range items {
+		if item.Etag != nil {
+			current, ok := store[item.Key]
+			if (ok && current.Etag != item.Etag.Value) || (!ok && item.Etag.Value != "") {
+				return status.Errorf(codes.Aborted, "state store %s: key %s: etag mismatch", storeName, item.Key)
+			}
+		}
+	}
+	for _, item := range items {
+		c.etag++
+		store[item.Key] = &dapr.StateItem{
+			Key:      item.Key,
+			Value:    item.Value,
+			Etag:     strconv.Itoa(c.etag),
+			Metadata: item.Metadata,
+		}
+	}
+	return nil
+}
+
+// outgoingMetadata returns the first value of the outgoing gRPC metadata of the context
+func outgoingMetadata(ctx context.Context) map[string]string {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) > 0 {
+			values[k] = v[0]
+		}
+	}
+	return values
+}
+
+// AddServiceInvocationHandler registers the handler of invocations of the named method
+func (c *Client) AddServiceInvocationHandler(name string, fn common.ServiceInvocationHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invocationHandlers[strings.TrimPrefix(name, "/")] = fn
+	return nil
+}
+
+// AddTopicEventHandler registers the handler of the events of the subscription topic
+func (c *Client) AddTopicEventHandler(sub *common.Subscription, fn common.TopicEventHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topicHandlers[sub.PubsubName+"/"+sub.Topic] = fn
+	return nil
+}
+
+// topicEvent returns the topic event delivered, by the Dapr sidecar, for published data
+func topicEvent(id, pubsubName, topicName string, data []byte, contentType string) *common.TopicEvent {
+	e := &common.TopicEvent{
+		ID:              id,
+		SpecVersion:     "1.0",
+		Type:            "com.dapr.event.sent",
+		DataContentType: contentType,
+		RawData:         data,
+		Topic:           topicName,
+		PubsubName:      pubsubName,
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if contentType == "" || strings.HasSuffix(mediaType, "json") {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err == nil {
+			e.Data = value
+			return e
+		}
+	}
+	e.Data = string(data)
+	return e
+}
+
+func toBytes(data interface{}) ([]byte, error) {
+	switch d := data.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return json.Marshal(d)
+	}
+}
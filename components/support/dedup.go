@@ -0,0 +1,117 @@
+package support
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/client"
+	lru "github.com/hashicorp/golang-lru"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultDedupTTL  = 10 * time.Minute
+	DefaultDedupSize = 10000
+
+	dedupKeyPrefix = "dedup-"
+)
+
+// Deduplicator tracks the ids of seen events, for a TTL, in a bounded LRU set.
+// The set can be backed by a Dapr state store, so that it survives restarts
+// and is shared across replicas.
+type Deduplicator struct {
+	ttl    time.Duration
+	seen   *lru.Cache // id -> expiration time
+	client StateStore
+	store  string
+}
+
+// NewDeduplicator returns a Deduplicator remembering up to size ids for ttl.
+// If store is empty, ids are only tracked in memory.
+func NewDeduplicator(size int, ttl time.Duration, client StateStore, store string) (*Deduplicator, error) {
+	if size <= 0 {
+		size = DefaultDedupSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultDedupTTL
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("dedup: %s", err)
+	}
+	return &Deduplicator{ttl: ttl, seen: cache, client: client, store: store}, nil
+}
+
+// Seen reports whether the id was seen within the TTL. If not, the id is recorded.
+func (d *Deduplicator) Seen(ctx context.Context, id string) (bool, error) {
+	now := time.Now()
+	if expires, ok := d.seen.Get(id); ok && now.Before(expires.(time.Time)) {
+		return true, nil
+	}
+
+	var etag string // the etag of an expired id, empty if the id is not in the store
+	if d.store != "" {
+		item, err := d.client.GetState(ctx, d.store, dedupKeyPrefix+id)
+		if err != nil {
+			return false, fmt.Errorf("dedup: state store %s: %s", d.store, err)
+		}
+		if item != nil && len(item.Value) > 0 {
+			// the expiration is stored for state stores that do not support TTL
+			var expires time.Time
+			if err := json.Unmarshal(item.Value, &expires); err == nil && now.Before(expires) {
+				d.seen.Add(id, expires)
+				return true, nil
+			}
+			etag = item.Etag
+		}
+	}
+
+	expires := now.Add(d.ttl)
+	d.seen.Add(id, expires)
+	if d.store != "" {
+		value, err := json.Marshal(expires)
+		if err != nil {
+			return false, fmt.Errorf("dedup: %s", err)
+		}
+		ttlSeconds := int(d.ttl.Seconds())
+		if ttlSeconds < 1 {
+			ttlSeconds = 1
+		}
+		// first write wins: the id was recorded by another replica since it was loaded
+		item := &dapr.SetStateItem{
+			Key:      dedupKeyPrefix + id,
+			Value:    value,
+			Etag:     &dapr.ETag{Value: etag},
+			Metadata: map[string]string{"ttlInSeconds": strconv.Itoa(ttlSeconds)},
+			Options:  &dapr.StateOptions{Concurrency: dapr.StateConcurrencyFirstWrite},
+		}
+		if err := d.client.SaveBulkState(ctx, d.store, item); err != nil {
+			// Dapr reports etag mismatches as Aborted
+			if status.Code(err) == codes.Aborted {
+				return true, nil
+			}
+			return false, fmt.Errorf("dedup: state store %s: %s", d.store, err)
+		}
+	}
+	return false, nil
+}
+
+// CloudEventID returns the id of a JSON-encoded CloudEvent. If the data is not a
+// CloudEvent, the SHA-256 digest of the data is returned.
+func CloudEventID(data []byte) string {
+	var event struct {
+		ID          string `json:"id"`
+		SpecVersion string `json:"specversion"`
+	}
+	if err := json.Unmarshal(data, &event); err == nil && event.ID != "" && event.SpecVersion != "" {
+		return event.ID
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,154 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"google.golang.org/grpc/metadata"
+)
+
+// Target is a stream (pubsub/topic) and/or a component (app-id/route) where output data is sent
+type Target struct {
+	StreamParts    []string
+	ComponentParts []string
+	Codec          Codec // encodes output data, if nil, output is JSON
+}
+
+// NewTarget returns a target for the stream and/or component paths
+func NewTarget(stream, component string, codec Codec) (*Target, error) {
+	streamParts, err := GetTargetParts(stream)
+	if err != nil {
+		return nil, fmt.Errorf("stream target: %s", err)
+	}
+	componentParts, err := GetTargetParts(component)
+	if err != nil {
+		return nil, fmt.Errorf("component target: %s", err)
+	}
+	return &Target{StreamParts: streamParts, ComponentParts: componentParts, Codec: codec}, nil
+}
+
+// TargetFromEnv returns a target configured with the following environment variables:
+//   - <prefix>_STREAM: "pubsub/topic" path where to publish data
+//   - <prefix>_COMPONENT: "component/route" path where to send data
+//   - <prefix>_CODEC*: codec used to encode data (see CodecFromEnv)
+//
+// It returns nil if neither a stream nor a component is configured.
+func TargetFromEnv(prefix string) (*Target, error) {
+	stream, component := os.Getenv(prefix+"_STREAM"), os.Getenv(prefix+"_COMPONENT")
+	if stream == "" && component == "" {
+		return nil, nil
+	}
+	codec, err := CodecFromEnv(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("target codec: %s", err)
+	}
+	return NewTarget(stream, component, codec)
+}
+
+// String returns the stream and component paths of the target
+func (t *Target) String() string {
+	return fmt.Sprintf("stream(%v) component(%v)", t.StreamParts, t.ComponentParts)
+}
+
+// ContentType returns the content type of the data encoded for the target
+func (t *Target) ContentType() string {
+	if t.Codec == nil {
+		return ContentTypeJSON
+	}
+	return t.Codec.ContentType()
+}
+
+// Encode encodes data using the target codec, or JSON if the target has no codec
+func (t *Target) Encode(data interface{}) ([]byte, error) {
+	if t.Codec == nil {
+		return json.Marshal(data)
+	}
+	return t.Codec.Encode(data)
+}
+
+// Sink receives the events produced by a pipeline
+type Sink interface {
+	Send(ctx context.Context, e *Event) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, e *Event) error
+
+// Send calls f(ctx, e)
+func (f SinkFunc) Send(ctx context.Context, e *Event) error {
+	return f(ctx, e)
+}
+
+// TargetSink returns a sink sending events to their target, or to the default
+// target if events have none. If there is no target, events are dropped.
+func TargetSink(client Dispatcher, target *Target) Sink {
+	return SinkFunc(func(ctx context.Context, e *Event) error {
+		t := e.Target
+		if t == nil {
+			t = target
+		}
+		if t == nil {
+			log.Printf("no target: dropping event: %s", string(e.Data))
+			return nil
+		}
+		if err := Send(ctx, client, t, e); err != nil {
+			return err
+		}
+		log.Printf("output sent to %s: %s", t, string(e.Data))
+		return nil
+	})
+}
+
+// Send publishes the event data to the stream target and/or invokes the component target.
+// The event metadata is sent as publish metadata and as invocation headers.
+func Send(ctx context.Context, client Dispatcher, t *Target, e *Event) error {
+	var errs []error
+	if parts := t.StreamParts; len(parts) > 0 {
+		pubsub, topic := parts[0], parts[1]
+		opts := []dapr.PublishEventOption{dapr.PublishEventWithContentType(e.ContentType)}
+		if len(e.Metadata) > 0 {
+			opts = append(opts, dapr.PublishEventWithMetadata(e.Metadata))
+		}
+		if err := client.PublishEvent(ctx, pubsub, topic, e.Data, opts...); err != nil {
+			errs = append(errs, fmt.Errorf("target pubsub/stream %s/%s: %s", pubsub, topic, err))
+		}
+	}
+
+	if parts := t.ComponentParts; len(parts) > 0 {
+		content := &dapr.DataContent{
+			Data:        e.Data,
+			ContentType: e.ContentType,
+		}
+		componentId, route := parts[0], parts[1]
+		if _, err := client.InvokeMethodWithContent(invocationContext(ctx, e.Metadata), componentId, route, http.MethodPost, content); err != nil {
+			errs = append(errs, fmt.Errorf("target component service %s/%s: %s", componentId, route, err))
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%s; %s", errs[0], errs[1])
+	}
+}
+
+// invocationContext returns a context sending the metadata as gRPC metadata of
+// the invocation, delivered by Dapr as request headers (with lowercase names)
+func invocationContext(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	pairs := make([]string, 0, 2*len(md))
+	for k, v := range md {
+		pairs = append(pairs, k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
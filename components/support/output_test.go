@@ -0,0 +1,82 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/vladimirvivien/streaming-runtime/components/support/daprtest"
+)
+
+func TestTargetSink(t *testing.T) {
+	stream := &Target{StreamParts: []string{"pubsub", "out"}}
+	component := &Target{ComponentParts: []string{"proc", "events"}}
+	both := &Target{StreamParts: []string{"pubsub", "both"}, ComponentParts: []string{"proc", "both"}}
+	tests := []struct {
+		name            string
+		sinkTarget      *Target
+		eventTarget     *Target
+		metadata        map[string]string
+		wantPublished   []string // pubsub/topic
+		wantInvocations []string // app/method
+	}{
+		{name: "default stream target", sinkTarget: stream, wantPublished: []string{"pubsub/out"}},
+		{name: "default component target", sinkTarget: component, wantInvocations: []string{"proc/events"}},
+		{name: "event target", sinkTarget: stream, eventTarget: component, wantInvocations: []string{"proc/events"}},
+		{name: "stream and component", eventTarget: both, wantPublished: []string{"pubsub/both"}, wantInvocations: []string{"proc/both"}},
+		{name: "metadata", sinkTarget: stream, metadata: map[string]string{"windowEnd": "now"}, wantPublished: []string{"pubsub/out"}},
+		{name: "invocation metadata", sinkTarget: component, metadata: map[string]string{"windowEnd": "now"}, wantInvocations: []string{"proc/events"}},
+		{name: "no target"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := daprtest.NewClient()
+			e := &Event{Data: []byte(`{"a":1}`), ContentType: ContentTypeJSON, Target: test.eventTarget, Metadata: test.metadata}
+			if err := TargetSink(client, test.sinkTarget).Send(context.Background(), e); err != nil {
+				t.Fatal(err)
+			}
+
+			published := client.Published()
+			if len(published) != len(test.wantPublished) {
+				t.Fatalf("got %d published events, want %d", len(published), len(test.wantPublished))
+			}
+			for i, p := range published {
+				if got := p.Pubsub + "/" + p.Topic; got != test.wantPublished[i] {
+					t.Errorf("published to %s, want %s", got, test.wantPublished[i])
+				}
+				if p.ContentType != ContentTypeJSON || string(p.Data) != `{"a":1}` {
+					t.Errorf("published %s (%s)", p.Data, p.ContentType)
+				}
+				if p.Metadata["windowEnd"] != test.metadata["windowEnd"] {
+					t.Errorf("got metadata %v, want %v", p.Metadata, test.metadata)
+				}
+			}
+
+			invocations := client.Invocations()
+			if len(invocations) != len(test.wantInvocations) {
+				t.Fatalf("got %d invocations, want %d", len(invocations), len(test.wantInvocations))
+			}
+			for i, inv := range invocations {
+				if got := inv.AppID + "/" + inv.Method; got != test.wantInvocations[i] {
+					t.Errorf("invoked %s, want %s", got, test.wantInvocations[i])
+				}
+				if inv.Verb != http.MethodPost || string(inv.Data) != `{"a":1}` {
+					t.Errorf("invoked %s with %s", inv.Verb, inv.Data)
+				}
+				if inv.Metadata["windowend"] != test.metadata["windowEnd"] {
+					t.Errorf("got invocation metadata %v, want %v", inv.Metadata, test.metadata)
+				}
+			}
+		})
+	}
+}
+
+func TestTargetSinkError(t *testing.T) {
+	client := daprtest.NewClient()
+	client.Err = errors.New("unavailable")
+	target := &Target{StreamParts: []string{"pubsub", "out"}, ComponentParts: []string{"proc", "events"}}
+	if err := TargetSink(client, target).Send(context.Background(), &Event{Data: []byte(`{}`)}); err == nil {
+		t.Error("expected error")
+	}
+}
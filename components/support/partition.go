@@ -0,0 +1,134 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/google/cel-go/cel"
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	// ForwardedMetadata marks the events forwarded by another replica to the owner of their partition
+	ForwardedMetadata = "forwarded"
+
+	partitionRoute = "partition"
+)
+
+// Forwarder sends an event to the replica owning a partition
+type Forwarder interface {
+	Forward(ctx context.Context, partition int, e *Event) error
+}
+
+// ForwarderFunc adapts a function to a Forwarder
+type ForwarderFunc func(ctx context.Context, partition int, e *Event) error
+
+// Forward calls f(ctx, partition, e)
+func (f ForwarderFunc) Forward(ctx context.Context, partition int, e *Event) error {
+	return f(ctx, partition, e)
+}
+
+// Partitioner assigns event keys to partitions, one per replica of a component. A
+// replica keeps the events of the partition it owns and forwards the others.
+type Partitioner struct {
+	Partitions int // number of partitions
+	Self       int // partition owned by the replica
+	Forwarder  Forwarder
+}
+
+// PartitionOf returns the partition of a key
+func PartitionOf(key string, partitions int) int {
+	return int(murmur3.Sum32([]byte(key)) % uint32(partitions))
+}
+
+// Partition returns a stage keeping the events of the partition owned by the replica
+// and forwarding the other events. The partition of an event is computed from the key
+// program, evaluated with the event activation. Forwarded events are always kept.
+func Partition(keyProg cel.Program, p *Partitioner) Stage {
+	return func(ctx context.Context, e *Event) ([]*Event, error) {
+		if p == nil || p.Partitions <= 1 || e.Metadata[ForwardedMetadata] != "" {
+			return []*Event{e}, nil
+		}
+		result, _, err := keyProg.Eval(e.Activation)
+		if err != nil {
+			return nil, fmt.Errorf("partition key: %s", err)
+		}
+		partition := PartitionOf(fmt.Sprint(result.Value()), p.Partitions)
+		if partition == p.Self {
+			return []*Event{e}, nil
+		}
+		forwarded := &Event{Data: e.Data, ContentType: e.ContentType, Topic: e.Topic, ID: e.ID}
+		if err := p.Forwarder.Forward(ctx, partition, forwarded); err != nil {
+			return nil, fmt.Errorf("partition %d: forward: %s", partition, err)
+		}
+		return nil, nil
+	}
+}
+
+// PartitionRoute returns the route where forwarded events of a topic are received
+func PartitionRoute(topic string) string {
+	return fmt.Sprintf("%s/%s", partitionRoute, topic)
+}
+
+// PartitionHandler returns the handler receiving the events of a topic forwarded
+// by other replicas, sent to the pipeline
+func (p *Pipeline) PartitionHandler(topic string) common.ServiceInvocationHandler {
+	return func(_ context.Context, in *common.InvocationEvent) (*common.Content, error) {
+		if in == nil {
+			return nil, fmt.Errorf("invocation parameter required")
+		}
+		e := NewInvocationEvent(in)
+		e.Topic = topic
+		e.Metadata = map[string]string{ForwardedMetadata: "true"}
+		p.Send(e)
+		return nil, nil
+	}
+}
+
+// NewHTTPForwarder returns a forwarder posting events to the partition route of the
+// replicas. The replica URL is peerURL where {partition} is replaced by the partition,
+// i.e. http://joiner-{partition}.joiner-partitions:8080
+func NewHTTPForwarder(peerURL string, client *http.Client) Forwarder {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return ForwarderFunc(func(ctx context.Context, partition int, e *Event) error {
+		url := fmt.Sprintf("%s/%s", strings.Replace(peerURL, "{partition}", strconv.Itoa(partition), -1), PartitionRoute(e.Topic))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(e.Data))
+		if err != nil {
+			return err
+		}
+		if e.ContentType != "" {
+			req.Header.Set("Content-Type", e.ContentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("%s: %s: %s", url, resp.Status, body)
+		}
+		return nil
+	})
+}
+
+// Ordinal returns the ordinal suffix of a StatefulSet pod name (i.e. 2 for joiner-2)
+func Ordinal(podName string) (int, error) {
+	i := strings.LastIndex(podName, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("pod name %s has no ordinal", podName)
+	}
+	ordinal, err := strconv.Atoi(podName[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("pod name %s has no ordinal", podName)
+	}
+	return ordinal, nil
+}
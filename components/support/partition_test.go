@@ -0,0 +1,102 @@
+package support
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	stream := StreamVar{Name: "s"}
+	keyProg := compileProg(t, CompileKeyProg, "s.id", stream)
+	keyA, keyB := "a", "b"
+	for PartitionOf(keyA, 2) == PartitionOf(keyB, 2) {
+		keyB += "b"
+	}
+	self := PartitionOf(keyA, 2)
+
+	tests := []struct {
+		name          string
+		data          string
+		forwarded     bool
+		wantKept      bool
+		wantForwarded int // partition, -1 if not forwarded
+	}{
+		{name: "own partition", data: `{"id":"` + keyA + `"}`, wantKept: true, wantForwarded: -1},
+		{name: "other partition", data: `{"id":"` + keyB + `"}`, wantForwarded: 1 - self},
+		{name: "forwarded event", data: `{"id":"` + keyB + `"}`, forwarded: true, wantKept: true, wantForwarded: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwardedTo := -1
+			p := &Partitioner{Partitions: 2, Self: self, Forwarder: ForwarderFunc(func(_ context.Context, partition int, e *Event) error {
+				forwardedTo = partition
+				if string(e.Data) != test.data {
+					t.Errorf("forwarded %s", e.Data)
+				}
+				return nil
+			})}
+			e := bindEvent(t, stream, test.data)
+			if test.forwarded {
+				e.Metadata = map[string]string{ForwardedMetadata: "true"}
+			}
+			events, err := Partition(keyProg, p)(context.Background(), e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(events) == 1; got != test.wantKept {
+				t.Errorf("got kept %t, want %t", got, test.wantKept)
+			}
+			if forwardedTo != test.wantForwarded {
+				t.Errorf("got forwarded to %d, want %d", forwardedTo, test.wantForwarded)
+			}
+		})
+	}
+}
+
+func TestHTTPForwarder(t *testing.T) {
+	type request struct {
+		path, contentType, body string
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: string(body)}
+	}))
+	defer server.Close()
+
+	forwarder := NewHTTPForwarder(server.URL+"/replica-{partition}", server.Client())
+	e := &Event{Data: []byte(`{"id":"a"}`), ContentType: ContentTypeJSON, Topic: "orders"}
+	if err := forwarder.Forward(context.Background(), 3, e); err != nil {
+		t.Fatal(err)
+	}
+	got := <-requests
+	want := request{path: "/replica-3/partition/orders", contentType: ContentTypeJSON, body: `{"id":"a"}`}
+	if got != want {
+		t.Errorf("got request %+v, want %+v", got, want)
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{name: "joiner-0", want: 0},
+		{name: "orders-joiner-12", want: 12},
+		{name: "joiner", wantErr: true},
+		{name: "joiner-abc", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := Ordinal(test.name)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package support
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/google/cel-go/cel"
+)
+
+const pipelineBufferSize = 1024
+
+// Event is a stream event flowing through a pipeline
+type Event struct {
+	Data        []byte                 // raw (encoded) data
+	ContentType string                 // content type of the data
+	Topic       string                 // topic of the event, empty for service invocations
+	ID          string                 // id of the event, if known
+	Value       map[string]interface{} // decoded data, set by the Decode stage
+	Activation  map[string]interface{} // expression variables bound to the event, set by the Bind stage
+	Metadata    map[string]string      // publish metadata
+	Target      *Target                // target of the event, if nil, the sink default target is used
+}
+
+// NewInvocationEvent returns an event for data received via service invocation
+func NewInvocationEvent(e *common.InvocationEvent) *Event {
+	return &Event{Data: e.Data, ContentType: e.ContentType}
+}
+
+// NewTopicEvent returns an event for data received from a pubsub topic
+func NewTopicEvent(e *common.TopicEvent) (*Event, error) {
+	event := &Event{Data: e.RawData, ContentType: e.DataContentType, Topic: e.Topic, ID: e.ID}
+	switch {
+	case e.DataBase64 != "":
+		data, err := base64.StdEncoding.DecodeString(e.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("topic event: data_base64: %s", err)
+		}
+		event.Data = data
+	case !isJSON(e.DataContentType):
+		// non-JSON payloads are delivered as JSON strings
+		if str, ok := e.Data.(string); ok {
+			event.Data = []byte(str)
+		}
+	}
+	return event, nil
+}
+
+// Stage processes an event and returns the resulting events. Returning no
+// event drops the event; returning an error drops it and logs the error.
+type Stage func(ctx context.Context, e *Event) ([]*Event, error)
+
+// Chain returns a stage applying the stages in sequence
+func Chain(stages ...Stage) Stage {
+	return func(ctx context.Context, e *Event) ([]*Event, error) {
+		events := []*Event{e}
+		for _, stage := range stages {
+			var next []*Event
+			for _, event := range events {
+				result, err := stage(ctx, event)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, result...)
+			}
+			if len(next) == 0 {
+				return nil, nil
+			}
+			events = next
+		}
+		return events, nil
+	}
+}
+
+// Decode returns a stage decoding the event data using codec. If codec is nil,
+// one is selected using the content type of the event.
+func Decode(codec Codec) Stage {
+	return func(_ context.Context, e *Event) ([]*Event, error) {
+		if normalizeContentType(e.ContentType) == ContentTypeCloudEvent {
+			var cloudevent map[string]interface{}
+			if err := json.Unmarshal(e.Data, &cloudevent); err != nil {
+				return nil, fmt.Errorf("decode data: %s", err)
+			}
+			value, err := decodeCloudEventData(cloudevent, codec)
+			if err != nil {
+				return nil, fmt.Errorf("decode data: %s", err)
+			}
+			e.Value = value
+			return []*Event{e}, nil
+		}
+		c := codec
+		if c == nil {
+			var err error
+			if c, err = NewCodec(CodecConfig{ContentType: e.ContentType}); err != nil {
+				return nil, fmt.Errorf("decode data: unsupported event type: %s", e.ContentType)
+			}
+		}
+		value, err := c.Decode(e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode data: %s", err)
+		}
+		e.Value = value
+		return []*Event{e}, nil
+	}
+}
+
+// Validate returns a stage validating decoded events against a schema. Invalid
+// events are sent, as received, to the violations sink. If validator is nil,
+// events are not validated.
+func Validate(validator SchemaValidator, violations Sink) Stage {
+	return func(ctx context.Context, e *Event) ([]*Event, error) {
+		if validator == nil {
+			return []*Event{e}, nil
+		}
+		if err := validator.Validate(e.Value); err != nil {
+			log.Printf("schema violation: %s", err)
+			violation := &Event{Data: e.Data, ContentType: e.ContentType, Topic: e.Topic, ID: e.ID}
+			if err := violations.Send(ctx, violation); err != nil {
+				return nil, fmt.Errorf("schema violation: %s", err)
+			}
+			return nil, nil
+		}
+		return []*Event{e}, nil
+	}
+}
+
+// Bind returns a stage binding the decoded event to its (typed) stream variable
+func Bind(v StreamVar) Stage {
+	return func(_ context.Context, e *Event) ([]*Event, error) {
+		value, err := StreamValue(v, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if e.Activation == nil {
+			e.Activation = make(map[string]interface{})
+		}
+		e.Activation[v.Name] = value
+		return []*Event{e}, nil
+	}
+}
+
+// Where returns a stage dropping events for which the where program is false.
+// If prog is nil, all events are kept.
+func Where(prog cel.Program) Stage {
+	return func(_ context.Context, e *Event) ([]*Event, error) {
+		ok, err := EvalWhere(prog, e.Activation)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []*Event{e}, nil
+	}
+}
+
+// EvalWhere evaluates a where program. If prog is nil, it returns true.
+func EvalWhere(prog cel.Program, activation map[string]interface{}) (bool, error) {
+	if prog == nil {
+		return true, nil
+	}
+	result, _, err := prog.Eval(activation)
+	if err != nil {
+		return false, fmt.Errorf("where expression: %s", err)
+	}
+	ok, isBool := result.Value().(bool)
+	if !isBool {
+		return false, fmt.Errorf("where expression: must return a boolean")
+	}
+	return ok, nil
+}
+
+// Select returns a stage applying the select program to the event and encoding
+// the result for the target. When the program returns a list, an event is returned
+// for each element (with its index in indexField, if set). If prog is nil, the
+// original event is sent (re-encoded if the target has a codec).
+func Select(prog cel.Program, indexField string, target *Target) Stage {
+	return func(_ context.Context, e *Event) ([]*Event, error) {
+		return SelectEvents(e, prog, indexField, target)
+	}
+}
+
+// SelectEvents applies the select program to the event (see Select)
+func SelectEvents(e *Event, prog cel.Program, indexField string, target *Target) ([]*Event, error) {
+	if prog == nil {
+		data := e.Data
+		if target.Codec != nil {
+			encoded, err := target.Codec.Encode(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("select: %s", err)
+			}
+			data = encoded
+		}
+		return []*Event{{Data: data, ContentType: target.ContentType(), Topic: e.Topic, ID: e.ID, Target: target}}, nil
+	}
+
+	result, _, err := prog.Eval(e.Activation)
+	if err != nil {
+		return nil, fmt.Errorf("select expression: %s", err)
+	}
+	values, err := ResultStructs(result, indexField)
+	if err != nil {
+		return nil, fmt.Errorf("select expression: %s", err)
+	}
+	var events []*Event
+	for _, value := range values {
+		var data []byte
+		if target.Codec == nil {
+			data, err = value.MarshalJSON()
+		} else {
+			data, err = target.Codec.Encode(value.AsMap())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("select: %s", err)
+		}
+		events = append(events, &Event{Data: data, ContentType: target.ContentType(), Topic: e.Topic, Target: target})
+	}
+	return events, nil
+}
+
+// Pipeline processes the events received from its sources with its stages and
+// sends the resulting events to its sink. Stages and sink run in separate
+// goroutines, connected by buffered channels.
+type Pipeline struct {
+	name   string
+	stage  Stage
+	sink   Sink
+	input  chan *Event
+	output chan *Event
+}
+
+// NewPipeline returns a pipeline applying the stages, in sequence, to its events
+func NewPipeline(name string, sink Sink, stages ...Stage) *Pipeline {
+	return &Pipeline{
+		name:   name,
+		stage:  Chain(stages...),
+		sink:   sink,
+		input:  make(chan *Event, pipelineBufferSize),
+		output: make(chan *Event, pipelineBufferSize),
+	}
+}
+
+// Send sends an event to the pipeline stages
+func (p *Pipeline) Send(e *Event) {
+	p.input <- e
+}
+
+// Emit sends an event directly to the pipeline sink
+func (p *Pipeline) Emit(e *Event) {
+	p.output <- e
+}
+
+// Flush sends events to the pipeline sink and waits until they are sent. Unlike Emit,
+// it can be used once the pipeline is done, to send the last events on shutdown.
+func (p *Pipeline) Flush(ctx context.Context, events ...*Event) {
+	for _, e := range events {
+		if len(e.Data) == 0 {
+			log.Printf("%s: data output is empty", p.name)
+			continue
+		}
+		if err := p.sink.Send(ctx, e); err != nil {
+			log.Printf("%s: %s", p.name, err)
+		}
+	}
+}
+
+// InvocationHandler returns a service invocation handler sending events to the pipeline
+func (p *Pipeline) InvocationHandler() common.ServiceInvocationHandler {
+	return func(ctx context.Context, e *common.InvocationEvent) (*common.Content, error) {
+		log.Printf("%s: event received: content-type: %s, data(%s)", p.name, e.ContentType, string(e.Data))
+		p.Send(NewInvocationEvent(e))
+		return &common.Content{Data: e.Data, ContentType: e.ContentType, DataTypeURL: e.DataTypeURL}, nil
+	}
+}
+
+// TopicEventHandler returns a topic event handler sending events to the pipeline
+func (p *Pipeline) TopicEventHandler() common.TopicEventHandler {
+	return func(ctx context.Context, e *common.TopicEvent) (bool, error) {
+		event, err := NewTopicEvent(e)
+		if err != nil {
+			log.Printf("%s: topic %s: dropping event: %s", p.name, e.Topic, err)
+			return false, nil
+		}
+		p.Send(event)
+		return false, nil
+	}
+}
+
+// Run processes events until the context is done
+func (p *Pipeline) Run(ctx context.Context) {
+	log.Printf("%s: starting pipeline", p.name)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case e := <-p.output:
+				if len(e.Data) == 0 {
+					log.Printf("%s: data output is empty", p.name)
+					continue
+				}
+				if err := p.sink.Send(ctx, e); err != nil {
+					log.Printf("%s: %s", p.name, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e := <-p.input:
+			events, err := p.stage(ctx, e)
+			if err != nil {
+				log.Printf("%s: %s", p.name, err)
+				continue
+			}
+			for _, event := range events {
+				p.output <- event
+			}
+		case <-ctx.Done():
+			<-done
+			log.Printf("%s: pipeline done", p.name)
+			return
+		}
+	}
+}
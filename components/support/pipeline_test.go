@@ -0,0 +1,233 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/google/cel-go/cel"
+)
+
+// bindEvent decodes JSON data and binds it to the stream variable
+func bindEvent(t *testing.T, stream StreamVar, data string) *Event {
+	t.Helper()
+	events, err := Chain(Decode(nil), Bind(stream))(context.Background(), &Event{Data: []byte(data), ContentType: ContentTypeJSON})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("bind event %s: %v", data, err)
+	}
+	return events[0]
+}
+
+// compileProg compiles the expression, if any, with the compile function
+func compileProg(t *testing.T, compile func(string, int, ...StreamVar) (cel.Program, error), expr string, vars ...StreamVar) cel.Program {
+	t.Helper()
+	if expr == "" {
+		return nil
+	}
+	prog, err := compile(expr, 0, vars...)
+	if err != nil {
+		t.Fatalf("compile %s: %s", expr, err)
+	}
+	return prog
+}
+
+// dataEqual compares data, as JSON values if both are JSON
+func dataEqual(data []byte, want string) bool {
+	var got, wanted interface{}
+	if json.Unmarshal(data, &got) != nil || json.Unmarshal([]byte(want), &wanted) != nil {
+		return string(data) == want
+	}
+	return reflect.DeepEqual(got, wanted)
+}
+
+func TestWhere(t *testing.T) {
+	stream := StreamVar{Name: "orders"}
+	tests := []struct {
+		name    string
+		expr    string
+		data    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "no expression", data: `{"qty": 1}`, want: true},
+		{name: "match", expr: "orders.qty > 10.0", data: `{"qty": 12}`, want: true},
+		{name: "no match", expr: "orders.qty > 10.0", data: `{"qty": 2}`, want: false},
+		{name: "string function", expr: "orders.id.startsWith('a')", data: `{"id": "a-1"}`, want: true},
+		{name: "missing field", expr: "orders.qty > 10.0", data: `{"id": "a-1"}`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := Where(compileProg(t, CompileWhereProg, test.expr, stream))(context.Background(), bindEvent(t, stream, test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(events) == 1; got != test.want {
+				t.Errorf("got kept %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	stream := StreamVar{Name: "orders"}
+	csv, err := NewCodec(CodecConfig{ContentType: ContentTypeCSV, Schema: []byte("id,qty")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		expr       string
+		indexField string
+		codec      Codec
+		data       string
+		want       []string
+	}{
+		{name: "no expression", data: `{"id":"a"}`, want: []string{`{"id":"a"}`}},
+		{name: "map", expr: "{'id': orders.id}", data: `{"id":"a","qty":1}`, want: []string{`{"id":"a"}`}},
+		{
+			name: "list",
+			expr: "orders.items.map(i, {'item': i})",
+			data: `{"items":["x","y"]}`,
+			want: []string{`{"item":"x"}`, `{"item":"y"}`},
+		},
+		{
+			name:       "list with index",
+			expr:       "orders.items.map(i, {'item': i})",
+			indexField: "idx",
+			data:       `{"items":["x","y"]}`,
+			want:       []string{`{"idx":0,"item":"x"}`, `{"idx":1,"item":"y"}`},
+		},
+		{name: "codec", expr: "{'id': orders.id, 'qty': orders.qty}", codec: csv, data: `{"id":"a","qty":2}`, want: []string{"a,2\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog := compileProg(t, CompileSelectProg, test.expr, stream)
+			target := &Target{StreamParts: []string{"pubsub", "out"}, Codec: test.codec}
+			events, err := Select(prog, test.indexField, target)(context.Background(), bindEvent(t, stream, test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(test.want) {
+				t.Fatalf("got %d events, want %d", len(events), len(test.want))
+			}
+			for i, e := range events {
+				if !dataEqual(e.Data, test.want[i]) {
+					t.Errorf("event %d: got %s, want %s", i, e.Data, test.want[i])
+				}
+				if e.Target != target || e.ContentType != target.ContentType() {
+					t.Errorf("event %d: not encoded for target", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   *Event
+		want    string
+		wantErr bool
+	}{
+		{name: "json", event: &Event{Data: []byte(`{"a":"b"}`), ContentType: ContentTypeJSON}, want: "b"},
+		{name: "no content type", event: &Event{Data: []byte(`{"a":"b"}`)}, want: "b"},
+		{
+			name:  "cloudevent",
+			event: &Event{Data: []byte(`{"specversion":"1.0","id":"1","datacontenttype":"application/json","data":{"a":"b"}}`), ContentType: ContentTypeCloudEvent},
+			want:  "b",
+		},
+		{name: "unsupported", event: &Event{Data: []byte(`a`), ContentType: "application/unknown"}, wantErr: true},
+		{name: "malformed", event: &Event{Data: []byte(`{`), ContentType: ContentTypeJSON}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := Decode(nil)(context.Background(), test.event)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := events[0].Value["a"]; got != test.want {
+				t.Errorf("got %v, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewTopicEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *common.TopicEvent
+		want  string
+	}{
+		{name: "json", event: &common.TopicEvent{DataContentType: ContentTypeJSON, Data: map[string]interface{}{}, RawData: []byte(`{"a":1}`)}, want: `{"a":1}`},
+		{name: "string", event: &common.TopicEvent{DataContentType: ContentTypeCSV, Data: "a,1", RawData: []byte(`"a,1"`)}, want: "a,1"},
+		{name: "base64", event: &common.TopicEvent{DataContentType: ContentTypeCSV, DataBase64: "YSwx"}, want: "a,1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := NewTopicEvent(test.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(e.Data) != test.want {
+				t.Errorf("got %s, want %s", e.Data, test.want)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	stream := StreamVar{Name: "s"}
+	where := compileProg(t, CompileWhereProg, "s.n > 1.0", stream)
+	target := &Target{StreamParts: []string{"pubsub", "out"}}
+	got := make(chan *Event, 10)
+	sink := SinkFunc(func(_ context.Context, e *Event) error {
+		got <- e
+		return nil
+	})
+	p := NewPipeline("test", sink, Decode(nil), Bind(stream), Where(where), Select(nil, "", target))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	for _, data := range []string{`{"n":1}`, `{`, `{"n":2}`} {
+		p.Send(&Event{Data: []byte(data), ContentType: ContentTypeJSON})
+	}
+	p.Emit(&Event{Data: []byte(`emitted`)})
+
+	var outputs []string
+	for len(outputs) < 2 {
+		select {
+		case e := <-got:
+			outputs = append(outputs, string(e.Data))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout, got outputs %v", outputs)
+		}
+	}
+	cancel()
+	<-done
+
+	want := map[string]bool{`{"n":2}`: true, `emitted`: true}
+	for _, out := range outputs {
+		if !want[out] {
+			t.Errorf("unexpected output %s", out)
+		}
+	}
+}
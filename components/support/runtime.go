@@ -0,0 +1,95 @@
+package support
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+	daprd "github.com/dapr/go-sdk/service/http"
+)
+
+// Runtime manages the lifecycle of a component: its Dapr client, its
+// Dapr service and the goroutines (i.e. pipelines) processing its events
+type Runtime struct {
+	Name    string
+	Client  dapr.Client
+	Service common.Service
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRuntime returns a runtime for the named component, serving on servicePort
+func NewRuntime(name, servicePort string) (*Runtime, error) {
+	client, err := dapr.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("%s: client failed: %s", name, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runtime{
+		Name:    name,
+		Client:  client,
+		Service: daprd.NewService(servicePort),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Context returns the context of the runtime, which is done on shutdown
+func (r *Runtime) Context() context.Context {
+	return r.ctx
+}
+
+// Go runs fn in a goroutine until the runtime shuts down
+func (r *Runtime) Go(fn func(ctx context.Context)) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn(r.ctx)
+	}()
+}
+
+// Run starts the service and blocks until it fails or the process receives
+// SIGINT or SIGTERM. On shutdown, the service is stopped, the goroutines are
+// cancelled (and awaited) and the client is closed.
+func (r *Runtime) Run() error {
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("%s: starting service", r.Name)
+		if err := r.Service.Start(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var err error
+	select {
+	case err = <-errs:
+		if err != nil {
+			err = fmt.Errorf("%s: service failed: %s", r.Name, err)
+		}
+	case sig := <-signals:
+		log.Printf("%s: %s received, shutting down", r.Name, sig)
+		if stopErr := r.Service.Stop(); stopErr != nil {
+			log.Printf("%s: service stop: %s", r.Name, stopErr)
+		}
+	}
+
+	r.cancel()
+	r.wg.Wait()
+	r.Client.Close()
+	log.Printf("%s: stopped", r.Name)
+	return err
+}
@@ -0,0 +1,106 @@
+package support
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	SchemaTypeJSON = "jsonschema"
+	SchemaTypeAvro = "avro"
+)
+
+// SchemaValidator validates decoded stream events against a stream schema
+type SchemaValidator interface {
+	Validate(data map[string]interface{}) error
+}
+
+// NewSchemaValidator returns a validator for the schema type (jsonschema or avro)
+func NewSchemaValidator(schemaType string, schema []byte) (SchemaValidator, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("schema: missing schema content")
+	}
+	switch schemaType {
+	case SchemaTypeJSON, "":
+		compiled, err := jsonschema.CompileString("schema.json", string(schema))
+		if err != nil {
+			return nil, fmt.Errorf("schema: jsonschema: %s", err)
+		}
+		return &jsonSchemaValidator{schema: compiled}, nil
+	case SchemaTypeAvro:
+		codec, err := goavro.NewCodec(string(schema))
+		if err != nil {
+			return nil, fmt.Errorf("schema: avro: %s", err)
+		}
+		return &avroSchemaValidator{codec: codec}, nil
+	default:
+		return nil, fmt.Errorf("schema: unsupported type: %s", schemaType)
+	}
+}
+
+// SchemaValidatorFromEnv creates a SchemaValidator using the following environment variables:
+//   - <prefix>_SCHEMA_TYPE: the schema type (jsonschema or avro)
+//   - <prefix>_SCHEMA: an inline schema
+//   - <prefix>_SCHEMA_FILE: path of a schema file (i.e. mounted from a ConfigMap)
+//
+// It returns nil if no schema is configured.
+func SchemaValidatorFromEnv(prefix string) (SchemaValidator, error) {
+	schemaType, schema, err := SchemaFromEnv(prefix)
+	if err != nil || schema == nil {
+		return nil, err
+	}
+	return NewSchemaValidator(schemaType, schema)
+}
+
+// SchemaFromEnv returns the schema type and content configured with
+// <prefix>_SCHEMA_TYPE and <prefix>_SCHEMA or <prefix>_SCHEMA_FILE.
+func SchemaFromEnv(prefix string) (string, []byte, error) {
+	schemaType := os.Getenv(prefix + "_SCHEMA_TYPE")
+	if schemaFile := os.Getenv(prefix + "_SCHEMA_FILE"); schemaFile != "" {
+		schema, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("schema: file: %s", err)
+		}
+		return schemaType, schema, nil
+	}
+	if schema := os.Getenv(prefix + "_SCHEMA"); schema != "" {
+		return schemaType, []byte(schema), nil
+	}
+	return schemaType, nil, nil
+}
+
+type jsonSchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+func (v *jsonSchemaValidator) Validate(data map[string]interface{}) error {
+	// normalize decoded values (i.e. from non-JSON codecs) into JSON types
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("schema: %s", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return fmt.Errorf("schema: %s", err)
+	}
+	if err := v.schema.Validate(doc); err != nil {
+		return fmt.Errorf("schema: %s", err)
+	}
+	return nil
+}
+
+type avroSchemaValidator struct {
+	codec *goavro.Codec
+}
+
+func (v *avroSchemaValidator) Validate(data map[string]interface{}) error {
+	if _, err := v.codec.BinaryFromNative(nil, data); err != nil {
+		return fmt.Errorf("schema: %s", err)
+	}
+	return nil
+}
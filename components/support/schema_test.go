@@ -0,0 +1,155 @@
+package support
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidator(t *testing.T) {
+	jsonSchema := `{
+		"type": "object",
+		"properties": {"id": {"type": "string"}, "amount": {"type": "integer", "minimum": 0}},
+		"required": ["id"]
+	}`
+	avroSchema := `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "long"},
+		{"name": "note", "type": ["null", "string"]}
+	]}`
+	tests := []struct {
+		name       string
+		schemaType string
+		schema     string
+		data       map[string]interface{}
+		wantErr    string
+	}{
+		{name: "jsonschema", schemaType: SchemaTypeJSON, schema: jsonSchema, data: map[string]interface{}{"id": "a", "amount": 3.0}},
+		{name: "jsonschema by default", schema: jsonSchema, data: map[string]interface{}{"id": "a"}},
+		{name: "jsonschema decoded integers", schemaType: SchemaTypeJSON, schema: jsonSchema, data: map[string]interface{}{"id": "a", "amount": int64(3)}},
+		{name: "jsonschema missing property", schemaType: SchemaTypeJSON, schema: jsonSchema, data: map[string]interface{}{"amount": 3.0}, wantErr: "missing properties: 'id'"},
+		{name: "jsonschema invalid value", schemaType: SchemaTypeJSON, schema: jsonSchema, data: map[string]interface{}{"id": "a", "amount": -1.0}, wantErr: "schema:"},
+		{name: "jsonschema wrong type", schemaType: SchemaTypeJSON, schema: jsonSchema, data: map[string]interface{}{"id": 1.0}, wantErr: "expected string"},
+		{
+			name: "avro", schemaType: SchemaTypeAvro, schema: avroSchema,
+			data: map[string]interface{}{"id": "a", "amount": int64(3), "note": map[string]interface{}{"string": "n"}},
+		},
+		{name: "avro null union", schemaType: SchemaTypeAvro, schema: avroSchema, data: map[string]interface{}{"id": "a", "amount": int64(3), "note": nil}},
+		{name: "avro missing field", schemaType: SchemaTypeAvro, schema: avroSchema, data: map[string]interface{}{"id": "a"}, wantErr: "amount"},
+		{name: "avro wrong type", schemaType: SchemaTypeAvro, schema: avroSchema, data: map[string]interface{}{"id": 1, "amount": int64(3), "note": nil}, wantErr: "schema:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator, err := NewSchemaValidator(test.schemaType, []byte(test.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validator.Validate(test.data)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSchemaValidatorErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		schemaType string
+		schema     string
+		wantErr    string
+	}{
+		{name: "missing schema", schemaType: SchemaTypeJSON, wantErr: "schema: missing schema content"},
+		{name: "invalid jsonschema", schemaType: SchemaTypeJSON, schema: `{"type": 1}`, wantErr: "schema: jsonschema:"},
+		{name: "invalid avro", schemaType: SchemaTypeAvro, schema: `{"type": "record"}`, wantErr: "schema: avro:"},
+		{name: "unsupported type", schemaType: "xsd", schema: "<xs:schema/>", wantErr: "schema: unsupported type: xsd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewSchemaValidator(test.schemaType, []byte(test.schema))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "schema.json")
+	if err := ioutil.WriteFile(schemaFile, []byte(`{"type": "object", "required": ["file"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantType   string
+		wantSchema string
+		wantErr    string
+	}{
+		{name: "no schema"},
+		{
+			name:       "inline",
+			env:        map[string]string{"TEST_SCHEMA_TYPE": SchemaTypeAvro, "TEST_SCHEMA": `"string"`},
+			wantType:   SchemaTypeAvro,
+			wantSchema: `"string"`,
+		},
+		{
+			name:       "file over inline",
+			env:        map[string]string{"TEST_SCHEMA_TYPE": SchemaTypeJSON, "TEST_SCHEMA": `{}`, "TEST_SCHEMA_FILE": schemaFile},
+			wantType:   SchemaTypeJSON,
+			wantSchema: `{"type": "object", "required": ["file"]}`,
+		},
+		{name: "missing file", env: map[string]string{"TEST_SCHEMA_FILE": filepath.Join(dir, "missing")}, wantErr: "schema: file:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			schemaType, schema, err := SchemaFromEnv("TEST")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schemaType != test.wantType || string(schema) != test.wantSchema {
+				t.Errorf("got %s schema %q, want %s schema %q", schemaType, schema, test.wantType, test.wantSchema)
+			}
+
+			validator, err := SchemaValidatorFromEnv("TEST")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := validator != nil; got != (test.wantSchema != "") {
+				t.Errorf("got validator %t, want %t", got, test.wantSchema != "")
+			}
+		})
+	}
+}
+
+func TestSchemaValidatorFromFile(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	if err := ioutil.WriteFile(schemaFile, []byte(`{"type": "object", "required": ["file"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the file takes precedence over the inline schema
+	setEnv(t, map[string]string{"TEST_SCHEMA_TYPE": SchemaTypeJSON, "TEST_SCHEMA": `{"required": ["inline"]}`, "TEST_SCHEMA_FILE": schemaFile})
+	validator, err := SchemaValidatorFromEnv("TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.Validate(map[string]interface{}{"file": true}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := validator.Validate(map[string]interface{}{"inline": true}); err == nil {
+		t.Error("expected an error without the property of the file schema")
+	}
+}
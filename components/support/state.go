@@ -0,0 +1,127 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/google/cel-go/cel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StateVarName is the name of the CEL variable holding the state of an event key
+const StateVarName = "state"
+
+// ErrStateConflict is returned by KeyedState.Update when the state of the key
+// was modified since it was loaded. The state should be reloaded and the update
+// evaluated again.
+var ErrStateConflict = errors.New("state modified concurrently")
+
+// KeyState is the state of a key, as loaded from the state store
+type KeyState struct {
+	Key   string
+	Value map[string]interface{}
+	etag  string
+}
+
+// KeyedState maintains per-key state, in a Dapr state store, for stream events.
+// Events are partitioned using a key program and the state of a key is
+// computed using an update program (which can reference the current state).
+type KeyedState struct {
+	client      StateStore
+	store       string
+	keyProg     cel.Program
+	initialProg cel.Program
+	updateProg  cel.Program
+}
+
+// NewKeyedState returns a KeyedState using the named state store. If initialProg
+// is nil, the state of a new key is an empty map.
+func NewKeyedState(client StateStore, store string, keyProg, initialProg, updateProg cel.Program) *KeyedState {
+	return &KeyedState{
+		client:      client,
+		store:       store,
+		keyProg:     keyProg,
+		initialProg: initialProg,
+		updateProg:  updateProg,
+	}
+}
+
+// Get evaluates the key of the event (bound in activation) and loads its state
+func (s *KeyedState) Get(ctx context.Context, activation map[string]interface{}) (*KeyState, error) {
+	result, _, err := s.keyProg.Eval(activation)
+	if err != nil {
+		return nil, fmt.Errorf("state key: %s", err)
+	}
+	key := fmt.Sprint(result.Value())
+
+	item, err := s.client.GetState(ctx, s.store, key)
+	if err != nil {
+		return nil, fmt.Errorf("state store %s: get %s: %s", s.store, key, err)
+	}
+	if item == nil || len(item.Value) == 0 {
+		value, err := s.initial(activation)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyState{Key: key, Value: value}, nil
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(item.Value, &value); err != nil {
+		return nil, fmt.Errorf("state store %s: key %s: %s", s.store, key, err)
+	}
+	return &KeyState{Key: key, Value: value, etag: item.Etag}, nil
+}
+
+// Update evaluates the new state of the key and saves it. The save fails with
+// ErrStateConflict if the state was modified (i.e. by another replica) since it
+// was loaded, or, for a new key, if the state was created since it was loaded.
+func (s *KeyedState) Update(ctx context.Context, state *KeyState, activation map[string]interface{}) error {
+	result, _, err := s.updateProg.Eval(activation)
+	if err != nil {
+		return fmt.Errorf("state update: %s", err)
+	}
+	value, err := ResultStruct(result)
+	if err != nil {
+		return fmt.Errorf("state update: %s", err)
+	}
+	data, err := value.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("state update: %s", err)
+	}
+
+	// the empty etag of a new key only matches a key without state
+	item := &dapr.SetStateItem{
+		Key:     state.Key,
+		Value:   data,
+		Etag:    &dapr.ETag{Value: state.etag},
+		Options: &dapr.StateOptions{Concurrency: dapr.StateConcurrencyFirstWrite},
+	}
+	if err := s.client.SaveBulkState(ctx, s.store, item); err != nil {
+		// Dapr reports etag mismatches as Aborted
+		if status.Code(err) == codes.Aborted {
+			return fmt.Errorf("state store %s: save %s: %w", s.store, state.Key, ErrStateConflict)
+		}
+		return fmt.Errorf("state store %s: save %s: %s", s.store, state.Key, err)
+	}
+	state.Value = value.AsMap()
+	return nil
+}
+
+func (s *KeyedState) initial(activation map[string]interface{}) (map[string]interface{}, error) {
+	if s.initialProg == nil {
+		return map[string]interface{}{}, nil
+	}
+	result, _, err := s.initialProg.Eval(activation)
+	if err != nil {
+		return nil, fmt.Errorf("state initial: %s", err)
+	}
+	value, err := ResultStruct(result)
+	if err != nil {
+		return nil, fmt.Errorf("state initial: %s", err)
+	}
+	return value.AsMap(), nil
+}
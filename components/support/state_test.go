@@ -0,0 +1,171 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/client"
+
+	"github.com/vladimirvivien/streaming-runtime/components/support/daprtest"
+)
+
+func TestKeyedState(t *testing.T) {
+	stream := StreamVar{Name: "s"}
+	state := StreamVar{Name: StateVarName}
+	keyProg := compileProg(t, CompileKeyProg, "s.user", stream)
+	updateProg := compileProg(t, CompileMapProg, "{'count': getOrDefault(state, 'count', 0.0) + 1.0}", stream, state)
+	keyed := NewKeyedState(daprtest.NewClient(), "store", keyProg, nil, updateProg)
+
+	tests := []struct {
+		data string
+		want float64
+	}{
+		{data: `{"user":"a"}`, want: 1},
+		{data: `{"user":"a"}`, want: 2},
+		{data: `{"user":"b"}`, want: 1},
+		{data: `{"user":"a"}`, want: 3},
+	}
+	ctx := context.Background()
+	for i, test := range tests {
+		e := bindEvent(t, stream, test.data)
+		st, err := keyed.Get(ctx, e.Activation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Activation[StateVarName] = st.Value
+		if err := keyed.Update(ctx, st, e.Activation); err != nil {
+			t.Fatal(err)
+		}
+		if got := st.Value["count"]; got != test.want {
+			t.Errorf("event %d: got count %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestKeyedStateConflict(t *testing.T) {
+	stream := StreamVar{Name: "s"}
+	keyProg := compileProg(t, CompileKeyProg, "s.user", stream)
+	updateProg := compileProg(t, CompileMapProg, "{'last': s.n}", stream)
+	keyed := NewKeyedState(daprtest.NewClient(), "store", keyProg, nil, updateProg)
+	ctx := context.Background()
+
+	// create the key, then load it twice (i.e. by two replicas)
+	e := bindEvent(t, stream, `{"user":"a","n":1}`)
+	st, _ := keyed.Get(ctx, e.Activation)
+	if err := keyed.Update(ctx, st, e.Activation); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := keyed.Get(ctx, e.Activation)
+	second, _ := keyed.Get(ctx, e.Activation)
+	if err := keyed.Update(ctx, first, e.Activation); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyed.Update(ctx, second, e.Activation); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("got error %v, want %v", err, ErrStateConflict)
+	}
+}
+
+func TestKeyedStateNewKeyConflict(t *testing.T) {
+	stream := StreamVar{Name: "s"}
+	keyProg := compileProg(t, CompileKeyProg, "s.user", stream)
+	updateProg := compileProg(t, CompileMapProg, "{'last': s.n}", stream)
+	keyed := NewKeyedState(daprtest.NewClient(), "store", keyProg, nil, updateProg)
+	ctx := context.Background()
+
+	// load a new key twice (i.e. by two replicas): the first write creates the key
+	e := bindEvent(t, stream, `{"user":"a","n":1}`)
+	first, _ := keyed.Get(ctx, e.Activation)
+	second, _ := keyed.Get(ctx, e.Activation)
+	if err := keyed.Update(ctx, first, e.Activation); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyed.Update(ctx, second, e.Activation); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("got error %v, want %v", err, ErrStateConflict)
+	}
+
+	// the reloaded state is updated
+	reloaded, _ := keyed.Get(ctx, e.Activation)
+	if err := keyed.Update(ctx, reloaded, e.Activation); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestDeduplicator(t *testing.T) {
+	tests := []struct {
+		name  string
+		store string
+		ids   []string
+		want  []bool
+	}{
+		{name: "memory", ids: []string{"1", "2", "1", "3", "2"}, want: []bool{false, false, true, false, true}},
+		{name: "state store", store: "dedup", ids: []string{"1", "1"}, want: []bool{false, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := NewDeduplicator(10, time.Minute, daprtest.NewClient(), test.store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, id := range test.ids {
+				seen, err := d.Seen(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seen != test.want[i] {
+					t.Errorf("id %s (%d): got seen %t, want %t", id, i, seen, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeduplicatorSharedStore(t *testing.T) {
+	// replicas sharing a state store see the ids seen by each other
+	client := daprtest.NewClient()
+	a, _ := NewDeduplicator(10, time.Minute, client, "dedup")
+	b, _ := NewDeduplicator(10, time.Minute, client, "dedup")
+	if seen, _ := a.Seen(context.Background(), "1"); seen {
+		t.Fatal("first replica: id seen")
+	}
+	if seen, _ := b.Seen(context.Background(), "1"); !seen {
+		t.Error("second replica: id not seen")
+	}
+}
+
+// staleStore returns no state, as loaded by a replica before another replica saved it
+type staleStore struct {
+	StateStore
+}
+
+func (staleStore) GetState(_ context.Context, _, key string) (*dapr.StateItem, error) {
+	return &dapr.StateItem{Key: key}, nil
+}
+
+func TestDeduplicatorConcurrentReplicas(t *testing.T) {
+	// both replicas load the id before it is recorded: the first write wins
+	client := daprtest.NewClient()
+	a, _ := NewDeduplicator(10, time.Minute, staleStore{client}, "dedup")
+	b, _ := NewDeduplicator(10, time.Minute, staleStore{client}, "dedup")
+	if seen, err := a.Seen(context.Background(), "1"); err != nil || seen {
+		t.Fatalf("first replica: got seen %t (%v), want false", seen, err)
+	}
+	if seen, err := b.Seen(context.Background(), "1"); err != nil || !seen {
+		t.Errorf("second replica: got seen %t (%v), want true", seen, err)
+	}
+}
+
+func TestDeduplicatorExpired(t *testing.T) {
+	// an expired id, left in a state store without TTL support, is recorded again
+	client := daprtest.NewClient()
+	a, _ := NewDeduplicator(10, time.Millisecond, client, "dedup")
+	b, _ := NewDeduplicator(10, time.Millisecond, client, "dedup")
+	if seen, _ := a.Seen(context.Background(), "1"); seen {
+		t.Fatal("first replica: id seen")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if seen, err := b.Seen(context.Background(), "1"); err != nil || seen {
+		t.Errorf("second replica: got seen %t (%v), want false", seen, err)
+	}
+}
@@ -35,31 +35,19 @@ func UnmarshalJSON(data []byte) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// ExtractJSONFromInvocation decodes invocation data using a codec selected
+// by the content type of the event.
 func ExtractJSONFromInvocation(e *common.InvocationEvent) (map[string]interface{}, error) {
-	switch {
-	case e.ContentType == "application/json":
-		return UnmarshalJSON(e.Data)
-	case e.ContentType == "application/cloudevents+json":
-		var cloudevent map[string]interface{}
-		if err := json.Unmarshal(e.Data, &cloudevent); err != nil {
-			return nil, err
-		}
-		data, ok := cloudevent["data"]
-		if !ok {
-			return nil, fmt.Errorf("cloudevent missing 'data' entry")
-		}
-		jsonData, ok := data.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("cloudevent data has unexpected type: %T", data)
-		}
-		return jsonData, nil
-	}
-	return nil, fmt.Errorf("unsupported event type: %s", e.ContentType)
+	return DecodeInvocation(e, nil)
 }
 
+// CompileCELProg compiles an expression, which can use the latest stream function library, into a program
 func CompileCELProg(expr string, variables ...*exprv1alpha1.Decl) (cel.Program, error) {
-	d := cel.Declarations(variables...)
-	env, err := cel.NewEnv(d)
+	lib, err := StreamLib(CELLibVersion)
+	if err != nil {
+		return nil, err
+	}
+	env, err := cel.NewEnv(lib, cel.Declarations(variables...))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/controllers/probe"
+)
+
+// authMode is an authentication mode of a protocol: the properties it requires and
+// the metadata it adds to the pubsub component
+type authMode struct {
+	required []string
+	metadata map[string]string
+	// validate, if set, checks the values of the properties (including the secret properties)
+	validate func(properties map[string]string) error
+}
+
+// authModes are the authentication modes supported by each protocol, rendered with the
+// metadata of the Dapr pubsub components. Protocols not listed only support none.
+var authModes = map[string]map[string]authMode{
+	"kafka": {
+		streamingruntime.AuthTypeNone: {
+			metadata: map[string]string{"authType": "none", "authRequired": "false"},
+		},
+		streamingruntime.AuthTypeSASL: {
+			required: []string{"saslUsername", "saslPassword"},
+			metadata: map[string]string{"authType": "password", "authRequired": "true", "saslMechanism": "PLAINTEXT"},
+		},
+		streamingruntime.AuthTypeSCRAMSHA256: {
+			required: []string{"saslUsername", "saslPassword"},
+			metadata: map[string]string{"authType": "password", "authRequired": "true", "saslMechanism": "SHA-256"},
+		},
+		streamingruntime.AuthTypeSCRAMSHA512: {
+			required: []string{"saslUsername", "saslPassword"},
+			metadata: map[string]string{"authType": "password", "authRequired": "true", "saslMechanism": "SHA-512"},
+		},
+		streamingruntime.AuthTypeMTLS: {
+			required: []string{"clientCert", "clientKey"},
+			metadata: map[string]string{"authType": "mtls", "authRequired": "true"},
+		},
+		streamingruntime.AuthTypeOAuth: {
+			required: []string{"oidcTokenEndpoint", "oidcClientID", "oidcClientSecret"},
+			metadata: map[string]string{"authType": "oidc", "authRequired": "true"},
+		},
+	},
+	"redis": {
+		streamingruntime.AuthTypeNone: {},
+		streamingruntime.AuthTypeSASL: {required: []string{"redisPassword"}},
+	},
+	"rabbitmq": {
+		streamingruntime.AuthTypeNone: {},
+		// the username and password are part of the host URL
+		streamingruntime.AuthTypeSASL: {required: []string{"host"}, validate: urlCredentials("host")},
+		streamingruntime.AuthTypeMTLS: {
+			required: []string{"clientCert", "clientKey"},
+			metadata: map[string]string{"saslExternal": "true"},
+		},
+	},
+}
+
+// urlCredentials returns a validation requiring the URL of a property to have a
+// username and a password
+func urlCredentials(property string) func(map[string]string) error {
+	return func(properties map[string]string) error {
+		u, err := url.Parse(properties[property])
+		if err != nil {
+			return fmt.Errorf("property %s: invalid URL", property)
+		}
+		if password, ok := u.User.Password(); u.User.Username() == "" || !ok || password == "" {
+			return fmt.Errorf("property %s: URL must include a username and a password", property)
+		}
+		return nil
+	}
+}
+
+// clusterStreamAuth returns the authentication mode of a ClusterStream. Without
+// authType, no metadata is added and nothing is required.
+func clusterStreamAuth(cs *streamingruntime.ClusterStream) (authMode, error) {
+	authType := cs.Spec.AuthType
+	switch {
+	case authType == "":
+		return authMode{}, nil // the properties configure the authentication
+	case authType == streamingruntime.AuthTypeNone && authModes[cs.Spec.Protocol] == nil:
+		return authMode{}, nil
+	}
+	mode, ok := authModes[cs.Spec.Protocol][authType]
+	if !ok {
+		return authMode{}, fmt.Errorf("authType %s not supported by protocol %s", authType, cs.Spec.Protocol)
+	}
+	return mode, nil
+}
+
+// validateClusterStream returns an error if a ClusterStream sets a property both as a
+// plain and a secret property, has an invalid TLS configuration, or lacks a property
+// required by its authentication mode. secrets are the values read from the credentials
+// Secret, the secret properties and the TLS Secrets.
+func validateClusterStream(cs *streamingruntime.ClusterStream, secrets map[string][]byte) error {
+	if cs.Spec.Protocol == "" {
+		return fmt.Errorf("protocol required")
+	}
+	for name := range cs.Spec.SecretProperties {
+		if _, ok := cs.Spec.Properties[name]; ok {
+			return fmt.Errorf("property %s is both a property and a secret property", name)
+		}
+	}
+	if err := validateTLS(cs); err != nil {
+		return err
+	}
+	if cs.Spec.Probe != nil && !probe.Supported(cs.Spec.Protocol) {
+		return fmt.Errorf("probe: not supported by protocol %s", cs.Spec.Protocol)
+	}
+	mode, err := clusterStreamAuth(cs)
+	if err != nil {
+		return err
+	}
+	required := mode.required
+	if cs.Spec.Protocol == streamingruntime.KafkaProtocolName {
+		required = append([]string{"brokers"}, required...)
+	}
+	var missing []string
+	for _, name := range required {
+		if _, ok := cs.Spec.Properties[name]; ok {
+			continue
+		}
+		if _, ok := secrets[name]; ok {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		if cs.Spec.AuthType == "" {
+			return fmt.Errorf("missing properties %s", strings.Join(missing, ", "))
+		}
+		return fmt.Errorf("authType %s: missing properties %s", cs.Spec.AuthType, strings.Join(missing, ", "))
+	}
+	if mode.validate != nil {
+		if err := mode.validate(connectionProperties(cs, secrets)); err != nil {
+			return fmt.Errorf("authType %s: %w", cs.Spec.AuthType, err)
+		}
+	}
+	return nil
+}
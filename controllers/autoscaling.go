@@ -0,0 +1,503 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+const (
+	// eventsReceivedMetric is the pods metric, exposed through a metrics adapter,
+	// used by HPAs to scale components on the events received per second
+	eventsReceivedMetric = "events_received_per_second"
+)
+
+// autoscalingLabel labels the TriggerAuthentications, and their Secrets, of the
+// ScaledObject of a component with the component name
+const autoscalingLabel = "streaming.vivien.io/autoscaling"
+
+var (
+	scaledObjectGVK          = schema.GroupVersionKind{Group: "keda.sh", Version: "v1alpha1", Kind: "ScaledObject"}
+	triggerAuthenticationGVK = schema.GroupVersionKind{Group: "keda.sh", Version: "v1alpha1", Kind: "TriggerAuthentication"}
+)
+
+// validateAutoscaling validates the autoscaling of a component
+func validateAutoscaling(spec *streamingruntime.Autoscaling) error {
+	if spec == nil {
+		return nil
+	}
+	if spec.MaxReplicas < autoscalingMinReplicas(spec) {
+		return fmt.Errorf("autoscaling maxReplicas must be greater than or equal to minReplicas")
+	}
+	switch spec.Scaler {
+	case "", "keda":
+		if spec.TargetLag <= 0 {
+			return fmt.Errorf("autoscaling with keda requires targetLag")
+		}
+	case "hpa":
+		if spec.TargetEventsPerSecond <= 0 {
+			return fmt.Errorf("autoscaling with hpa requires targetEventsPerSecond")
+		}
+	default:
+		return fmt.Errorf("autoscaling scaler %s not supported", spec.Scaler)
+	}
+	return nil
+}
+
+// autoscalingMinReplicas returns the minimum replicas of an autoscaled component
+func autoscalingMinReplicas(spec *streamingruntime.Autoscaling) int32 {
+	if spec.MinReplicas == 0 {
+		return 1
+	}
+	return spec.MinReplicas
+}
+
+// reconcileAutoscaler creates, updates or deletes the autoscaler of the deployment of a
+// component. The deployment and the component app-id are named after the owner, which
+// is scaled with the load of the streams it consumes.
+func reconcileAutoscaler(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, spec *streamingruntime.Autoscaling, streams []*streamingruntime.Stream) error {
+	log := log.FromContext(ctx)
+	key := types.NamespacedName{Namespace: owner.GetNamespace(), Name: owner.GetName()}
+
+	var desired client.Object
+	var auths []client.Object // TriggerAuthentications of the ScaledObject, and their Secrets
+	if spec != nil {
+		if spec.Scaler == "hpa" {
+			desired = createHPA(owner, spec)
+		} else {
+			scaledObject, objects, err := createScaledObject(ctx, c, owner, spec, streams)
+			if err != nil {
+				return err
+			}
+			desired, auths = scaledObject, objects
+		}
+		if err := ctrl.SetControllerReference(owner, desired, scheme); err != nil {
+			return err
+		}
+	}
+	if err := reconcileTriggerAuths(ctx, c, scheme, owner, auths); err != nil {
+		return fmt.Errorf("autoscaler: %w", err)
+	}
+
+	// delete the autoscalers not (or no longer) desired
+	var unwanted []client.Object
+	if spec == nil || spec.Scaler != "hpa" {
+		unwanted = append(unwanted, &autoscalingv2beta2.HorizontalPodAutoscaler{})
+	}
+	if spec == nil || spec.Scaler == "hpa" {
+		unwanted = append(unwanted, newScaledObject())
+	}
+	for _, obj := range unwanted {
+		if err := c.Get(ctx, key, obj); err != nil {
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(obj, owner) {
+			continue
+		}
+		if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info("Deleted autoscaler", "Name", key.Name, "Namespace", key.Namespace)
+	}
+	if desired == nil {
+		return nil
+	}
+
+	existing := desired.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, key, existing)
+	switch {
+	case errors.IsNotFound(err):
+		if err := c.Create(ctx, desired); err != nil {
+			return fmt.Errorf("autoscaler: %w", err)
+		}
+		log.Info("Created autoscaler", "Name", key.Name, "Namespace", key.Namespace, "Scaler", spec.Scaler)
+		return nil
+	case err != nil:
+		return fmt.Errorf("autoscaler: %w", err)
+	}
+
+	switch existing := existing.(type) {
+	case *autoscalingv2beta2.HorizontalPodAutoscaler:
+		hpa := desired.(*autoscalingv2beta2.HorizontalPodAutoscaler)
+		if equality.Semantic.DeepEqual(existing.Spec, hpa.Spec) {
+			return nil
+		}
+		existing.Spec = hpa.Spec
+	case *unstructured.Unstructured:
+		scaledObject := desired.(*unstructured.Unstructured)
+		if equality.Semantic.DeepEqual(existing.Object["spec"], scaledObject.Object["spec"]) {
+			return nil
+		}
+		existing.Object["spec"] = scaledObject.Object["spec"]
+	}
+	if err := c.Update(ctx, existing); err != nil {
+		return fmt.Errorf("autoscaler: %w", err)
+	}
+	log.Info("Updated autoscaler", "Name", key.Name, "Namespace", key.Namespace, "Scaler", spec.Scaler)
+	return nil
+}
+
+// reconcileTriggerAuths creates or updates the TriggerAuthentications of the ScaledObject
+// of the owner, and their Secrets, and deletes the ones no longer desired
+func reconcileTriggerAuths(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, desired []client.Object) error {
+	log := log.FromContext(ctx)
+	wanted := make(map[string]bool)
+	for _, obj := range desired {
+		if err := ctrl.SetControllerReference(owner, obj, scheme); err != nil {
+			return err
+		}
+		wanted[authKind(obj)+"/"+obj.GetName()] = true
+
+		key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		existing := obj.DeepCopyObject().(client.Object)
+		err := c.Get(ctx, key, existing)
+		switch {
+		case errors.IsNotFound(err):
+			if err := c.Create(ctx, obj); err != nil {
+				return err
+			}
+			log.Info("Created autoscaler authentication", "Name", key.Name, "Namespace", key.Namespace)
+			continue
+		case err != nil:
+			return err
+		case !metav1.IsControlledBy(existing, owner):
+			return fmt.Errorf("%s %s already exists and is not owned by %s", authKind(obj), key, owner.GetName())
+		}
+		switch existing := existing.(type) {
+		case *corev1.Secret:
+			secret := obj.(*corev1.Secret)
+			if equality.Semantic.DeepEqual(existing.Data, secret.Data) {
+				continue
+			}
+			existing.Data = secret.Data
+		case *unstructured.Unstructured:
+			auth := obj.(*unstructured.Unstructured)
+			if equality.Semantic.DeepEqual(existing.Object["spec"], auth.Object["spec"]) {
+				continue
+			}
+			existing.Object["spec"] = auth.Object["spec"]
+		}
+		if err := c.Update(ctx, existing); err != nil {
+			return err
+		}
+		log.Info("Updated autoscaler authentication", "Name", key.Name, "Namespace", key.Namespace)
+	}
+
+	auths := new(unstructured.UnstructuredList)
+	auths.SetGroupVersionKind(triggerAuthenticationGVK.GroupVersion().WithKind("TriggerAuthenticationList"))
+	lists := []client.ObjectList{new(corev1.SecretList), auths}
+	for _, list := range lists {
+		err := c.List(ctx, list, client.InNamespace(owner.GetNamespace()), client.MatchingLabels{autoscalingLabel: owner.GetName()})
+		if meta.IsNoMatchError(err) {
+			continue // KEDA is not installed
+		}
+		if err != nil {
+			return err
+		}
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			obj := o.(client.Object)
+			if wanted[authKind(obj)+"/"+obj.GetName()] || !metav1.IsControlledBy(obj, owner) {
+				continue
+			}
+			if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			log.Info("Deleted autoscaler authentication", "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		}
+	}
+	return nil
+}
+
+// authKind returns the kind of a TriggerAuthentication or of a Secret of its parameters
+func authKind(obj client.Object) string {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return "Secret"
+	}
+	return triggerAuthenticationGVK.Kind
+}
+
+// createHPA returns a HorizontalPodAutoscaler scaling the owner deployment on the
+// events received per second by its pods
+func createHPA(owner client.Object, spec *streamingruntime.Autoscaling) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	minReplicas := autoscalingMinReplicas(spec)
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{APIVersion: autoscalingv2beta2.SchemeGroupVersion.String(), Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      owner.GetName(),
+			Namespace: owner.GetNamespace(),
+		},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       owner.GetName(),
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: spec.MaxReplicas,
+			Metrics: []autoscalingv2beta2.MetricSpec{{
+				Type: autoscalingv2beta2.PodsMetricSourceType,
+				Pods: &autoscalingv2beta2.PodsMetricSource{
+					Metric: autoscalingv2beta2.MetricIdentifier{Name: eventsReceivedMetric},
+					Target: autoscalingv2beta2.MetricTarget{
+						Type:         autoscalingv2beta2.AverageValueMetricType,
+						AverageValue: resource.NewQuantity(spec.TargetEventsPerSecond, resource.DecimalSI),
+					},
+				},
+			}},
+		},
+	}
+}
+
+// newScaledObject returns an empty KEDA ScaledObject. KEDA types are handled as
+// unstructured objects, the controller does not depend on KEDA being installed.
+func newScaledObject() *unstructured.Unstructured {
+	obj := new(unstructured.Unstructured)
+	obj.SetGroupVersionKind(scaledObjectGVK)
+	return obj
+}
+
+// createScaledObject returns a KEDA ScaledObject scaling the owner deployment with
+// the lag of its streams, and the TriggerAuthentications (and the Secrets of their
+// parameters) of its triggers. Triggers are derived from the ClusterStream of each stream.
+func createScaledObject(ctx context.Context, c client.Client, owner client.Object, spec *streamingruntime.Autoscaling, streams []*streamingruntime.Stream) (*unstructured.Unstructured, []client.Object, error) {
+	if len(streams) == 0 {
+		return nil, nil, fmt.Errorf("autoscaling with keda requires input streams")
+	}
+	var triggers []interface{}
+	var auths []client.Object
+	authenticated := make(map[string]bool) // ClusterStreams whose TriggerAuthentication is rendered
+	for _, stream := range streams {
+		cs := new(streamingruntime.ClusterStream)
+		if err := c.Get(ctx, types.NamespacedName{Name: stream.Spec.ClusterStream}, cs); err != nil {
+			return nil, nil, fmt.Errorf("autoscaling: stream %s: clusterstream: %w", stream.Name, err)
+		}
+		credentials, err := projectedCredentials(ctx, c, cs, owner.GetNamespace())
+		if err != nil {
+			return nil, nil, fmt.Errorf("autoscaling: stream %s: %w", stream.Name, err)
+		}
+		trigger, auth, err := lagTrigger(cs, credentials, stream.Spec.Topic, owner.GetName(), spec.TargetLag)
+		if err != nil {
+			return nil, nil, fmt.Errorf("autoscaling: stream %s: %w", stream.Name, err)
+		}
+		if auth.empty() {
+			triggers = append(triggers, trigger)
+			continue
+		}
+		name := fmt.Sprintf("%s-%s", owner.GetName(), cs.Name)
+		trigger["authenticationRef"] = map[string]interface{}{"name": name}
+		triggers = append(triggers, trigger)
+		if !authenticated[cs.Name] {
+			authenticated[cs.Name] = true
+			auths = append(auths, auth.objects(owner, name, credentialsSecretName(cs))...)
+		}
+	}
+
+	obj := newScaledObject()
+	obj.SetName(owner.GetName())
+	obj.SetNamespace(owner.GetNamespace())
+	obj.Object["spec"] = map[string]interface{}{
+		"scaleTargetRef": map[string]interface{}{
+			"name": owner.GetName(),
+		},
+		"minReplicaCount": int64(autoscalingMinReplicas(spec)),
+		"maxReplicaCount": int64(spec.MaxReplicas),
+		"triggers":        triggers,
+	}
+	return obj, auths, nil
+}
+
+// projectedCredentials returns the values of the credentials Secret projected for a
+// ClusterStream in a namespace, by property name, nil if the ClusterStream has no secrets
+func projectedCredentials(ctx context.Context, c client.Client, cs *streamingruntime.ClusterStream, namespace string) (map[string][]byte, error) {
+	if cs.Spec.CredentialsSecretRef == nil && len(secretProperties(cs)) == 0 {
+		return nil, nil
+	}
+	secret := new(corev1.Secret)
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: credentialsSecretName(cs)}, secret); err != nil {
+		return nil, fmt.Errorf("clusterstream %s: credentials: %w", cs.Name, err)
+	}
+	return secret.Data, nil
+}
+
+// triggerAuth holds the authentication parameters of a KEDA trigger: the parameters
+// read from the credentials Secret projected for the ClusterStream are referenced, so
+// that rotated credentials are used, the others are rendered in a Secret of the owner
+type triggerAuth struct {
+	refs   map[string]string // parameter -> key of the projected credentials Secret
+	values map[string]string // parameter -> value
+}
+
+func newTriggerAuth() *triggerAuth {
+	return &triggerAuth{refs: make(map[string]string), values: make(map[string]string)}
+}
+
+// param sets a parameter from a property of the ClusterStream, if the property is set
+func (a *triggerAuth) param(param, property string, properties map[string]string, credentials map[string][]byte) {
+	if _, ok := credentials[property]; ok {
+		a.refs[param] = property
+		return
+	}
+	if value, ok := properties[property]; ok {
+		a.values[param] = value
+	}
+}
+
+func (a *triggerAuth) empty() bool {
+	return len(a.refs) == 0 && len(a.values) == 0
+}
+
+// objects returns the TriggerAuthentication of the parameters, and the Secret of the
+// rendered parameters, named name
+func (a *triggerAuth) objects(owner client.Object, name, credentialsSecret string) []client.Object {
+	labels := map[string]string{autoscalingLabel: owner.GetName()}
+	var objects []client.Object
+	var refs []interface{}
+	if len(a.values) > 0 {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: owner.GetNamespace(), Labels: labels},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       make(map[string][]byte),
+		}
+		// the Secrets read by the controller are labelled to be cached
+		secret.Labels[credentialsLabel] = "true"
+		for _, param := range sortedKeys(a.values) {
+			secret.Data[param] = []byte(a.values[param])
+			refs = append(refs, map[string]interface{}{"parameter": param, "name": name, "key": param})
+		}
+		objects = append(objects, secret)
+	}
+	for _, param := range sortedKeys(a.refs) {
+		refs = append(refs, map[string]interface{}{"parameter": param, "name": credentialsSecret, "key": a.refs[param]})
+	}
+	auth := new(unstructured.Unstructured)
+	auth.SetGroupVersionKind(triggerAuthenticationGVK)
+	auth.SetName(name)
+	auth.SetNamespace(owner.GetNamespace())
+	auth.SetLabels(labels)
+	auth.Object["spec"] = map[string]interface{}{"secretTargetRef": refs}
+	return append(objects, auth)
+}
+
+// kafkaSASL are the SASL mechanisms of the KEDA kafka scaler, by authType
+var kafkaSASL = map[string]string{
+	streamingruntime.AuthTypeSASL:        "plaintext",
+	streamingruntime.AuthTypeSCRAMSHA256: "scram_sha256",
+	streamingruntime.AuthTypeSCRAMSHA512: "scram_sha512",
+	streamingruntime.AuthTypeOAuth:       "oauthbearer",
+}
+
+// lagTrigger returns the KEDA trigger measuring the lag of the consumer (appID) of a
+// topic, for the protocol of the ClusterStream, and its authentication parameters,
+// derived from the authType and TLS configuration of the ClusterStream. credentials
+// are the values of the credentials Secret projected for the ClusterStream. The
+// consumer group and queue names follow the Dapr pubsub components defaults.
+func lagTrigger(cs *streamingruntime.ClusterStream, credentials map[string][]byte, topic, appID string, targetLag int64) (map[string]interface{}, *triggerAuth, error) {
+	properties := cs.Spec.Properties
+	lag := strconv.FormatInt(targetLag, 10)
+	auth := newTriggerAuth()
+	tlsParams := func() {
+		auth.values["tls"] = "enable"
+		auth.param("ca", "caCert", properties, credentials)
+		auth.param("cert", "clientCert", properties, credentials)
+		auth.param("key", "clientKey", properties, credentials)
+	}
+	var metadata map[string]interface{}
+	var scaler string
+	switch cs.Spec.Protocol {
+	case streamingruntime.KafkaProtocolName:
+		if _, ok := credentials["brokers"]; ok {
+			return nil, nil, fmt.Errorf("autoscaling with keda requires the brokers property of clusterstream %s not to be a secret", cs.Name)
+		}
+		group := properties["consumerGroup"]
+		if group == "" {
+			group = appID
+		}
+		// the kafka scaler does not scale beyond the topic partitions
+		scaler = "kafka"
+		metadata = map[string]interface{}{
+			"bootstrapServers": properties["brokers"],
+			"consumerGroup":    group,
+			"topic":            topic,
+			"lagThreshold":     lag,
+		}
+		if sasl, ok := kafkaSASL[cs.Spec.AuthType]; ok {
+			auth.values["sasl"] = sasl
+		}
+		switch cs.Spec.AuthType {
+		case streamingruntime.AuthTypeSASL, streamingruntime.AuthTypeSCRAMSHA256, streamingruntime.AuthTypeSCRAMSHA512:
+			auth.param("username", "saslUsername", properties, credentials)
+			auth.param("password", "saslPassword", properties, credentials)
+		case streamingruntime.AuthTypeOAuth:
+			auth.param("username", "oidcClientID", properties, credentials)
+			auth.param("password", "oidcClientSecret", properties, credentials)
+			auth.param("oauthTokenEndpointUri", "oidcTokenEndpoint", properties, credentials)
+			auth.param("scopes", "oidcScopes", properties, credentials)
+		}
+		if cs.Spec.TLS != nil || cs.Spec.AuthType == streamingruntime.AuthTypeMTLS {
+			tlsParams()
+		}
+		if cs.Spec.TLS != nil && cs.Spec.TLS.InsecureSkipVerify {
+			metadata["unsafeSsl"] = "true"
+		}
+	case "redis":
+		scaler = "redis-streams"
+		metadata = map[string]interface{}{
+			"stream":              topic,
+			"consumerGroup":       appID,
+			"pendingEntriesCount": lag,
+		}
+		if _, ok := credentials["redisHost"]; ok {
+			auth.refs["address"] = "redisHost"
+		} else {
+			metadata["address"] = properties["redisHost"]
+		}
+		auth.param("username", "redisUsername", properties, credentials)
+		auth.param("password", "redisPassword", properties, credentials)
+		if cs.Spec.TLS != nil {
+			metadata["enableTLS"] = "true"
+		}
+	case "rabbitmq":
+		scaler = "rabbitmq"
+		metadata = map[string]interface{}{
+			"queueName": fmt.Sprintf("%s-%s", appID, topic),
+			"mode":      "QueueLength",
+			"value":     lag,
+		}
+		// with authType sasl, the credentials are part of the host URL
+		if _, ok := credentials["host"]; ok {
+			auth.refs["host"] = "host"
+		} else {
+			metadata["host"] = properties["host"]
+		}
+		if cs.Spec.TLS != nil || cs.Spec.AuthType == streamingruntime.AuthTypeMTLS {
+			tlsParams()
+		}
+	default:
+		return nil, nil, fmt.Errorf("autoscaling on the lag of protocol %s is not supported", cs.Spec.Protocol)
+	}
+	return map[string]interface{}{"type": scaler, "metadata": metadata}, auth, nil
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+func TestLagTrigger(t *testing.T) {
+	secret := func(keys ...string) map[string][]byte {
+		data := make(map[string][]byte)
+		for _, k := range keys {
+			data[k] = []byte("secret")
+		}
+		return data
+	}
+	tests := []struct {
+		name        string
+		spec        streamingruntime.ClusterStreamSpec
+		credentials map[string][]byte
+		wantType    string
+		wantMeta    map[string]interface{}
+		wantRefs    map[string]string
+		wantValues  map[string]string
+		wantErr     string
+	}{
+		{
+			name:     "kafka",
+			spec:     streamingruntime.ClusterStreamSpec{Protocol: "kafka", Properties: map[string]string{"brokers": "b:9092"}},
+			wantType: "kafka",
+			wantMeta: map[string]interface{}{"bootstrapServers": "b:9092", "consumerGroup": "app", "topic": "orders", "lagThreshold": "10"},
+		},
+		{
+			name: "kafka scram with secret credentials",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol: "kafka", AuthType: streamingruntime.AuthTypeSCRAMSHA256,
+				Properties: map[string]string{"brokers": "b:9092", "consumerGroup": "group", "saslUsername": "user"},
+			},
+			credentials: secret("saslPassword"),
+			wantType:    "kafka",
+			wantMeta:    map[string]interface{}{"bootstrapServers": "b:9092", "consumerGroup": "group", "topic": "orders", "lagThreshold": "10"},
+			wantRefs:    map[string]string{"password": "saslPassword"},
+			wantValues:  map[string]string{"sasl": "scram_sha256", "username": "user"},
+		},
+		{
+			name: "kafka oauth over tls",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol: "kafka", AuthType: streamingruntime.AuthTypeOAuth,
+				Properties: map[string]string{"brokers": "b:9093", "oidcTokenEndpoint": "https://idp/token", "oidcClientID": "id"},
+				TLS:        &streamingruntime.ClusterStreamTLS{CASecretRef: &streamingruntime.SecretKeyReference{Name: "ca", Key: "ca.crt"}, InsecureSkipVerify: true},
+			},
+			credentials: secret("oidcClientSecret", "caCert"),
+			wantType:    "kafka",
+			wantMeta:    map[string]interface{}{"bootstrapServers": "b:9093", "consumerGroup": "app", "topic": "orders", "lagThreshold": "10", "unsafeSsl": "true"},
+			wantRefs:    map[string]string{"password": "oidcClientSecret", "ca": "caCert"},
+			wantValues:  map[string]string{"sasl": "oauthbearer", "username": "id", "oauthTokenEndpointUri": "https://idp/token", "tls": "enable"},
+		},
+		{
+			name: "kafka mtls",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol: "kafka", AuthType: streamingruntime.AuthTypeMTLS, Properties: map[string]string{"brokers": "b:9093"},
+				TLS: &streamingruntime.ClusterStreamTLS{ClientCertSecretRef: &corev1.SecretReference{Name: "client"}},
+			},
+			credentials: secret("clientCert", "clientKey"),
+			wantType:    "kafka",
+			wantMeta:    map[string]interface{}{"bootstrapServers": "b:9093", "consumerGroup": "app", "topic": "orders", "lagThreshold": "10"},
+			wantRefs:    map[string]string{"cert": "clientCert", "key": "clientKey"},
+			wantValues:  map[string]string{"tls": "enable"},
+		},
+		{
+			name:        "kafka secret brokers",
+			spec:        streamingruntime.ClusterStreamSpec{Protocol: "kafka"},
+			credentials: secret("brokers"),
+			wantErr:     "brokers property of clusterstream cs not to be a secret",
+		},
+		{
+			name:     "redis",
+			spec:     streamingruntime.ClusterStreamSpec{Protocol: "redis", Properties: map[string]string{"redisHost": "redis:6379"}},
+			wantType: "redis-streams",
+			wantMeta: map[string]interface{}{"address": "redis:6379", "stream": "orders", "consumerGroup": "app", "pendingEntriesCount": "10"},
+		},
+		{
+			name: "redis password over tls",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol: "redis", AuthType: streamingruntime.AuthTypeSASL,
+				Properties: map[string]string{"redisHost": "redis:6379"}, TLS: &streamingruntime.ClusterStreamTLS{},
+			},
+			credentials: secret("redisPassword"),
+			wantType:    "redis-streams",
+			wantMeta:    map[string]interface{}{"address": "redis:6379", "stream": "orders", "consumerGroup": "app", "pendingEntriesCount": "10", "enableTLS": "true"},
+			wantRefs:    map[string]string{"password": "redisPassword"},
+		},
+		{
+			name:        "redis secret host",
+			spec:        streamingruntime.ClusterStreamSpec{Protocol: "redis"},
+			credentials: secret("redisHost"),
+			wantType:    "redis-streams",
+			wantMeta:    map[string]interface{}{"stream": "orders", "consumerGroup": "app", "pendingEntriesCount": "10"},
+			wantRefs:    map[string]string{"address": "redisHost"},
+		},
+		{
+			name:     "rabbitmq",
+			spec:     streamingruntime.ClusterStreamSpec{Protocol: "rabbitmq", Properties: map[string]string{"host": "amqp://rabbit:5672"}},
+			wantType: "rabbitmq",
+			wantMeta: map[string]interface{}{"host": "amqp://rabbit:5672", "queueName": "app-orders", "mode": "QueueLength", "value": "10"},
+		},
+		{
+			name: "rabbitmq sasl over tls",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol: "rabbitmq", AuthType: streamingruntime.AuthTypeSASL,
+				TLS: &streamingruntime.ClusterStreamTLS{CASecretRef: &streamingruntime.SecretKeyReference{Name: "ca", Key: "ca.crt"}},
+			},
+			credentials: secret("host", "caCert"),
+			wantType:    "rabbitmq",
+			wantMeta:    map[string]interface{}{"queueName": "app-orders", "mode": "QueueLength", "value": "10"},
+			wantRefs:    map[string]string{"host": "host", "ca": "caCert"},
+			wantValues:  map[string]string{"tls": "enable"},
+		},
+		{name: "unsupported protocol", spec: streamingruntime.ClusterStreamSpec{Protocol: "jetstream"}, wantErr: "protocol jetstream is not supported"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := &streamingruntime.ClusterStream{ObjectMeta: metav1.ObjectMeta{Name: "cs"}, Spec: test.spec}
+			trigger, auth, err := lagTrigger(cs, test.credentials, "orders", "app", 10)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trigger["type"] != test.wantType {
+				t.Errorf("got type %v, want %s", trigger["type"], test.wantType)
+			}
+			if !reflect.DeepEqual(trigger["metadata"], test.wantMeta) {
+				t.Errorf("got metadata %v, want %v", trigger["metadata"], test.wantMeta)
+			}
+			if test.wantRefs == nil {
+				test.wantRefs = map[string]string{}
+			}
+			if test.wantValues == nil {
+				test.wantValues = map[string]string{}
+			}
+			if !reflect.DeepEqual(auth.refs, test.wantRefs) {
+				t.Errorf("got refs %v, want %v", auth.refs, test.wantRefs)
+			}
+			if !reflect.DeepEqual(auth.values, test.wantValues) {
+				t.Errorf("got values %v, want %v", auth.values, test.wantValues)
+			}
+		})
+	}
+}
+
+func TestTriggerAuthObjects(t *testing.T) {
+	owner := &streamingruntime.Channel{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns"}}
+	auth := &triggerAuth{
+		refs:   map[string]string{"password": "saslPassword"},
+		values: map[string]string{"username": "user", "sasl": "plaintext"},
+	}
+	objects := auth.objects(owner, "orders-kafka", "kafka-credentials")
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, want a Secret and a TriggerAuthentication", len(objects))
+	}
+
+	secret, ok := objects[0].(*corev1.Secret)
+	if !ok {
+		t.Fatalf("got %T, want a Secret", objects[0])
+	}
+	wantData := map[string][]byte{"sasl": []byte("plaintext"), "username": []byte("user")}
+	if secret.Name != "orders-kafka" || secret.Namespace != "ns" || !reflect.DeepEqual(secret.Data, wantData) {
+		t.Errorf("got secret %s/%s %v", secret.Namespace, secret.Name, secret.Data)
+	}
+	if secret.Labels[autoscalingLabel] != "orders" || secret.Labels[credentialsLabel] != "true" {
+		t.Errorf("got labels %v", secret.Labels)
+	}
+
+	authentication, ok := objects[1].(*unstructured.Unstructured)
+	if !ok || authentication.GroupVersionKind() != triggerAuthenticationGVK {
+		t.Fatalf("got %T, want a TriggerAuthentication", objects[1])
+	}
+	wantRefs := []interface{}{
+		map[string]interface{}{"parameter": "sasl", "name": "orders-kafka", "key": "sasl"},
+		map[string]interface{}{"parameter": "username", "name": "orders-kafka", "key": "username"},
+		map[string]interface{}{"parameter": "password", "name": "kafka-credentials", "key": "saslPassword"},
+	}
+	refs, _, _ := unstructured.NestedSlice(authentication.Object, "spec", "secretTargetRef")
+	if !reflect.DeepEqual(refs, wantRefs) {
+		t.Errorf("got refs %v, want %v", refs, wantRefs)
+	}
+
+	// without rendered parameters, only the credentials are referenced
+	objects = (&triggerAuth{refs: map[string]string{"host": "host"}, values: map[string]string{}}).objects(owner, "orders-rabbit", "rabbit-credentials")
+	if len(objects) != 1 {
+		t.Errorf("got %d objects, want a TriggerAuthentication", len(objects))
+	}
+}
@@ -19,8 +19,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -28,7 +30,9 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
 )
@@ -47,6 +51,11 @@ type ChannelReconciler struct {
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=channels/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=channels/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams,verbs=get;list;watch
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams,verbs=get;list;watch
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=triggerauthentications,verbs=get;list;watch;create;update;patch;delete
 
 func (r *ChannelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
@@ -70,21 +79,49 @@ func (r *ChannelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil // do nothing, stop reconciliation
 	}
 
-	// Retrieve joiner deployment component
+	if len(channel.Spec.Stream.From) == 0 {
+		err := fmt.Errorf("channel stream.from must have a stream specified")
+		log.Error(err, "Invalid Channel", "Name", channel.Name, "Namespace", channel.Namespace)
+		return ctrl.Result{}, nil
+	}
+
+	// the deployment is rendered from the source stream, and scaled on the lag of
+	// its clusterstream: missing streams and clusterstreams are reported in the status
+	streams, missing, err := resolveStreams(ctx, r.Client, channel.Namespace, channel.Spec.Stream.From)
+	if err != nil {
+		log.Error(err, "Failed to get streams for Channel", "Name", channel.Name, "Namespace", channel.Namespace)
+		return ctrl.Result{}, err
+	}
+	missingClusterStreams, err := resolveClusterStreams(ctx, r.Client, streams)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	missing = append(missing, missingClusterStreams...)
+	if err := updateReferencesResolved(ctx, r.Client, channel, &channel.Status.Conditions, missing); err != nil {
+		log.Error(err, "Failed to update Channel status", "Name", channel.Name, "Namespace", channel.Namespace)
+		return ctrl.Result{}, err
+	}
+	if len(missing) > 0 {
+		log.Info("Channel references not found", "Name", channel.Name, "Namespace", channel.Namespace, "Missing", missing)
+		return ctrl.Result{}, nil
+	}
+
+	desired, err := r.createChanDeployment(channel, streams[0])
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Retrieve channel deployment component
 	// if not found, create it
 	deployment := new(appsv1.Deployment)
 	err = r.Get(ctx, types.NamespacedName{Name: channel.Name, Namespace: channel.Namespace}, deployment)
 	if err != nil && errors.IsNotFound(err) {
-		log.V(1).Info("Creating deployment for Joiner",
+		log.V(1).Info("Creating deployment for Channel",
 			"Name", channel.Name,
 			"Namespace", channel.Namespace,
 		)
 
-		deployment, err = r.createChanDeployment(ctx, channel)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-
+		deployment = desired
 		if err := r.Create(ctx, deployment); err != nil {
 			log.Error(err, "Failed to create deployment for Channel",
 				"Name", channel.Name,
@@ -101,7 +138,25 @@ func (r *ChannelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{Requeue: true}, nil
 
 	} else if err != nil {
-		log.Error(err, "Failed to get Joiner deployment", "Name", channel.Name, "Namespace", channel.Namespace)
+		log.Error(err, "Failed to get Channel deployment", "Name", channel.Name, "Namespace", channel.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	// the pod template is rendered from the channel and its source stream
+	if deployment.Annotations[templateHashAnnotation] != desired.Annotations[templateHashAnnotation] {
+		deployment.Spec.Template = desired.Spec.Template
+		if err := setTemplateHash(&deployment.ObjectMeta, desired.Spec.Template); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, deployment); err != nil {
+			log.Error(err, "Failed to update deployment for Channel", "Name", channel.Name, "Namespace", channel.Namespace)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// scale the channel with the load of its input stream
+	if err := reconcileAutoscaler(ctx, r.Client, r.Scheme, channel, channel.Spec.Autoscaling, streams); err != nil {
+		log.Error(err, "Failed to reconcile autoscaler for Channel", "Name", channel.Name, "Namespace", channel.Namespace)
 		return ctrl.Result{}, err
 	}
 
@@ -117,10 +172,80 @@ func (r *ChannelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&streamingruntime.Channel{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&autoscalingv2beta2.HorizontalPodAutoscaler{}).
+		Watches(&source.Kind{Type: &streamingruntime.Stream{}}, handler.EnqueueRequestsFromMapFunc(requestsForStream(r.Client, channelList))).
+		Watches(&source.Kind{Type: &streamingruntime.ClusterStream{}}, handler.EnqueueRequestsFromMapFunc(requestsForClusterStream(r.Client, channelList))).
 		Complete(r)
 }
 
-func (r *ChannelReconciler) createChanDeployment(ctx context.Context, channel *streamingruntime.Channel) (*appsv1.Deployment, error) {
+func channelList() client.ObjectList {
+	return new(streamingruntime.ChannelList)
+}
+
+// validateChannelAutoscaling validates the autoscaling of a channel. Channels keeping
+// state in memory (aggregates, or dedup without state store) cannot be scaled safely.
+func validateChannelAutoscaling(channel *streamingruntime.Channel, mode string) error {
+	if channel.Spec.Autoscaling == nil {
+		return nil
+	}
+	if mode == "aggregate" {
+		return fmt.Errorf("channel autoscaling is not supported in aggregate mode")
+	}
+	if channel.Spec.State != nil {
+		return fmt.Errorf("channel autoscaling is not supported with keyed state")
+	}
+	if channel.Spec.Dedup != nil && channel.Spec.Dedup.Store == "" {
+		return fmt.Errorf("channel autoscaling requires dedup.store to share seen ids across replicas")
+	}
+	return validateAutoscaling(channel.Spec.Autoscaling)
+}
+
+// routesEnv returns the env variables used to configure the routes of a channel:
+// CHANNEL_ROUTES (number of routes), CHANNEL_ROUTE_MATCH, and for each route i,
+// CHANNEL_ROUTE_<i>_WHEN, CHANNEL_ROUTE_<i>_SELECT, CHANNEL_ROUTE_<i>_TARGETS (number of targets),
+// and, for each target j, CHANNEL_ROUTE_<i>_TO_<j>_STREAM, CHANNEL_ROUTE_<i>_TO_<j>_COMPONENT, and codec.
+func routesEnv(routes []streamingruntime.ChannelRoute, match string) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount, error) {
+	if len(routes) == 0 {
+		return nil, nil, nil, nil
+	}
+	if match == "" {
+		match = "first"
+	}
+	env := []corev1.EnvVar{
+		{Name: "CHANNEL_ROUTES", Value: strconv.Itoa(len(routes))},
+		{Name: "CHANNEL_ROUTE_MATCH", Value: match},
+	}
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for i, route := range routes {
+		if len(route.To) == 0 {
+			return nil, nil, nil, fmt.Errorf("channel routes[%d].to must have a target", i)
+		}
+		prefix := fmt.Sprintf("CHANNEL_ROUTE_%d", i)
+		env = append(env,
+			corev1.EnvVar{Name: prefix + "_WHEN", Value: route.When},
+			corev1.EnvVar{Name: prefix + "_SELECT", Value: route.Select},
+			corev1.EnvVar{Name: prefix + "_TARGETS", Value: strconv.Itoa(len(route.To))},
+		)
+		for j, target := range route.To {
+			if target.Stream == "" && target.Component == "" {
+				return nil, nil, nil, fmt.Errorf("channel routes[%d].to[%d] must have a stream or a component specified", i, j)
+			}
+			targetPrefix := fmt.Sprintf("%s_TO_%d", prefix, j)
+			env = append(env,
+				corev1.EnvVar{Name: targetPrefix + "_STREAM", Value: validateTarget(target.Stream)},
+				corev1.EnvVar{Name: targetPrefix + "_COMPONENT", Value: validateTarget(target.Component)},
+			)
+			codecVars, codecVols, codecMounts := codecEnv(targetPrefix, fmt.Sprintf("codec-route-%d-%d", i, j), target.Codec)
+			env = append(env, codecVars...)
+			volumes = append(volumes, codecVols...)
+			mounts = append(mounts, codecMounts...)
+		}
+	}
+	return env, volumes, mounts, nil
+}
+
+func (r *ChannelReconciler) createChanDeployment(channel *streamingruntime.Channel, source *streamingruntime.Stream) (*appsv1.Deployment, error) {
 	var replicas int32 = 1
 
 	// resolve container
@@ -142,7 +267,12 @@ func (r *ChannelReconciler) createChanDeployment(ctx context.Context, channel *s
 		ContainerPort: channel.Spec.ServicePort,
 	})
 
-	if channel.Spec.Stream.To[0].Stream == "" && channel.Spec.Stream.To[0].Component == "" {
+	// with routes, the stream target is optional
+	var target streamingruntime.OutputTarget
+	if len(channel.Spec.Stream.To) > 0 {
+		target = channel.Spec.Stream.To[0]
+	}
+	if target.Stream == "" && target.Component == "" && len(channel.Spec.Routes) == 0 {
 		return nil, fmt.Errorf("channel stream.To must have a stream or a component specified")
 	}
 
@@ -153,17 +283,69 @@ func (r *ChannelReconciler) createChanDeployment(ctx context.Context, channel *s
 	if mode == "aggregate" && channel.Spec.Trigger == "" {
 		return nil, fmt.Errorf("channel missing aggregate trigger expression")
 	}
+	if err := validateChannelAutoscaling(channel, mode); err != nil {
+		return nil, err
+	}
+	if channel.Spec.Autoscaling != nil {
+		replicas = autoscalingMinReplicas(channel.Spec.Autoscaling)
+	}
 
 	container.Env = []corev1.EnvVar{
 		{Name: "CHANNEL_SERVICE_PORT", Value: fmt.Sprintf(":%d", channel.Spec.ServicePort)},
 		{Name: "CHANNEL_MODE", Value: mode},
 		{Name: "CHANNEL_AGGREGATE_TRIGGER", Value: channel.Spec.Trigger},
 		{Name: "CHANNEL_STREAM_FROM", Value: channel.Spec.Stream.From[0]},
-		{Name: "CHANNEL_STREAM_TO_STREAM", Value: channel.Spec.Stream.To[0].Stream},
-		{Name: "CHANNEL_STREAM_TO_COMPONENT", Value: channel.Spec.Stream.To[0].Component},
+		{Name: "CHANNEL_STREAM_TO_STREAM", Value: target.Stream},
+		{Name: "CHANNEL_STREAM_TO_COMPONENT", Value: target.Component},
 		{Name: "CHANNEL_STREAM_WHERE", Value: channel.Spec.Stream.Where},
 		{Name: "CHANNEL_STREAM_SELECT", Value: channel.Spec.Stream.Select},
+		{Name: "CHANNEL_STREAM_LIB_VERSION", Value: strconv.Itoa(channel.Spec.Stream.LibVersion)},
+		{Name: "CHANNEL_STREAM_INDEX_FIELD", Value: channel.Spec.Stream.IndexField},
+	}
+
+	if channel.Spec.State != nil {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "CHANNEL_STATE_STORE", Value: channel.Spec.State.Store},
+			corev1.EnvVar{Name: "CHANNEL_STATE_KEY", Value: channel.Spec.State.Key},
+			corev1.EnvVar{Name: "CHANNEL_STATE_INITIAL", Value: channel.Spec.State.Initial},
+			corev1.EnvVar{Name: "CHANNEL_STATE_UPDATE", Value: channel.Spec.State.Update},
+		)
+	}
+
+	if channel.Spec.Dedup != nil {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "CHANNEL_DEDUP_ENABLED", Value: "true"},
+			corev1.EnvVar{Name: "CHANNEL_DEDUP_ID", Value: channel.Spec.Dedup.ID},
+			corev1.EnvVar{Name: "CHANNEL_DEDUP_TTL", Value: channel.Spec.Dedup.TTL},
+			corev1.EnvVar{Name: "CHANNEL_DEDUP_SIZE", Value: strconv.Itoa(channel.Spec.Dedup.Size)},
+			corev1.EnvVar{Name: "CHANNEL_DEDUP_STORE", Value: channel.Spec.Dedup.Store},
+		)
+	}
+
+	// setup codecs for source stream and output target
+	var volumes []corev1.Volume
+	env, vols, mounts := codecEnv("CHANNEL_STREAM_FROM", "codec-from", source.Spec.Codec)
+	container.Env = append(container.Env, env...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	volumes = append(volumes, vols...)
+
+	env, vols, mounts = schemaEnv("CHANNEL_STREAM_FROM", "schema-from", source.Spec.Schema)
+	container.Env = append(container.Env, env...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	volumes = append(volumes, vols...)
+
+	env, vols, mounts = codecEnv("CHANNEL_STREAM_TO", "codec-to", target.Codec)
+	container.Env = append(container.Env, env...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	volumes = append(volumes, vols...)
+
+	env, vols, mounts, err := routesEnv(channel.Spec.Routes, channel.Spec.RouteMatch)
+	if err != nil {
+		return nil, err
 	}
+	container.Env = append(container.Env, env...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	volumes = append(volumes, vols...)
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -187,10 +369,14 @@ func (r *ChannelReconciler) createChanDeployment(ctx context.Context, channel *s
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{container},
+					Volumes:    volumes,
 				},
 			},
 		},
 	}
+	if err := setTemplateHash(&deployment.ObjectMeta, deployment.Spec.Template); err != nil {
+		return nil, err
+	}
 
 	// establish ownership
 	if err := ctrl.SetControllerReference(channel, deployment, r.Scheme); err != nil {
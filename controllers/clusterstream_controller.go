@@ -20,19 +20,44 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	daprcomponents "github.com/dapr/dapr/pkg/apis/components/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/controllers/probe"
+)
+
+const (
+	// clusterStreamLabel labels the resources projected for a ClusterStream with its name
+	clusterStreamLabel = "streaming.vivien.io/clusterstream"
+	// credentialsLabel labels the Secrets read by ClusterStreams, and their projected
+	// copies. Only the Secrets with this label are watched and cached.
+	credentialsLabel = "streaming.vivien.io/credentials"
+	// clusterStreamFinalizer blocks the deletion of a ClusterStream while Streams reference it
+	clusterStreamFinalizer = "streaming.vivien.io/clusterstream-protection"
+
+	defaultProbePeriod  = 30 * time.Second
+	defaultProbeTimeout = 5 * time.Second
 )
 
 // ClusterStreamReconciler reconciles a ClusterStream object
@@ -45,9 +70,17 @@ type ClusterStreamReconciler struct {
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams/finalizers,verbs=update
 //+kubebuilder:rbac:groups=dapr.io,resources=components,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=channels,verbs=get;list;watch
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=joiners,verbs=get;list;watch
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=processors,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
+// The pubsub component of a ClusterStream is projected, on demand, in the namespaces
+// where it is used by Streams or components and allowed by allowedNamespaces.
 func (r *ClusterStreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx) // get logger from context
 
@@ -56,56 +89,80 @@ func (r *ClusterStreamReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			log.Info("ClusterStream not found, ignoring", "Name", req.Name, "Namespace", req.Namespace)
+			log.Info("ClusterStream not found, ignoring", "Name", req.Name)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
-		log.Error(err, "Failed to fetch ClusterStream", "Name", req.Name, "Namespace", req.Namespace)
+		log.Error(err, "Failed to fetch ClusterStream", "Name", req.Name)
 		return ctrl.Result{}, err
 	}
 
-	// Add associated dapr component if not found
-	component := new(daprcomponents.Component)
-	err = r.Get(ctx, types.NamespacedName{Name: cs.Name, Namespace: cs.Namespace}, component)
-	if err != nil && errors.IsNotFound(err) {
-		// New component
-		componentType := fmt.Sprintf("pubsub.%s", cs.Spec.Protocol)
-		component, err = r.createClusterStream(ctx, componentType, cs)
-		if err != nil {
+	// is item being deleted?
+	if !cs.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.finalizeClusterStream(ctx, cs)
+	}
+	if !controllerutil.ContainsFinalizer(cs, clusterStreamFinalizer) {
+		controllerutil.AddFinalizer(cs, clusterStreamFinalizer)
+		if err := r.Update(ctx, cs); err != nil {
+			log.Error(err, "Failed to add ClusterStream finalizer", "Name", cs.Name)
 			return ctrl.Result{}, err
 		}
+	}
 
-		log.V(1).Info("Creating pub/sub dapr component for ClusterStream",
-			"Namespace", component.Namespace,
-			"Name", component.Name,
-			"Type", componentType)
+	secrets, missing, err := readClusterStreamSecrets(ctx, r.Client, cs)
+	if err != nil {
+		log.Error(err, "Failed to read ClusterStream secrets", "Name", cs.Name)
+		return ctrl.Result{}, err
+	}
+	if err := validateClusterStream(cs, secrets); err != nil || len(missing) > 0 {
+		message := fmt.Sprintf("unresolved secrets: %s", strings.Join(missing, ", "))
+		if err != nil {
+			message = err.Error()
+		}
+		log.Info("Invalid ClusterStream", "Name", cs.Name, "Error", message)
+		meta.SetStatusCondition(&cs.Status.Conditions, metav1.Condition{
+			Type:               streamingruntime.ClusterStreamConditionValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: cs.Generation,
+			Reason:             "Invalid",
+			Message:            message,
+		})
+		cs.Status.Status = "Error"
+		cs.Status.TLS = false
+		return ctrl.Result{}, r.Status().Update(ctx, cs)
+	}
+	meta.SetStatusCondition(&cs.Status.Conditions, metav1.Condition{
+		Type:               streamingruntime.ClusterStreamConditionValid,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cs.Generation,
+		Reason:             "Valid",
+	})
+	cs.Status.TLS = cs.Spec.TLS != nil
 
-		if err := r.Create(ctx, component); err != nil {
-			log.Error(err, "Failed to create pub/sub dapr component for ClusterStream",
-				"Component.Namespace", component.Namespace,
-				"Component.Name", component.Name,
-				"Component.Type", componentType)
+	namespaces, err := r.consumingNamespaces(ctx, cs)
+	if err != nil {
+		log.Error(err, "Failed to find namespaces using ClusterStream", "Name", cs.Name)
+		return ctrl.Result{}, err
+	}
+	for _, namespace := range namespaces {
+		if err := r.projectClusterStream(ctx, cs, namespace, secrets); err != nil {
+			log.Error(err, "Failed to project ClusterStream", "Name", cs.Name, "Namespace", namespace)
 			return ctrl.Result{}, err
 		}
-
-		log.Info("Pubsub component created successfully", "Component.Name", component.Name, "Component.Namespace", component.Namespace)
-		return ctrl.Result{Requeue: true}, nil
-
-	} else if err != nil {
-		log.Error(err, "Failed to get Component", "Component.Name", cs.Name, "Component.Namespace", cs.Namespace)
+	}
+	if err := r.deleteProjections(ctx, cs, namespaces); err != nil {
+		log.Error(err, "Failed to delete ClusterStream projections", "Name", cs.Name)
 		return ctrl.Result{}, err
 	}
+	cs.Status.Namespaces = namespaces
 
-	// Update status for the
-	cs.Status.Provider = streamingruntime.KafkaProtocolName
-	brokers := getMetadataStringValues("brokers", cs.Spec.Properties)
-
-	if len(brokers) == 0 {
-		cs.Status.Status = "Error"
-		err = fmt.Errorf("kafka: missing bootstrap servers")
-	} else {
-		cs.Status.Status = "Ready"
-		cs.Status.Servers = strings.Join(brokers, ",")
+	// Update status for the stream, ready unless the probe fails
+	cs.Status.Provider = cs.Spec.Protocol
+	cs.Status.Servers = strings.Join(getMetadataStringValues("brokers", cs.Spec.Properties), ",")
+	cs.Status.Status = "Ready"
+	next := r.probeClusterStream(ctx, cs, secrets)
+	if meta.IsStatusConditionFalse(cs.Status.Conditions, streamingruntime.ClusterStreamConditionReachable) {
+		cs.Status.Status = "Unreachable"
 	}
 
 	if statErr := r.Status().Update(ctx, cs); statErr != nil {
@@ -113,24 +170,509 @@ func (r *ClusterStreamReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, statErr
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: next}, nil
+}
+
+// finalizeClusterStream removes the finalizer of a deleted ClusterStream once no Stream
+// references it, the projected components are then deleted with the ClusterStream. With
+// the Cascade deletion policy the Streams are deleted, until they are gone the
+// DeletionBlocked condition lists them.
+func (r *ClusterStreamReconciler) finalizeClusterStream(ctx context.Context, cs *streamingruntime.ClusterStream) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(cs, clusterStreamFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	streams := new(streamingruntime.StreamList)
+	if err := r.List(ctx, streams, client.MatchingFields{clusterStreamIndex: cs.Name}); err != nil {
+		log.Error(err, "Failed to list Streams of ClusterStream", "Name", cs.Name)
+		return ctrl.Result{}, err
+	}
+	if len(streams.Items) == 0 {
+		controllerutil.RemoveFinalizer(cs, clusterStreamFinalizer)
+		if err := r.Update(ctx, cs); err != nil {
+			log.Error(err, "Failed to remove ClusterStream finalizer", "Name", cs.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("ClusterStream finalized", "Name", cs.Name)
+		return ctrl.Result{}, nil
+	}
+
+	cascade := cs.Spec.DeletionPolicy == streamingruntime.ClusterStreamDeletionCascade
+	var names []string
+	for i := range streams.Items {
+		stream := &streams.Items[i]
+		names = append(names, fmt.Sprintf("%s/%s", stream.Namespace, stream.Name))
+		if !cascade || !stream.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if err := r.Delete(ctx, stream); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Stream of ClusterStream", "Name", cs.Name, "Stream", stream.Name, "Namespace", stream.Namespace)
+			return ctrl.Result{}, err
+		}
+		log.Info("Deleted Stream of ClusterStream", "Name", cs.Name, "Stream", stream.Name, "Namespace", stream.Namespace)
+	}
+	sort.Strings(names)
+
+	// the deletions of the streams reconcile the clusterstream again
+	status := cs.Status.DeepCopy()
+	blocked := metav1.Condition{
+		Type:               streamingruntime.ClusterStreamConditionDeletionBlocked,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cs.Generation,
+		Reason:             "StreamsReferencing",
+		Message:            fmt.Sprintf("referenced by streams %s", strings.Join(names, ", ")),
+	}
+	if cascade {
+		blocked.Reason = "StreamsDeleting"
+		blocked.Message = fmt.Sprintf("deleting streams %s", strings.Join(names, ", "))
+	}
+	meta.SetStatusCondition(&cs.Status.Conditions, blocked)
+	cs.Status.Status = "Terminating"
+	if equality.Semantic.DeepEqual(status, &cs.Status) {
+		return ctrl.Result{}, nil
+	}
+	log.Info("ClusterStream deletion blocked", "Name", cs.Name, "Streams", names)
+	return ctrl.Result{}, r.Status().Update(ctx, cs)
+}
+
+// probeClusterStream probes the brokers of a ClusterStream, when its probe is enabled
+// and the last probe is older than the probe period, and sets the Reachable condition.
+// It returns the delay until the next probe, 0 if the probe is disabled.
+func (r *ClusterStreamReconciler) probeClusterStream(ctx context.Context, cs *streamingruntime.ClusterStream, secrets map[string][]byte) time.Duration {
+	log := log.FromContext(ctx)
+	spec := cs.Spec.Probe
+	if spec == nil {
+		meta.RemoveStatusCondition(&cs.Status.Conditions, streamingruntime.ClusterStreamConditionReachable)
+		cs.Status.LastProbeTime = nil
+		cs.Status.LastProbeError = ""
+		return 0
+	}
+	period, timeout := defaultProbePeriod, defaultProbeTimeout
+	if spec.PeriodSeconds > 0 {
+		period = time.Duration(spec.PeriodSeconds) * time.Second
+	}
+	if spec.TimeoutSeconds > 0 {
+		timeout = time.Duration(spec.TimeoutSeconds) * time.Second
+	}
+	// the status updates of the probes reconcile the stream again, before the period
+	condition := meta.FindStatusCondition(cs.Status.Conditions, streamingruntime.ClusterStreamConditionReachable)
+	if last := cs.Status.LastProbeTime; last != nil && condition != nil && condition.ObservedGeneration == cs.Generation {
+		if wait := time.Until(last.Add(period)); wait > 0 {
+			return wait
+		}
+	}
+
+	var description string
+	tlsConfig, err := clusterStreamTLSConfig(cs, secrets)
+	if err == nil {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		description, err = probe.Probe(probeCtx, cs.Spec.Protocol, connectionProperties(cs, secrets), tlsConfig)
+		cancel()
+	}
+
+	now := metav1.Now()
+	cs.Status.LastProbeTime = &now
+	cs.Status.LastProbeError = ""
+	reachable := metav1.Condition{
+		Type:               streamingruntime.ClusterStreamConditionReachable,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cs.Generation,
+		Reason:             "Connected",
+		Message:            description,
+	}
+	if err != nil {
+		log.Info("ClusterStream probe failed", "Name", cs.Name, "Error", err.Error())
+		cs.Status.LastProbeError = err.Error()
+		reachable.Status = metav1.ConditionFalse
+		reachable.Reason = "ProbeFailed"
+		reachable.Message = err.Error()
+	}
+	meta.SetStatusCondition(&cs.Status.Conditions, reachable)
+	return period
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterStreamReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// objects using a ClusterStream enqueue it, to project its component in their namespace
+	referenced := handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		var names []string
+		if stream, ok := obj.(*streamingruntime.Stream); ok {
+			names = append(names, stream.Spec.ClusterStream)
+		} else {
+			names = outputClusterStreams(obj)
+		}
+		var requests []reconcile.Request
+		for _, name := range names {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		}
+		return requests
+	})
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&streamingruntime.ClusterStream{}).
 		Owns(&daprcomponents.Component{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &streamingruntime.Stream{}}, referenced).
+		Watches(&source.Kind{Type: &streamingruntime.Channel{}}, referenced).
+		Watches(&source.Kind{Type: &streamingruntime.Joiner{}}, referenced).
+		Watches(&source.Kind{Type: &streamingruntime.Processor{}}, referenced).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForNamespace)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForCredentials)).
 		Complete(r)
 }
 
-// createClusterStream is a helper method to create v1alpha1 Dapr Component API objects
-func (r *ClusterStreamReconciler) createClusterStream(ctx context.Context, componentType string, clusterStream *streamingruntime.ClusterStream) (*daprcomponents.Component, error) {
+// requestsForNamespace enqueues the ClusterStreams selecting namespaces, whose
+// labels may have changed
+func (r *ClusterStreamReconciler) requestsForNamespace(_ client.Object) []reconcile.Request {
+	return r.listClusterStreams(func(cs *streamingruntime.ClusterStream) bool {
+		return cs.Spec.AllowedNamespaces != nil
+	})
+}
+
+// requestsForCredentials enqueues the ClusterStreams using a Secret as credentials
+// or in secret properties
+func (r *ClusterStreamReconciler) requestsForCredentials(obj client.Object) []reconcile.Request {
+	list := new(streamingruntime.ClusterStreamList)
+	key := fmt.Sprintf("%s/%s", obj.GetNamespace(), obj.GetName())
+	if err := r.List(context.Background(), list, client.MatchingFields{credentialsIndex: key}); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, cs := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: cs.Name}})
+	}
+	return requests
+}
+
+func (r *ClusterStreamReconciler) listClusterStreams(match func(*streamingruntime.ClusterStream) bool) []reconcile.Request {
+	list := new(streamingruntime.ClusterStreamList)
+	if err := r.List(context.Background(), list); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range list.Items {
+		if match(&list.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: list.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+// consumingNamespaces returns the sorted namespaces where a ClusterStream is used, by
+// Streams or by components sending to its topics, and allowed by allowedNamespaces
+func (r *ClusterStreamReconciler) consumingNamespaces(ctx context.Context, cs *streamingruntime.ClusterStream) ([]string, error) {
+	users := []struct {
+		field string
+		list  client.ObjectList
+	}{
+		{clusterStreamIndex, new(streamingruntime.StreamList)},
+		{outputClusterStreamsIndex, new(streamingruntime.ChannelList)},
+		{outputClusterStreamsIndex, new(streamingruntime.JoinerList)},
+		{outputClusterStreamsIndex, new(streamingruntime.ProcessorList)},
+	}
+	used := make(map[string]bool)
+	for _, user := range users {
+		if err := r.List(ctx, user.list, client.MatchingFields{user.field: cs.Name}); err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(user.list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			used[item.(client.Object).GetNamespace()] = true
+		}
+	}
+
+	var namespaces []string
+	for namespace := range used {
+		allowed, err := namespaceAllowed(ctx, r.Client, cs, namespace)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if allowed {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// credentialsSecrets returns the namespace/name of the Secrets read by a ClusterStream
+func credentialsSecrets(obj client.Object) []string {
+	cs := obj.(*streamingruntime.ClusterStream)
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(namespace, name string) {
+		key := fmt.Sprintf("%s/%s", namespace, name)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if ref := cs.Spec.CredentialsSecretRef; ref != nil {
+		add(ref.Namespace, ref.Name)
+	}
+	for _, ref := range secretProperties(cs) {
+		add(ref.Namespace, ref.Name)
+	}
+	return keys
+}
+
+// CacheSelectors restricts the Secrets watched and cached by the manager to the
+// Secrets labelled with credentialsLabel
+func CacheSelectors() cache.SelectorsByObject {
+	return cache.SelectorsByObject{
+		&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{credentialsLabel: "true"})},
+	}
+}
+
+// secretProperties returns the secret properties of a ClusterStream, including the
+// certificates of its TLS configuration
+func secretProperties(cs *streamingruntime.ClusterStream) map[string]streamingruntime.SecretKeyReference {
+	properties := make(map[string]streamingruntime.SecretKeyReference)
+	for name, ref := range cs.Spec.SecretProperties {
+		properties[name] = ref
+	}
+	// an invalid TLS configuration is reported by the validation
+	if _, certificates, err := clusterStreamTLS(cs); err == nil {
+		for name, ref := range certificates {
+			properties[name] = ref
+		}
+	}
+	return properties
+}
+
+// connectionProperties returns the properties of a ClusterStream with the metadata of its
+// authentication mode and the values of its secrets, to connect to the brokers as the
+// pubsub component does
+func connectionProperties(cs *streamingruntime.ClusterStream, secrets map[string][]byte) map[string]string {
+	properties := make(map[string]string)
+	for k, v := range cs.Spec.Properties {
+		properties[k] = v
+	}
+	if mode, err := clusterStreamAuth(cs); err == nil {
+		for k, v := range mode.metadata {
+			properties[k] = v
+		}
+	}
+	for k, v := range secrets {
+		properties[k] = string(v)
+	}
+	return properties
+}
+
+// readClusterStreamSecrets returns the values of the credentials Secret and of the secret
+// properties of a ClusterStream, by property name, and the Secrets or keys not found
+func readClusterStreamSecrets(ctx context.Context, c client.Reader, cs *streamingruntime.ClusterStream) (map[string][]byte, []string, error) {
+	secrets := make(map[string][]byte)
+	var missing []string
+	get := func(namespace, name string) (*corev1.Secret, error) {
+		secret := new(corev1.Secret)
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+		switch {
+		case errors.IsNotFound(err):
+			// unlabelled Secrets are not cached
+			missing = append(missing, fmt.Sprintf("secret %s/%s (labelled %s=true)", namespace, name, credentialsLabel))
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return secret, nil
+	}
+
+	if ref := cs.Spec.CredentialsSecretRef; ref != nil {
+		secret, err := get(ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret != nil {
+			for k, v := range secret.Data {
+				secrets[k] = v
+			}
+		}
+	}
+	properties := secretProperties(cs)
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ref := properties[name]
+		secret, err := get(ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret == nil {
+			continue
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("secret %s/%s key %s", ref.Namespace, ref.Name, ref.Key))
+			continue
+		}
+		secrets[name] = value // a secret property takes precedence over the credentials
+	}
+	return secrets, missing, nil
+}
+
+// projectClusterStream creates or updates the pubsub component of a ClusterStream in a
+// namespace, and the copy of its secrets
+func (r *ClusterStreamReconciler) projectClusterStream(ctx context.Context, cs *streamingruntime.ClusterStream, namespace string, secrets map[string][]byte) error {
+	log := log.FromContext(ctx)
+
+	var credentials *corev1.Secret
+	if len(secrets) > 0 {
+		var err error
+		if credentials, err = r.createCredentialsSecret(cs, secrets, namespace); err != nil {
+			return err
+		}
+		existing := new(corev1.Secret)
+		err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: credentials.Name}, existing)
+		switch {
+		case errors.IsNotFound(err):
+			if err := r.Create(ctx, credentials); err != nil {
+				return err
+			}
+			log.Info("Created credentials secret for ClusterStream", "Name", cs.Name, "Namespace", namespace, "Secret", credentials.Name)
+		case err != nil:
+			return err
+		case !metav1.IsControlledBy(existing, cs):
+			return fmt.Errorf("secret %s/%s already exists and is not owned by the clusterstream", namespace, credentials.Name)
+		case !equality.Semantic.DeepEqual(existing.Data, credentials.Data):
+			existing.Data = credentials.Data
+			if err := r.Update(ctx, existing); err != nil {
+				return err
+			}
+			log.Info("Updated credentials secret for ClusterStream", "Name", cs.Name, "Namespace", namespace, "Secret", credentials.Name)
+		}
+	}
+
+	componentType := fmt.Sprintf("pubsub.%s", cs.Spec.Protocol)
+	component, err := r.createClusterStream(ctx, componentType, cs, namespace, credentials)
+	if err != nil {
+		return err
+	}
+	existing := new(daprcomponents.Component)
+	err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: component.Name}, existing)
+	switch {
+	case errors.IsNotFound(err):
+		log.V(1).Info("Creating pub/sub dapr component for ClusterStream",
+			"Namespace", component.Namespace,
+			"Name", component.Name,
+			"Type", componentType)
+
+		if err := r.Create(ctx, component); err != nil {
+			log.Error(err, "Failed to create pub/sub dapr component for ClusterStream",
+				"Component.Namespace", component.Namespace,
+				"Component.Name", component.Name,
+				"Component.Type", componentType)
+			return err
+		}
+		log.Info("Pubsub component created successfully", "Component.Name", component.Name, "Component.Namespace", component.Namespace)
+	case err != nil:
+		log.Error(err, "Failed to get Component", "Component.Name", component.Name, "Component.Namespace", namespace)
+		return err
+	case !metav1.IsControlledBy(existing, cs):
+		return fmt.Errorf("component %s/%s already exists and is not owned by the clusterstream", namespace, component.Name)
+	case !equality.Semantic.DeepEqual(existing.Spec, component.Spec):
+		existing.Spec = component.Spec
+		if err := r.Update(ctx, existing); err != nil {
+			return err
+		}
+		log.Info("Pubsub component updated", "Component.Name", component.Name, "Component.Namespace", component.Namespace)
+	}
+	return nil
+}
+
+// deleteProjections deletes the components and credentials projected for a
+// ClusterStream outside of the namespaces where it is used
+func (r *ClusterStreamReconciler) deleteProjections(ctx context.Context, cs *streamingruntime.ClusterStream, namespaces []string) error {
 	log := log.FromContext(ctx)
-	properties := clusterStream.Spec.Properties
+	keep := make(map[string]bool)
+	for _, namespace := range namespaces {
+		keep[namespace] = true
+	}
+
+	for _, list := range []client.ObjectList{new(daprcomponents.ComponentList), new(corev1.SecretList)} {
+		if err := r.List(ctx, list, client.MatchingLabels{clusterStreamLabel: cs.Name}); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			obj := item.(client.Object)
+			stale := !keep[obj.GetNamespace()]
+			if _, isSecret := obj.(*corev1.Secret); isSecret && cs.Spec.CredentialsSecretRef == nil && len(secretProperties(cs)) == 0 {
+				stale = true // secrets removed from the clusterstream
+			}
+			if !stale || !metav1.IsControlledBy(obj, cs) {
+				continue
+			}
+			if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			log.Info("Deleted ClusterStream projection", "Name", cs.Name, "Namespace", obj.GetNamespace(), "Object", obj.GetName())
+		}
+	}
+	return nil
+}
+
+// credentialsSecretName returns the name of the copy of the credentials of a ClusterStream
+func credentialsSecretName(cs *streamingruntime.ClusterStream) string {
+	return fmt.Sprintf("%s-credentials", cs.Name)
+}
+
+// createCredentialsSecret returns the copy, in a namespace, of the secrets of a ClusterStream
+func (r *ClusterStreamReconciler) createCredentialsSecret(cs *streamingruntime.ClusterStream, secrets map[string][]byte, namespace string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credentialsSecretName(cs),
+			Namespace: namespace,
+			Labels:    map[string]string{clusterStreamLabel: cs.Name, credentialsLabel: "true"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: secrets,
+	}
+	if err := ctrl.SetControllerReference(cs, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// createClusterStream is a helper method to create v1alpha1 Dapr Component API objects.
+// The metadata of the authentication mode and of the TLS configuration overrides the
+// properties, and the keys of the secrets copy are added to the metadata as secret references.
+func (r *ClusterStreamReconciler) createClusterStream(_ context.Context, componentType string, clusterStream *streamingruntime.ClusterStream, namespace string, credentials *corev1.Secret) (*daprcomponents.Component, error) {
+	auth, err := clusterStreamAuth(clusterStream)
+	if err != nil {
+		return nil, err
+	}
+	tls, _, err := clusterStreamTLS(clusterStream)
+	if err != nil {
+		return nil, err
+	}
+	properties := make(map[string]string)
+	for k, v := range clusterStream.Spec.Properties {
+		properties[k] = v
+	}
+	for k, v := range auth.metadata {
+		properties[k] = v
+	}
+	for k, v := range tls {
+		properties[k] = v
+	}
 	var componentMetadata []daprcomponents.MetadataItem
-	for k, v := range properties {
-		value, err := json.Marshal(v)
+	for _, k := range sortedKeys(properties) {
+		if credentials != nil && credentials.Data[k] != nil {
+			continue // the secret takes precedence
+		}
+		value, err := json.Marshal(properties[k])
 		if err != nil {
 			return nil, fmt.Errorf("component metadata encoding failed: %s", err)
 		}
@@ -141,11 +683,25 @@ func (r *ClusterStreamReconciler) createClusterStream(ctx context.Context, compo
 			},
 		})
 	}
+	if credentials != nil {
+		var keys []string
+		for k := range credentials.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			componentMetadata = append(componentMetadata, daprcomponents.MetadataItem{
+				Name:         k,
+				SecretKeyRef: daprcomponents.SecretKeyRef{Name: credentials.Name, Key: k},
+			})
+		}
+	}
 
 	component := &daprcomponents.Component{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      clusterStream.Name,
-			Namespace: clusterStream.Namespace,
+			Namespace: namespace,
+			Labels:    map[string]string{clusterStreamLabel: clusterStream.Name},
 		},
 		Spec: daprcomponents.ComponentSpec{
 			Type:     componentType,
@@ -154,11 +710,19 @@ func (r *ClusterStreamReconciler) createClusterStream(ctx context.Context, compo
 		},
 	}
 
-	log.Info("Component created successfully", "Component.Name", component.Name, "Component.Namespace", component.Namespace)
-
 	// establish object ownership
 	if err := ctrl.SetControllerReference(clusterStream, component, r.Scheme); err != nil {
 		return nil, err
 	}
 	return component, nil
 }
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,7 +2,16 @@ package controllers
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+const (
+	schemaMountPath = "/var/run/streaming/schemas"
 )
 
 func getMetadataStringValues(keyName string, properties map[string]string) (result []string) {
@@ -24,3 +33,69 @@ func validateTarget(target string) string {
 	}
 	return target
 }
+
+// codecEnv returns the env variables (read by components using support.CodecFromEnv)
+// used to configure a stream codec. Schemas stored in ConfigMaps are mounted as volumes.
+func codecEnv(prefix, volumeName string, codec *streamingruntime.StreamCodec) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount) {
+	if codec == nil || codec.ContentType == "" {
+		return nil, nil, nil
+	}
+
+	env := []corev1.EnvVar{{Name: prefix + "_CODEC", Value: codec.ContentType}}
+	if codec.MessageType != "" {
+		env = append(env, corev1.EnvVar{Name: prefix + "_CODEC_MESSAGE_TYPE", Value: codec.MessageType})
+	}
+	if codec.Schema == nil {
+		return env, nil, nil
+	}
+	schemaEnv, volumes, mounts := schemaSourceEnv(prefix+"_CODEC_SCHEMA", volumeName, *codec.Schema)
+	return append(env, schemaEnv...), volumes, mounts
+}
+
+// schemaEnv returns the env variables (read by components using support.SchemaValidatorFromEnv)
+// used to validate stream events and to route invalid events.
+func schemaEnv(prefix, volumeName string, schema *streamingruntime.StreamSchema) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount) {
+	if schema == nil {
+		return nil, nil, nil
+	}
+
+	schemaType := schema.Type
+	if schemaType == "" {
+		schemaType = "jsonschema"
+	}
+	env := []corev1.EnvVar{{Name: prefix + "_SCHEMA_TYPE", Value: schemaType}}
+	if schema.Violations != nil {
+		env = append(env,
+			corev1.EnvVar{Name: prefix + "_SCHEMA_VIOLATIONS_TO_STREAM", Value: schema.Violations.Stream},
+			corev1.EnvVar{Name: prefix + "_SCHEMA_VIOLATIONS_TO_COMPONENT", Value: validateTarget(schema.Violations.Component)},
+		)
+	}
+	sourceEnv, volumes, mounts := schemaSourceEnv(prefix+"_SCHEMA", volumeName, schema.SchemaSource)
+	return append(env, sourceEnv...), volumes, mounts
+}
+
+// schemaSourceEnv returns env variable envName, with an inline schema, or envName_FILE with the
+// path of the schema file. Schemas stored in ConfigMaps are mounted as volumes.
+func schemaSourceEnv(envName, volumeName string, source streamingruntime.SchemaSource) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount) {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		mountPath := filepath.Join(schemaMountPath, volumeName)
+		volume := corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: source.ConfigMapKeyRef.LocalObjectReference,
+					Items:                []corev1.KeyToPath{{Key: source.ConfigMapKeyRef.Key, Path: "schema"}},
+				},
+			},
+		}
+		mount := corev1.VolumeMount{Name: volumeName, MountPath: mountPath, ReadOnly: true}
+		env := corev1.EnvVar{Name: envName + "_FILE", Value: filepath.Join(mountPath, "schema")}
+		return []corev1.EnvVar{env}, []corev1.Volume{volume}, []corev1.VolumeMount{mount}
+	case source.File != "":
+		return []corev1.EnvVar{{Name: envName + "_FILE", Value: source.File}}, nil, nil
+	case source.Inline != "":
+		return []corev1.EnvVar{{Name: envName, Value: source.Inline}}, nil, nil
+	}
+	return nil, nil, nil
+}
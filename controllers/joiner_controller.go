@@ -19,6 +19,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -28,13 +29,26 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
 )
 
 const (
 	defaultJoinerImage = "ghcr.io/vladimirvivien/streaming-runtime/components/joiner:latest"
+
+	// joinerFinalizer was added by previous versions to flush the open window of the
+	// joiner replicas before deletion, it is removed from deleted joiners
+	joinerFinalizer = "streaming.vivien.io/window-flush"
+
+	// the joiner replicas flush their open window on shutdown (within 10 seconds): the
+	// Dapr sidecar keeps publishing during its graceful shutdown, and the pods are
+	// given the time of both before being killed
+	joinerSidecarShutdownSeconds        = 15
+	joinerTerminationGracePeriodSeconds = 30
 )
 
 // JoinerReconciler reconciles a Joiner object
@@ -48,6 +62,9 @@ type JoinerReconciler struct {
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=joiners/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 
 func (r *JoinerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
@@ -68,7 +85,44 @@ func (r *JoinerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	// Is object being deleted?
 	if !joiner.ObjectMeta.DeletionTimestamp.IsZero() {
-		return ctrl.Result{}, nil // do nothing, stop reconciliation
+		return r.finalizeJoiner(ctx, joiner)
+	}
+
+	// the streams and their clusterstreams are resolved before rendering the workload,
+	// missing references are reported in the status until they are created
+	streams, missing, err := resolveStreams(ctx, r.Client, joiner.Namespace, joiner.Spec.Stream.From)
+	if err != nil {
+		log.Error(err, "Failed to get streams for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace)
+		return ctrl.Result{}, err
+	}
+	missingClusterStreams, err := resolveClusterStreams(ctx, r.Client, streams)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	missing = append(missing, missingClusterStreams...)
+	if err := updateReferencesResolved(ctx, r.Client, joiner, &joiner.Status.Conditions, missing); err != nil {
+		log.Error(err, "Failed to update Joiner status", "Name", joiner.Name, "Namespace", joiner.Namespace)
+		return ctrl.Result{}, err
+	}
+	if len(missing) > 0 {
+		log.Info("Joiner references not found", "Name", joiner.Name, "Namespace", joiner.Namespace, "Missing", missing)
+		return ctrl.Result{}, nil
+	}
+
+	// the workload of the other kind is left over when partitioning is turned on or off
+	if err := r.deleteUnwantedWorkload(ctx, joiner); err != nil {
+		log.Error(err, "Failed to delete previous workload for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	// partitioned joiners run as a statefulset
+	if joiner.Spec.Partitioning != nil {
+		return r.reconcilePartitions(ctx, joiner)
+	}
+
+	desired, err := r.createJoinerDeployment(ctx, joiner)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	// Retrieve joiner deployment component
@@ -81,11 +135,7 @@ func (r *JoinerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 			"Namespace", joiner.Namespace,
 		)
 
-		deployment, err = r.createJoinerDeployment(ctx, joiner)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-
+		deployment = desired
 		if err := r.Create(ctx, deployment); err != nil {
 			log.Error(err, "Failed to create deployment for Joiner",
 				"Name", joiner.Name,
@@ -106,6 +156,18 @@ func (r *JoinerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, err
 	}
 
+	// the pod template is rendered from the joiner and its streams
+	if deployment.Annotations[templateHashAnnotation] != desired.Annotations[templateHashAnnotation] {
+		deployment.Spec.Template = desired.Spec.Template
+		if err := setTemplateHash(&deployment.ObjectMeta, desired.Spec.Template); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, deployment); err != nil {
+			log.Error(err, "Failed to update deployment for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace)
+			return ctrl.Result{}, err
+		}
+	}
+
 	log.Info("Updated deployment for Joiner",
 		"Namespace", joiner.Namespace,
 		"Name", joiner.Name)
@@ -113,17 +175,69 @@ func (r *JoinerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	return ctrl.Result{}, nil
 }
 
+// finalizeJoiner removes the finalizer of a deleted joiner. The replicas are deleted
+// with the joiner and flush their open window on shutdown.
+func (r *JoinerReconciler) finalizeJoiner(ctx context.Context, joiner *streamingruntime.Joiner) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(joiner, joinerFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	controllerutil.RemoveFinalizer(joiner, joinerFinalizer)
+	if err := r.Update(ctx, joiner); err != nil {
+		log.Error(err, "Failed to remove Joiner finalizer", "Name", joiner.Name, "Namespace", joiner.Namespace)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *JoinerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&streamingruntime.Joiner{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
+		Watches(&source.Kind{Type: &streamingruntime.Stream{}}, handler.EnqueueRequestsFromMapFunc(requestsForStream(r.Client, joinerList))).
+		Watches(&source.Kind{Type: &streamingruntime.ClusterStream{}}, handler.EnqueueRequestsFromMapFunc(requestsForClusterStream(r.Client, joinerList))).
 		Complete(r)
 }
 
 func (r *JoinerReconciler) createJoinerDeployment(ctx context.Context, joiner *streamingruntime.Joiner) (*appsv1.Deployment, error) {
 	var replicas int32 = 1
 
+	template, err := r.createJoinerPodTemplate(ctx, joiner)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joiner.Name,
+			Namespace: joiner.Namespace,
+			Labels:    map[string]string{"app": joiner.Name},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": joiner.Name},
+			},
+			Replicas: &replicas,
+			Template: template,
+		},
+	}
+	if err := setTemplateHash(&deployment.ObjectMeta, template); err != nil {
+		return nil, err
+	}
+
+	// establish ownership
+	if err := ctrl.SetControllerReference(joiner, deployment, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// createJoinerPodTemplate returns the pod template of the joiner replicas
+func (r *JoinerReconciler) createJoinerPodTemplate(ctx context.Context, joiner *streamingruntime.Joiner) (corev1.PodTemplateSpec, error) {
 	// resolve container
 	// if not provided, use default image above.
 	var container corev1.Container
@@ -145,17 +259,18 @@ func (r *JoinerReconciler) createJoinerDeployment(ctx context.Context, joiner *s
 
 	// validate and set env data
 	if len(joiner.Spec.Stream.From) != 2 {
-		return nil, fmt.Errorf("joiner stream.From must have 2 input streams")
+		return corev1.PodTemplateSpec{}, fmt.Errorf("joiner stream.From must have 2 input streams")
 	}
 
-	if joiner.Spec.Stream.To[0].Stream == "" && joiner.Spec.Stream.To[0].Component == "" {
-		return nil, fmt.Errorf("joiner stream.To must have a stream or a component specified")
+	if len(joiner.Spec.Stream.To) == 0 || joiner.Spec.Stream.To[0].Stream == "" && joiner.Spec.Stream.To[0].Component == "" {
+		return corev1.PodTemplateSpec{}, fmt.Errorf("joiner stream.To must have a stream or a component specified")
 	}
 
-	streamInfo, err := r.collateStreamInfo(ctx, joiner)
+	streams, err := r.getStreams(ctx, joiner)
 	if err != nil {
-		return nil, err
+		return corev1.PodTemplateSpec{}, err
 	}
+	streamInfo := collateStreamInfo(streams)
 
 	container.Env = []corev1.EnvVar{
 		{Name: "JOINER_SERVICE_PORT", Value: fmt.Sprintf(":%d", joiner.Spec.ServicePort)},
@@ -165,73 +280,259 @@ func (r *JoinerReconciler) createJoinerDeployment(ctx context.Context, joiner *s
 		{Name: "JOINER_STREAM_TO_COMPONENT", Value: validateTarget(joiner.Spec.Stream.To[0].Component)},
 		{Name: "JOINER_STREAM_WHERE", Value: joiner.Spec.Stream.Where},
 		{Name: "JOINER_STREAM_SELECT", Value: joiner.Spec.Stream.Select},
+		{Name: "JOINER_STREAM_LIB_VERSION", Value: strconv.Itoa(joiner.Spec.Stream.LibVersion)},
+		{Name: "JOINER_STREAM_INDEX_FIELD", Value: joiner.Spec.Stream.IndexField},
 		{Name: "JOINER_WINDOW_SIZE", Value: joiner.Spec.Window},
 	}
+	if joiner.Spec.Emit != nil {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "JOINER_EMIT_MODE", Value: joiner.Spec.Emit.Mode},
+			corev1.EnvVar{Name: "JOINER_EMIT_MAX_BATCH_SIZE", Value: strconv.Itoa(joiner.Spec.Emit.MaxBatchSize)},
+		)
+	}
 
-	deployment := &appsv1.Deployment{
+	// setup codecs for source streams and output target
+	var volumes []corev1.Volume
+	for i, stream := range streams {
+		prefix := fmt.Sprintf("JOINER_STREAM_FROM_%d", i)
+		env, vols, mounts := codecEnv(prefix, fmt.Sprintf("codec-from-%d", i), stream.Spec.Codec)
+		container.Env = append(container.Env, env...)
+		container.VolumeMounts = append(container.VolumeMounts, mounts...)
+		volumes = append(volumes, vols...)
+
+		env, vols, mounts = schemaEnv(prefix, fmt.Sprintf("schema-from-%d", i), stream.Spec.Schema)
+		container.Env = append(container.Env, env...)
+		container.VolumeMounts = append(container.VolumeMounts, mounts...)
+		volumes = append(volumes, vols...)
+	}
+	env, vols, mounts := codecEnv("JOINER_STREAM_TO", "codec-to", joiner.Spec.Stream.To[0].Codec)
+	container.Env = append(container.Env, env...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	volumes = append(volumes, vols...)
+
+	// with partitioning, replicas (sharing the app-id, thus the subscriptions) forward
+	// the events of other partitions to their owner through the partitions service
+	if p := joiner.Spec.Partitioning; p != nil {
+		if len(p.Keys) != len(streams) {
+			return corev1.PodTemplateSpec{}, fmt.Errorf("joiner partitioning.keys must have a key for each input stream")
+		}
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "JOINER_PARTITIONS", Value: strconv.Itoa(int(p.Replicas))},
+			corev1.EnvVar{Name: "JOINER_POD_NAME", ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			}},
+			corev1.EnvVar{Name: "JOINER_PARTITION_PEER_URL", Value: fmt.Sprintf("http://%s-{partition}.%s.%s.svc:%d",
+				joiner.Name, joinerPartitionsService(joiner), joiner.Namespace, joiner.Spec.ServicePort)},
+		)
+		for i, key := range p.Keys {
+			container.Env = append(container.Env, corev1.EnvVar{Name: fmt.Sprintf("JOINER_STREAM_FROM_%d_PARTITION_KEY", i), Value: key})
+		}
+	}
+
+	terminationGracePeriod := int64(joinerTerminationGracePeriodSeconds)
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": joiner.Name},
+			Annotations: map[string]string{
+				"dapr.io/enabled":                   "true",
+				"dapr.io/app-id":                    joiner.Name,
+				"dapr.io/app-port":                  fmt.Sprintf("%d", joiner.Spec.ServicePort),
+				"dapr.io/graceful-shutdown-seconds": strconv.Itoa(joinerSidecarShutdownSeconds),
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers:                    []corev1.Container{container},
+			Volumes:                       volumes,
+			TerminationGracePeriodSeconds: &terminationGracePeriod,
+		},
+	}, nil
+}
+
+// joinerPartitionsService returns the name of the headless service addressing the joiner replicas
+func joinerPartitionsService(joiner *streamingruntime.Joiner) string {
+	return fmt.Sprintf("%s-partitions", joiner.Name)
+}
+
+// createJoinerPartitionsService returns the headless service giving each replica of a
+// partitioned joiner a stable address
+func (r *JoinerReconciler) createJoinerPartitionsService(joiner *streamingruntime.Joiner) (*corev1.Service, error) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joinerPartitionsService(joiner),
+			Namespace: joiner.Namespace,
+			Labels:    map[string]string{"app": joiner.Name},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{"app": joiner.Name},
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{{
+				Name: "app-port",
+				Port: joiner.Spec.ServicePort,
+			}},
+		},
+	}
+
+	// establish ownership
+	if err := ctrl.SetControllerReference(joiner, service, r.Scheme); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// createJoinerStatefulSet returns the statefulset running the replicas of a partitioned
+// joiner: the ordinal of a replica is the partition it owns
+func (r *JoinerReconciler) createJoinerStatefulSet(ctx context.Context, joiner *streamingruntime.Joiner) (*appsv1.StatefulSet, error) {
+	replicas := joiner.Spec.Partitioning.Replicas
+	template, err := r.createJoinerPodTemplate(ctx, joiner)
+	if err != nil {
+		return nil, err
+	}
+
+	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      joiner.Name,
 			Namespace: joiner.Namespace,
 			Labels:    map[string]string{"app": joiner.Name},
 		},
-		Spec: appsv1.DeploymentSpec{
+		Spec: appsv1.StatefulSetSpec{
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{"app": joiner.Name},
 			},
-			Replicas: &replicas,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": joiner.Name},
-					Annotations: map[string]string{
-						"dapr.io/enabled":  "true",
-						"dapr.io/app-id":   joiner.Name,
-						"dapr.io/app-port": fmt.Sprintf("%d", joiner.Spec.ServicePort),
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{container},
-				},
-			},
+			Replicas:            &replicas,
+			ServiceName:         joinerPartitionsService(joiner),
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Template:            template,
 		},
 	}
+	if err := setTemplateHash(&statefulSet.ObjectMeta, template); err != nil {
+		return nil, err
+	}
 
 	// establish ownership
-	if err := ctrl.SetControllerReference(joiner, deployment, r.Scheme); err != nil {
+	if err := ctrl.SetControllerReference(joiner, statefulSet, r.Scheme); err != nil {
 		return nil, err
 	}
+	return statefulSet, nil
+}
 
-	return deployment, nil
+// reconcilePartitions creates the headless service and the statefulset of a
+// partitioned joiner. When the number of replicas (or the pod template) changes, the
+// statefulset is updated, which repartitions the join keys.
+func (r *JoinerReconciler) reconcilePartitions(ctx context.Context, joiner *streamingruntime.Joiner) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	service := new(corev1.Service)
+	err := r.Get(ctx, types.NamespacedName{Name: joinerPartitionsService(joiner), Namespace: joiner.Namespace}, service)
+	if err != nil && errors.IsNotFound(err) {
+		if service, err = r.createJoinerPartitionsService(joiner); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, service); err != nil {
+			log.Error(err, "Failed to create partitions service for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace)
+			return ctrl.Result{}, err
+		}
+		log.Info("Created partitions service for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace, "Service", service.Name)
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	desired, err := r.createJoinerStatefulSet(ctx, joiner)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	statefulSet := new(appsv1.StatefulSet)
+	err = r.Get(ctx, types.NamespacedName{Name: joiner.Name, Namespace: joiner.Namespace}, statefulSet)
+	if err != nil && errors.IsNotFound(err) {
+		if err := r.Create(ctx, desired); err != nil {
+			log.Error(err, "Failed to create statefulset for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace)
+			return ctrl.Result{}, err
+		}
+		log.Info("Created statefulset for Joiner successfully",
+			"Name", joiner.Name,
+			"Namespace", joiner.Namespace,
+			"Replicas", joiner.Spec.Partitioning.Replicas,
+		)
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Joiner statefulset", "Name", joiner.Name, "Namespace", joiner.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas != *desired.Spec.Replicas ||
+		statefulSet.Annotations[templateHashAnnotation] != desired.Annotations[templateHashAnnotation] {
+		statefulSet.Spec.Replicas = desired.Spec.Replicas
+		statefulSet.Spec.Template = desired.Spec.Template
+		if err := setTemplateHash(&statefulSet.ObjectMeta, desired.Spec.Template); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, statefulSet); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Updated statefulset for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace, "Replicas", *desired.Spec.Replicas)
+	}
+	return ctrl.Result{}, nil
 }
 
-func (r *JoinerReconciler) updateJoinerDeployment(joiner *streamingruntime.Joiner, dep *appsv1.Deployment) {
-	var container corev1.Container
-	if joiner.Spec.Container == nil {
-		container = corev1.Container{
-			Name:            joiner.Name,
-			Image:           defaultJoinerImage,
-			ImagePullPolicy: corev1.PullAlways,
+// deleteUnwantedWorkload deletes the workload the joiner no longer runs as: the
+// deployment of a partitioned joiner, or the statefulset and the partitions service
+// of a joiner without partitioning
+func (r *JoinerReconciler) deleteUnwantedWorkload(ctx context.Context, joiner *streamingruntime.Joiner) error {
+	log := log.FromContext(ctx)
+	meta := metav1.ObjectMeta{Name: joiner.Name, Namespace: joiner.Namespace}
+	unwanted := []client.Object{
+		&appsv1.StatefulSet{ObjectMeta: meta},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: joinerPartitionsService(joiner), Namespace: joiner.Namespace}},
+	}
+	if joiner.Spec.Partitioning != nil {
+		unwanted = []client.Object{&appsv1.Deployment{ObjectMeta: meta}}
+	}
+	for _, obj := range unwanted {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
 		}
-	} else {
-		container = *joiner.Spec.Container
+		if !metav1.IsControlledBy(obj, joiner) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info("Deleted previous workload for Joiner", "Name", joiner.Name, "Namespace", joiner.Namespace, "Object", obj.GetName())
 	}
-	dep.Spec.Template.Spec.Containers = []corev1.Container{container}
+	return nil
 }
 
-// collateStreamInfo returns Stream info as a []string
-// where each element is ClusterStream|Topic|Route
-func (r *JoinerReconciler) collateStreamInfo(ctx context.Context, joiner *streamingruntime.Joiner) ([]string, error) {
-	var result []string
+func joinerList() client.ObjectList {
+	return new(streamingruntime.JoinerList)
+}
+
+// getStreams returns the Stream objects referenced in joiner.Spec.Stream.From
+func (r *JoinerReconciler) getStreams(ctx context.Context, joiner *streamingruntime.Joiner) ([]*streamingruntime.Stream, error) {
+	var result []*streamingruntime.Stream
 	for _, streamName := range joiner.Spec.Stream.From {
 		stream := new(streamingruntime.Stream)
 		err := r.Get(ctx, types.NamespacedName{Namespace: joiner.Namespace, Name: streamName}, stream)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, stream)
+	}
+	return result, nil
+}
+
+// collateStreamInfo returns Stream info as a []string
+// where each element is ClusterStream|Topic|Route
+func collateStreamInfo(streams []*streamingruntime.Stream) []string {
+	var result []string
+	for _, stream := range streams {
 		route := stream.Spec.Route
 		if route == "" {
 			route = stream.Spec.Topic
 		}
 		result = append(result, fmt.Sprintf("%s|%s|%s", stream.Spec.ClusterStream, stream.Spec.Topic, route))
 	}
-	return result, nil
+	return result
 }
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+func TestDeleteUnwantedWorkload(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := streamingruntime.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	partitioning := &streamingruntime.JoinerPartitioning{Replicas: 2, Keys: []string{"a.id", "b.id"}}
+
+	tests := []struct {
+		name         string
+		partitioning *streamingruntime.JoinerPartitioning
+		owned        bool
+		wantDeleted  []string
+		wantKept     []string
+	}{
+		{name: "partitioning turned on", partitioning: partitioning, owned: true, wantDeleted: []string{"Deployment"}, wantKept: []string{"StatefulSet", "Service"}},
+		{name: "partitioning turned off", owned: true, wantDeleted: []string{"StatefulSet", "Service"}, wantKept: []string{"Deployment"}},
+		{name: "not owned", owned: false, wantKept: []string{"Deployment", "StatefulSet", "Service"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			joiner := &streamingruntime.Joiner{
+				ObjectMeta: metav1.ObjectMeta{Name: "join", Namespace: "ns", UID: "uid"},
+				Spec:       streamingruntime.JoinerSpec{Partitioning: test.partitioning},
+			}
+			objects := map[string]client.Object{
+				"Deployment":  &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "join", Namespace: "ns"}},
+				"StatefulSet": &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "join", Namespace: "ns"}},
+				"Service":     &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "join-partitions", Namespace: "ns"}},
+			}
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for _, obj := range objects {
+				if test.owned {
+					if err := ctrl.SetControllerReference(joiner, obj, scheme); err != nil {
+						t.Fatal(err)
+					}
+				}
+				builder = builder.WithObjects(obj)
+			}
+			r := &JoinerReconciler{Client: builder.Build(), Scheme: scheme}
+
+			if err := r.deleteUnwantedWorkload(context.TODO(), joiner); err != nil {
+				t.Fatal(err)
+			}
+			for _, kind := range test.wantDeleted {
+				obj := objects[kind]
+				if err := r.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); !errors.IsNotFound(err) {
+					t.Errorf("%s: got error %v, want not found", kind, err)
+				}
+			}
+			for _, kind := range test.wantKept {
+				obj := objects[kind]
+				if err := r.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); err != nil {
+					t.Errorf("%s: %s", kind, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	daprsubscriptions "github.com/dapr/dapr/pkg/apis/subscriptions/v2alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+const (
+	// pipelineLabel labels the nodes created for a pipeline with its name
+	pipelineLabel = "streaming.vivien.io/pipeline"
+
+	// pipelineRequeueInterval is the interval at which a pipeline with nodes not
+	// ready is reconciled, the readiness of the node workloads is not watched
+	pipelineRequeueInterval = 10 * time.Second
+)
+
+// pipelineNode is a child resource created for a node of a pipeline
+type pipelineNode struct {
+	kind string
+	obj  client.Object
+}
+
+// pipelineNodeLists returns the list types of the pipeline node kinds
+func pipelineNodeLists() map[string]client.ObjectList {
+	return map[string]client.ObjectList{
+		"Stream":    new(streamingruntime.StreamList),
+		"Channel":   new(streamingruntime.ChannelList),
+		"Joiner":    new(streamingruntime.JoinerList),
+		"Processor": new(streamingruntime.ProcessorList),
+	}
+}
+
+// PipelineReconciler reconciles a Pipeline object
+type PipelineReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=pipelines,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=pipelines/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=pipelines/finalizers,verbs=update
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=channels,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=joiners,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=processors,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=dapr.io,resources=subscriptions,verbs=get;list;watch
+
+func (r *PipelineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	pipeline := new(streamingruntime.Pipeline)
+	err := r.Get(ctx, req.NamespacedName, pipeline)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Pipeline not found, ignoring", "Name", req.Name, "Namespace", req.Namespace)
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to fetch Pipeline", "Name", req.Name, "Namespace", req.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	// is item being deleted?
+	if !pipeline.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil // do nothing, stop reconciliation
+	}
+	pipeline.Status.ObservedGeneration = pipeline.Generation
+
+	// an invalid graph is reported in the status, it is reconciled again when
+	// the pipeline is updated
+	if err := validatePipeline(&pipeline.Spec); err != nil {
+		log.Info("Invalid Pipeline", "Name", pipeline.Name, "Namespace", pipeline.Namespace, "Error", err.Error())
+		meta.SetStatusCondition(&pipeline.Status.Conditions, metav1.Condition{
+			Type:    streamingruntime.PipelineConditionValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidGraph",
+			Message: err.Error(),
+		})
+		meta.SetStatusCondition(&pipeline.Status.Conditions, metav1.Condition{
+			Type:    streamingruntime.PipelineConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidGraph",
+			Message: "pipeline graph is not valid",
+		})
+		return ctrl.Result{}, r.updatePipelineStatus(ctx, pipeline)
+	}
+	meta.SetStatusCondition(&pipeline.Status.Conditions, metav1.Condition{
+		Type:   streamingruntime.PipelineConditionValid,
+		Status: metav1.ConditionTrue,
+		Reason: "ValidGraph",
+	})
+
+	nodes, err := r.createPipelineNodes(pipeline)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// a node failing to reconcile is reported in its status, the other nodes are
+	// still reconciled
+	var statuses []streamingruntime.PipelineNodeStatus
+	var notReady []string
+	for _, node := range nodes {
+		status := streamingruntime.PipelineNodeStatus{Kind: node.kind, Name: node.obj.GetName()}
+		if err := r.reconcileNode(ctx, pipeline, node); err != nil {
+			log.Error(err, "Failed to reconcile Pipeline node", "Name", pipeline.Name, "Namespace", pipeline.Namespace, "Kind", node.kind, "Node", status.Name)
+			status.Message = err.Error()
+		} else if status.Ready, status.Message, err = r.nodeReady(ctx, node); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !status.Ready {
+			notReady = append(notReady, fmt.Sprintf("%s %s", strings.ToLower(node.kind), status.Name))
+		}
+		statuses = append(statuses, status)
+	}
+	pipeline.Status.Nodes = statuses
+
+	if err := r.deleteRemovedNodes(ctx, pipeline, nodes); err != nil {
+		log.Error(err, "Failed to delete removed Pipeline nodes", "Name", pipeline.Name, "Namespace", pipeline.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	ready := metav1.Condition{
+		Type:   streamingruntime.PipelineConditionReady,
+		Status: metav1.ConditionTrue,
+		Reason: "NodesReady",
+	}
+	if len(notReady) > 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "NodesNotReady"
+		ready.Message = fmt.Sprintf("nodes not ready: %s", strings.Join(notReady, ", "))
+	}
+	meta.SetStatusCondition(&pipeline.Status.Conditions, ready)
+	if err := r.updatePipelineStatus(ctx, pipeline); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Reconciled Pipeline", "Name", pipeline.Name, "Namespace", pipeline.Namespace, "Nodes", len(nodes), "NotReady", len(notReady))
+	if len(notReady) > 0 {
+		return ctrl.Result{RequeueAfter: pipelineRequeueInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PipelineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&streamingruntime.Pipeline{}).
+		Owns(&streamingruntime.Stream{}).
+		Owns(&streamingruntime.Channel{}).
+		Owns(&streamingruntime.Joiner{}).
+		Owns(&streamingruntime.Processor{}).
+		Complete(r)
+}
+
+func (r *PipelineReconciler) updatePipelineStatus(ctx context.Context, pipeline *streamingruntime.Pipeline) error {
+	if err := r.Status().Update(ctx, pipeline); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update Pipeline status", "Name", pipeline.Name, "Namespace", pipeline.Namespace)
+		return err
+	}
+	return nil
+}
+
+// createPipelineNodes returns the child resources declared by the pipeline nodes,
+// named after the nodes and owned by the pipeline
+func (r *PipelineReconciler) createPipelineNodes(pipeline *streamingruntime.Pipeline) ([]pipelineNode, error) {
+	objectMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pipeline.Namespace,
+			Labels:    map[string]string{pipelineLabel: pipeline.Name},
+		}
+	}
+
+	var nodes []pipelineNode
+	for _, node := range pipeline.Spec.Streams {
+		nodes = append(nodes, pipelineNode{kind: "Stream", obj: &streamingruntime.Stream{ObjectMeta: objectMeta(node.Name), Spec: node.Spec}})
+	}
+	for _, node := range pipeline.Spec.Channels {
+		nodes = append(nodes, pipelineNode{kind: "Channel", obj: &streamingruntime.Channel{ObjectMeta: objectMeta(node.Name), Spec: node.Spec}})
+	}
+	for _, node := range pipeline.Spec.Joiners {
+		nodes = append(nodes, pipelineNode{kind: "Joiner", obj: &streamingruntime.Joiner{ObjectMeta: objectMeta(node.Name), Spec: node.Spec}})
+	}
+	for _, node := range pipeline.Spec.Processors {
+		nodes = append(nodes, pipelineNode{kind: "Processor", obj: &streamingruntime.Processor{ObjectMeta: objectMeta(node.Name), Spec: node.Spec}})
+	}
+
+	for _, node := range nodes {
+		if err := ctrl.SetControllerReference(pipeline, node.obj, r.Scheme); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// reconcileNode creates the child resource of a node or updates its spec. A resource
+// of the same name not owned by the pipeline is left untouched.
+func (r *PipelineReconciler) reconcileNode(ctx context.Context, pipeline *streamingruntime.Pipeline, node pipelineNode) error {
+	log := log.FromContext(ctx)
+	key := types.NamespacedName{Namespace: node.obj.GetNamespace(), Name: node.obj.GetName()}
+
+	existing := node.obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, key, existing)
+	switch {
+	case errors.IsNotFound(err):
+		if err := r.Create(ctx, node.obj); err != nil {
+			return err
+		}
+		log.Info("Created Pipeline node", "Pipeline", pipeline.Name, "Namespace", key.Namespace, "Kind", node.kind, "Name", key.Name)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, pipeline) {
+		return fmt.Errorf("%s %s already exists and is not owned by the pipeline", strings.ToLower(node.kind), key.Name)
+	}
+	if !updateNodeSpec(existing, node.obj) {
+		return nil
+	}
+	if err := r.Update(ctx, existing); err != nil {
+		return err
+	}
+	log.Info("Updated Pipeline node", "Pipeline", pipeline.Name, "Namespace", key.Namespace, "Kind", node.kind, "Name", key.Name)
+	return nil
+}
+
+// updateNodeSpec sets the spec of the existing node resource to the desired spec,
+// it returns false if the spec is unchanged
+func updateNodeSpec(existing, desired client.Object) bool {
+	switch existing := existing.(type) {
+	case *streamingruntime.Stream:
+		spec := desired.(*streamingruntime.Stream).Spec
+		if equality.Semantic.DeepEqual(existing.Spec, spec) {
+			return false
+		}
+		existing.Spec = spec
+	case *streamingruntime.Channel:
+		spec := desired.(*streamingruntime.Channel).Spec
+		if equality.Semantic.DeepEqual(existing.Spec, spec) {
+			return false
+		}
+		existing.Spec = spec
+	case *streamingruntime.Joiner:
+		spec := desired.(*streamingruntime.Joiner).Spec
+		if equality.Semantic.DeepEqual(existing.Spec, spec) {
+			return false
+		}
+		existing.Spec = spec
+	case *streamingruntime.Processor:
+		spec := desired.(*streamingruntime.Processor).Spec
+		if equality.Semantic.DeepEqual(existing.Spec, spec) {
+			return false
+		}
+		existing.Spec = spec
+	}
+	return true
+}
+
+// nodeReady returns whether a node is ready, and why if it is not: a Stream is
+// ready when its Dapr subscription exists, and a component when the replicas of
+// its workload are ready.
+func (r *PipelineReconciler) nodeReady(ctx context.Context, node pipelineNode) (bool, string, error) {
+	key := types.NamespacedName{Namespace: node.obj.GetNamespace(), Name: node.obj.GetName()}
+	var workload client.Object = new(appsv1.Deployment)
+	switch obj := node.obj.(type) {
+	case *streamingruntime.Stream:
+		err := r.Get(ctx, key, new(daprsubscriptions.Subscription))
+		if errors.IsNotFound(err) {
+			return false, "subscription not created", nil
+		}
+		return err == nil, "", err
+	case *streamingruntime.Joiner:
+		if obj.Spec.Partitioning != nil {
+			workload = new(appsv1.StatefulSet)
+		}
+	}
+
+	err := r.Get(ctx, key, workload)
+	if errors.IsNotFound(err) {
+		return false, "workload not created", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	var replicas *int32
+	var readyReplicas int32
+	switch workload := workload.(type) {
+	case *appsv1.Deployment:
+		replicas, readyReplicas = workload.Spec.Replicas, workload.Status.ReadyReplicas
+	case *appsv1.StatefulSet:
+		replicas, readyReplicas = workload.Spec.Replicas, workload.Status.ReadyReplicas
+	}
+	want := int32(1)
+	if replicas != nil {
+		want = *replicas
+	}
+	if readyReplicas < want {
+		return false, fmt.Sprintf("%d/%d replicas ready", readyReplicas, want), nil
+	}
+	return true, "", nil
+}
+
+// deleteRemovedNodes deletes the resources owned by the pipeline whose nodes were
+// removed from the pipeline
+func (r *PipelineReconciler) deleteRemovedNodes(ctx context.Context, pipeline *streamingruntime.Pipeline, nodes []pipelineNode) error {
+	log := log.FromContext(ctx)
+	desired := make(map[string]bool)
+	for _, node := range nodes {
+		desired[node.kind+"/"+node.obj.GetName()] = true
+	}
+
+	for kind, list := range pipelineNodeLists() {
+		if err := r.List(ctx, list, client.InNamespace(pipeline.Namespace), client.MatchingLabels{pipelineLabel: pipeline.Name}); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			obj := item.(client.Object)
+			if desired[kind+"/"+obj.GetName()] || !metav1.IsControlledBy(obj, pipeline) {
+				continue
+			}
+			if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			log.Info("Deleted Pipeline node", "Pipeline", pipeline.Name, "Namespace", pipeline.Namespace, "Kind", kind, "Name", obj.GetName())
+		}
+	}
+	return nil
+}
+
+// validatePipeline validates the graph of a pipeline: node names are unique, the
+// names referenced by the nodes are nodes of the pipeline, and the events sent by
+// a component do not flow back to its inputs.
+func validatePipeline(spec *streamingruntime.PipelineSpec) error {
+	kinds := make(map[string]string) // node name -> kind
+	declare := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("%s name required", strings.ToLower(kind))
+		}
+		if other, ok := kinds[name]; ok {
+			return fmt.Errorf("%s %s: name already used by %s %s", strings.ToLower(kind), name, strings.ToLower(other), name)
+		}
+		kinds[name] = kind
+		return nil
+	}
+	for _, node := range spec.Streams {
+		if err := declare("Stream", node.Name); err != nil {
+			return err
+		}
+	}
+	for _, node := range spec.Channels {
+		if err := declare("Channel", node.Name); err != nil {
+			return err
+		}
+	}
+	for _, node := range spec.Joiners {
+		if err := declare("Joiner", node.Name); err != nil {
+			return err
+		}
+	}
+	for _, node := range spec.Processors {
+		if err := declare("Processor", node.Name); err != nil {
+			return err
+		}
+	}
+
+	// edges follow the events: from a stream to its consumers, and from a
+	// component to the streams of the pubsub/topic it sends to
+	graph := make(map[string][]string)
+	topics := make(map[string][]string) // pubsub/topic -> stream names
+	for _, node := range spec.Streams {
+		topic := fmt.Sprintf("%s/%s", node.Spec.ClusterStream, node.Spec.Topic)
+		topics[topic] = append(topics[topic], node.Name)
+	}
+
+	ref := func(kind, name, refKind, ref string) error {
+		if kinds[ref] != refKind {
+			return fmt.Errorf("%s %s: %s %s is not a node of the pipeline", strings.ToLower(kind), name, strings.ToLower(refKind), ref)
+		}
+		return nil
+	}
+	inputs := func(kind, name string, from []string) error {
+		for _, stream := range from {
+			if err := ref(kind, name, "Stream", stream); err != nil {
+				return err
+			}
+			graph[stream] = append(graph[stream], name)
+		}
+		return nil
+	}
+	output := func(kind, name, stream string) error {
+		parts := strings.SplitN(stream, "/", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s %s: stream %s must be specified as pubsub/topic", strings.ToLower(kind), name, stream)
+		}
+		graph[name] = append(graph[name], topics[stream]...)
+		return nil
+	}
+	outputs := func(kind, name string, targets []streamingruntime.OutputTarget) error {
+		for _, target := range targets {
+			if target.Stream == "" {
+				continue
+			}
+			if err := output(kind, name, target.Stream); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, node := range spec.Streams {
+		if node.Spec.ClusterStream == "" {
+			return fmt.Errorf("stream %s: clusterStream required", node.Name)
+		}
+		for _, recipient := range node.Spec.Recipients {
+			switch kinds[recipient] {
+			case "Channel", "Joiner", "Processor":
+				graph[node.Name] = append(graph[node.Name], recipient)
+			default:
+				return fmt.Errorf("stream %s: recipient %s is not a component of the pipeline", node.Name, recipient)
+			}
+		}
+		if schema := node.Spec.Schema; schema != nil && schema.Violations != nil {
+			if err := outputs("Stream", node.Name, []streamingruntime.OutputTarget{*schema.Violations}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, node := range spec.Channels {
+		if err := inputs("Channel", node.Name, node.Spec.Stream.From); err != nil {
+			return err
+		}
+		if err := outputs("Channel", node.Name, node.Spec.Stream.To); err != nil {
+			return err
+		}
+		for _, route := range node.Spec.Routes {
+			if err := outputs("Channel", node.Name, route.To); err != nil {
+				return err
+			}
+		}
+	}
+	for _, node := range spec.Joiners {
+		if node.Spec.Stream == nil {
+			return fmt.Errorf("joiner %s: stream required", node.Name)
+		}
+		if err := inputs("Joiner", node.Name, node.Spec.Stream.From); err != nil {
+			return err
+		}
+		if err := outputs("Joiner", node.Name, node.Spec.Stream.To); err != nil {
+			return err
+		}
+	}
+	for _, node := range spec.Processors {
+		if err := inputs("Processor", node.Name, node.Spec.From); err != nil {
+			return err
+		}
+		stream, _, err := processorTarget(node.Spec.Target)
+		if err != nil {
+			return err
+		}
+		if stream != "" {
+			if err := output("Processor", node.Name, stream); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cycle := findCycle(graph); cycle != nil {
+		return fmt.Errorf("pipeline graph has a cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findCycle returns a cycle of the graph, as the path of its node names from and
+// back to the same node, or nil if the graph is acyclic
+func findCycle(graph map[string][]string) []string {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		switch state[node] {
+		case visiting:
+			for i := range path {
+				if path[i] == node {
+					return append(append([]string{}, path[i:]...), node)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[node] = visiting
+		path = append(path, node)
+		for _, next := range graph[node] {
+			if cycle := visit(next); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+
+	// nodes are visited in order for the reported cycle to be stable
+	var nodes []string
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if cycle := visit(node); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
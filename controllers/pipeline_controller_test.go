@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+func TestFindCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		want  []string
+	}{
+		{name: "empty graph"},
+		{name: "chain", graph: map[string][]string{"a": {"b"}, "b": {"c"}}},
+		{name: "diamond", graph: map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}}},
+		{name: "self-loop", graph: map[string][]string{"a": {"a"}}, want: []string{"a", "a"}},
+		{name: "two-node cycle", graph: map[string][]string{"a": {"b"}, "b": {"a"}}, want: []string{"a", "b", "a"}},
+		{
+			name:  "cycle after a prefix",
+			graph: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d"}, "d": {"b"}},
+			want:  []string{"b", "c", "d", "b"},
+		},
+		{
+			name:  "cycle in a disconnected component",
+			graph: map[string][]string{"a": {"b"}, "x": {"y"}, "y": {"z"}, "z": {"x"}},
+			want:  []string{"x", "y", "z", "x"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findCycle(test.graph); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got cycle %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	stream := func(name, topic string, recipients ...string) streamingruntime.PipelineStream {
+		return streamingruntime.PipelineStream{
+			Name: name,
+			Spec: streamingruntime.StreamSpec{ClusterStream: "pubsub", Topic: topic, Recipients: recipients},
+		}
+	}
+	channel := func(name string, from []string, to ...string) streamingruntime.PipelineChannel {
+		var targets []streamingruntime.OutputTarget
+		for _, stream := range to {
+			targets = append(targets, streamingruntime.OutputTarget{Stream: stream})
+		}
+		return streamingruntime.PipelineChannel{
+			Name: name,
+			Spec: streamingruntime.ChannelSpec{Stream: streamingruntime.StreamSetup{From: from, To: targets}},
+		}
+	}
+	processor := func(name string, from []string, target string) streamingruntime.PipelineProcessor {
+		return streamingruntime.PipelineProcessor{
+			Name: name,
+			Spec: streamingruntime.ProcessorSpec{From: from, Target: target},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		spec    streamingruntime.PipelineSpec
+		wantErr string
+	}{
+		{
+			name: "valid DAG",
+			spec: streamingruntime.PipelineSpec{
+				Streams:    []streamingruntime.PipelineStream{stream("orders", "orders"), stream("big", "big")},
+				Channels:   []streamingruntime.PipelineChannel{channel("filter", []string{"orders"}, "pubsub/big")},
+				Processors: []streamingruntime.PipelineProcessor{processor("enrich", []string{"big"}, "component:crm/orders")},
+			},
+		},
+		{
+			name: "valid fan-out and fan-in",
+			spec: streamingruntime.PipelineSpec{
+				Streams: []streamingruntime.PipelineStream{stream("in", "in"), stream("out", "out")},
+				Channels: []streamingruntime.PipelineChannel{
+					channel("a", []string{"in"}, "pubsub/out"),
+					channel("b", []string{"in"}, "pubsub/out"),
+				},
+			},
+		},
+		{
+			name: "duplicate node name",
+			spec: streamingruntime.PipelineSpec{
+				Streams:  []streamingruntime.PipelineStream{stream("orders", "orders")},
+				Channels: []streamingruntime.PipelineChannel{channel("orders", []string{"orders"})},
+			},
+			wantErr: "channel orders: name already used by stream orders",
+		},
+		{
+			name: "dangling input stream",
+			spec: streamingruntime.PipelineSpec{
+				Channels: []streamingruntime.PipelineChannel{channel("filter", []string{"missing"})},
+			},
+			wantErr: "channel filter: stream missing is not a node of the pipeline",
+		},
+		{
+			name: "dangling recipient",
+			spec: streamingruntime.PipelineSpec{
+				Streams: []streamingruntime.PipelineStream{stream("orders", "orders", "missing")},
+			},
+			wantErr: "stream orders: recipient missing is not a component of the pipeline",
+		},
+		{
+			name: "input is not a stream",
+			spec: streamingruntime.PipelineSpec{
+				Streams:  []streamingruntime.PipelineStream{stream("orders", "orders")},
+				Channels: []streamingruntime.PipelineChannel{channel("a", []string{"orders"}), channel("b", []string{"a"})},
+			},
+			wantErr: "channel b: stream a is not a node of the pipeline",
+		},
+		{
+			name: "output not specified as pubsub/topic",
+			spec: streamingruntime.PipelineSpec{
+				Streams:  []streamingruntime.PipelineStream{stream("orders", "orders")},
+				Channels: []streamingruntime.PipelineChannel{channel("filter", []string{"orders"}, "orders")},
+			},
+			wantErr: "channel filter: stream orders must be specified as pubsub/topic",
+		},
+		{
+			name: "self-loop",
+			spec: streamingruntime.PipelineSpec{
+				Streams:  []streamingruntime.PipelineStream{stream("orders", "orders")},
+				Channels: []streamingruntime.PipelineChannel{channel("filter", []string{"orders"}, "pubsub/orders")},
+			},
+			wantErr: "pipeline graph has a cycle: filter -> orders -> filter",
+		},
+		{
+			name: "multi-node cycle",
+			spec: streamingruntime.PipelineSpec{
+				Streams: []streamingruntime.PipelineStream{stream("a", "a"), stream("b", "b")},
+				Channels: []streamingruntime.PipelineChannel{
+					channel("ab", []string{"a"}, "pubsub/b"),
+					channel("ba", []string{"b"}, "pubsub/a"),
+				},
+			},
+			wantErr: "pipeline graph has a cycle: a -> ab -> b -> ba -> a",
+		},
+		{
+			name: "cycle through a processor",
+			spec: streamingruntime.PipelineSpec{
+				Streams:    []streamingruntime.PipelineStream{stream("orders", "orders")},
+				Processors: []streamingruntime.PipelineProcessor{processor("enrich", []string{"orders"}, "stream:pubsub/orders")},
+			},
+			wantErr: "pipeline graph has a cycle: enrich -> orders -> enrich",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePipeline(&test.spec)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+)
+
+const (
+	amqpFrameMethod = 1
+	amqpFrameEnd    = 0xCE
+	// class and method ids of connection.start
+	amqpConnectionClass = 10
+	amqpStartMethod     = 10
+)
+
+var amqpHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+// AMQP opens an AMQP 0-9-1 connection to the server of the host property (an amqp or
+// amqps URL) and returns the product and version of its connection.start method. The
+// connection is closed before authenticating.
+func AMQP(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (string, error) {
+	host, err := url.Parse(properties["host"])
+	if err != nil {
+		return "", fmt.Errorf("host: %w", err)
+	}
+	port := host.Port()
+	switch host.Scheme {
+	case "amqp":
+		if port == "" {
+			port = "5672"
+		}
+	case "amqps":
+		if port == "" {
+			port = "5671"
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+	default:
+		return "", fmt.Errorf("host: amqp or amqps URL required")
+	}
+
+	conn, err := Dial(ctx, net.JoinHostPort(host.Hostname(), port), tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(amqpHeader); err != nil {
+		return "", err
+	}
+
+	var frame struct {
+		Type    uint8
+		Channel uint16
+		Size    uint32
+	}
+	if err := binary.Read(conn, binary.BigEndian, &frame); err != nil {
+		return "", fmt.Errorf("connection.start: %w", err)
+	}
+	if frame.Type != amqpFrameMethod {
+		// a server not supporting the protocol version replies with its header
+		return "", fmt.Errorf("connection.start: unexpected frame type %d", frame.Type)
+	}
+	payload := make([]byte, frame.Size+1) // with the frame end
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return "", fmt.Errorf("connection.start: %w", err)
+	}
+	if payload[frame.Size] != amqpFrameEnd {
+		return "", fmt.Errorf("connection.start: invalid frame end")
+	}
+
+	reader := bytes.NewReader(payload[:frame.Size])
+	var start struct {
+		Class, Method uint16
+		Major, Minor  uint8
+	}
+	if err := binary.Read(reader, binary.BigEndian, &start); err != nil {
+		return "", fmt.Errorf("connection.start: %w", err)
+	}
+	if start.Class != amqpConnectionClass || start.Method != amqpStartMethod {
+		return "", fmt.Errorf("connection.start: unexpected method %d.%d", start.Class, start.Method)
+	}
+	server := amqpStrings(reader)
+	return fmt.Sprintf("amqp %d-%d %s %s", start.Major, start.Minor, server["product"], server["version"]), nil
+}
+
+// amqpStrings returns the string values of an AMQP field table, the decoding stops at
+// the first value of an unsupported type
+func amqpStrings(r *bytes.Reader) map[string]string {
+	values := make(map[string]string)
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return values
+	}
+	table := io.LimitReader(r, int64(size))
+	for {
+		var nameSize uint8
+		if err := binary.Read(table, binary.BigEndian, &nameSize); err != nil {
+			return values
+		}
+		name := make([]byte, nameSize)
+		var kind uint8
+		if _, err := io.ReadFull(table, name); err != nil {
+			return values
+		}
+		if err := binary.Read(table, binary.BigEndian, &kind); err != nil {
+			return values
+		}
+		var length uint32
+		switch kind {
+		case 'S', 'F': // long string, nested table
+			if err := binary.Read(table, binary.BigEndian, &length); err != nil {
+				return values
+			}
+		case 't': // boolean
+			length = 1
+		default:
+			return values
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(table, value); err != nil {
+			return values
+		}
+		if kind == 'S' {
+			values[string(name)] = string(value)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/oauth"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const kafkaClientID = "streaming-runtime-probe"
+
+// Kafka sends a metadata request to the brokers of the brokers property, authenticated
+// as the pubsub component, and returns the number of brokers of the cluster
+func Kafka(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (string, error) {
+	client, err := KafkaClient(ctx, properties, tlsConfig, kgo.ClientID(kafkaClientID))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	metadata, err := kadm.NewClient(client).BrokerMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("brokers %s: %w", properties["brokers"], err)
+	}
+	if len(metadata.Brokers) == 0 {
+		return "", fmt.Errorf("metadata response: no brokers")
+	}
+	return fmt.Sprintf("kafka cluster of %d brokers", len(metadata.Brokers)), nil
+}
+
+// KafkaClient returns a client of the brokers of the brokers property, authenticated
+// with the SASL mechanism of the authType (or authRequired) and SASL properties of a
+// Dapr kafka pubsub component. Client certificates are part of tlsConfig. The deadline of
+// ctx, if any, also bounds the reads and writes of the connections, so that closing the
+// client does not wait for an unresponsive broker.
+func KafkaClient(ctx context.Context, properties map[string]string, tlsConfig *tls.Config, opts ...kgo.Opt) (*kgo.Client, error) {
+	var brokers []string
+	for _, broker := range strings.Split(properties["brokers"], ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("brokers required")
+	}
+	opts = append([]kgo.Opt{kgo.SeedBrokers(brokers...)}, opts...)
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, kgo.RequestTimeoutOverhead(time.Until(deadline)))
+	}
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	mechanism, err := kafkaSASL(properties)
+	if err != nil {
+		return nil, err
+	}
+	if mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+	return kgo.NewClient(opts...)
+}
+
+// kafkaSASL returns the SASL mechanism of the properties of a Dapr kafka pubsub
+// component, nil without SASL authentication
+func kafkaSASL(properties map[string]string) (sasl.Mechanism, error) {
+	authType := properties["authType"]
+	if authType == "" && properties["authRequired"] == "true" {
+		authType = "password"
+	}
+	switch authType {
+	case "", "none", "mtls":
+		return nil, nil
+	case "password":
+		user, pass := properties["saslUsername"], properties["saslPassword"]
+		switch mechanism := properties["saslMechanism"]; strings.ToUpper(mechanism) {
+		case "", "PLAIN", "PLAINTEXT":
+			return plain.Auth{User: user, Pass: pass}.AsMechanism(), nil
+		case "SHA-256", "SCRAM-SHA-256":
+			return scram.Auth{User: user, Pass: pass}.AsSha256Mechanism(), nil
+		case "SHA-512", "SCRAM-SHA-512":
+			return scram.Auth{User: user, Pass: pass}.AsSha512Mechanism(), nil
+		default:
+			return nil, fmt.Errorf("saslMechanism %s not supported", mechanism)
+		}
+	case "oidc":
+		config := clientcredentials.Config{
+			ClientID:     properties["oidcClientID"],
+			ClientSecret: properties["oidcClientSecret"],
+			TokenURL:     properties["oidcTokenEndpoint"],
+		}
+		if scopes := properties["oidcScopes"]; scopes != "" {
+			config.Scopes = strings.Split(scopes, ",")
+		}
+		return oauth.Oauth(func(ctx context.Context) (oauth.Auth, error) {
+			token, err := config.Token(ctx)
+			if err != nil {
+				return oauth.Auth{}, fmt.Errorf("oidc token: %w", err)
+			}
+			return oauth.Auth{Token: token.AccessToken}, nil
+		}), nil
+	default:
+		return nil, fmt.Errorf("authType %s not supported", authType)
+	}
+}
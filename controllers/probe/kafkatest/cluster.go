@@ -0,0 +1,466 @@
+// Package kafkatest provides an in-memory fake of a Kafka cluster, answering the metadata,
+// SASL and admin requests of the probe and of the topic provisioner.
+package kafkatest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// kafka error codes answered by the cluster
+const (
+	unknownTopic            = 3
+	notController           = 41
+	unsupportedSASL         = 33
+	topicAlreadyExists      = 36
+	invalidPartitions       = 37
+	saslAuthenticationError = 58
+	invalidRequest          = 42
+)
+
+// defaultConfigs are the configuration of the topics created without configs
+var defaultConfigs = map[string]string{"retention.ms": "604800000", "cleanup.policy": "delete"}
+
+// Topic is a topic of the cluster
+type Topic struct {
+	Partitions        int32
+	ReplicationFactor int32
+	Configs           map[string]string
+}
+
+// Option configures a Cluster
+type Option func(*Cluster)
+
+// WithTLS serves the brokers with TLS
+func WithTLS(config *tls.Config) Option {
+	return func(c *Cluster) { c.tls = config }
+}
+
+// WithSASLPlain requires the clients to authenticate with the SASL PLAIN mechanism
+func WithSASLPlain(user, password string) Option {
+	return func(c *Cluster) { c.user, c.password = user, password }
+}
+
+// Cluster is an in-memory Kafka cluster of brokers listening on local addresses. The
+// last broker is the controller, the others answer the admin requests with NOT_CONTROLLER.
+// Topics created without a replication factor are replicated by every broker.
+type Cluster struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	topics    map[string]*Topic
+
+	tls            *tls.Config
+	user, password string
+}
+
+// NewCluster starts a cluster of brokers
+func NewCluster(brokers int, opts ...Option) (*Cluster, error) {
+	c := &Cluster{topics: make(map[string]*Topic)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for id := 0; id < brokers; id++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if c.tls != nil {
+			listener = tls.NewListener(listener, c.tls)
+		}
+		c.listeners = append(c.listeners, listener)
+		go c.accept(int32(id), listener)
+	}
+	return c, nil
+}
+
+// Brokers returns the comma separated addresses of the brokers
+func (c *Cluster) Brokers() string {
+	var addresses []string
+	for _, listener := range c.listeners {
+		addresses = append(addresses, listener.Addr().String())
+	}
+	return strings.Join(addresses, ",")
+}
+
+// Close stops the brokers
+func (c *Cluster) Close() {
+	for _, listener := range c.listeners {
+		listener.Close()
+	}
+}
+
+// SetTopic creates or replaces a topic
+func (c *Cluster) SetTopic(name string, topic Topic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	configs := make(map[string]string)
+	for k, v := range topic.Configs {
+		configs[k] = v
+	}
+	topic.Configs = configs
+	c.topics[name] = &topic
+}
+
+// Topic returns a topic and whether it exists
+func (c *Cluster) Topic(name string) (Topic, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	topic, ok := c.topics[name]
+	if !ok {
+		return Topic{}, false
+	}
+	configs := make(map[string]string)
+	for k, v := range topic.Configs {
+		configs[k] = v
+	}
+	return Topic{Partitions: topic.Partitions, ReplicationFactor: topic.ReplicationFactor, Configs: configs}, true
+}
+
+func (c *Cluster) accept(id int32, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go c.serve(id, conn)
+	}
+}
+
+// serve answers the requests of a connection, closed on unknown requests or, before the
+// SASL authentication, on requests other than the SASL requests
+func (c *Cluster) serve(id int32, conn net.Conn) {
+	defer conn.Close()
+	authenticated := c.user == ""
+	for {
+		var size int32
+		if binary.Read(conn, binary.BigEndian, &size) != nil {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		request, correlationID, err := readRequest(data)
+		if err != nil {
+			return
+		}
+		var response kmsg.Response
+		switch request := request.(type) {
+		case *kmsg.ApiVersionsRequest:
+			response = apiVersions(request)
+		case *kmsg.SASLHandshakeRequest:
+			response = saslHandshake(request)
+		case *kmsg.SASLAuthenticateRequest:
+			response = c.saslAuthenticate(request, &authenticated)
+		default:
+			if !authenticated {
+				return
+			}
+			c.mu.Lock()
+			response = c.handle(id, request)
+			c.mu.Unlock()
+		}
+		if response == nil {
+			return
+		}
+		response.SetVersion(request.GetVersion())
+
+		var frame bytes.Buffer
+		binary.Write(&frame, binary.BigEndian, correlationID)
+		// the header of the ApiVersions response is never flexible
+		if response.IsFlexible() && request.Key() != kmsg.ApiVersions.Int16() {
+			frame.WriteByte(0) // tagged fields
+		}
+		frame.Write(response.AppendTo(nil))
+		binary.Write(conn, binary.BigEndian, int32(frame.Len()))
+		if _, err := conn.Write(frame.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// readRequest parses the header and the body of a request
+func readRequest(data []byte) (kmsg.Request, int32, error) {
+	if len(data) < 10 {
+		return nil, 0, fmt.Errorf("short request")
+	}
+	key := int16(binary.BigEndian.Uint16(data))
+	version := int16(binary.BigEndian.Uint16(data[2:]))
+	correlationID := int32(binary.BigEndian.Uint32(data[4:]))
+	body := data[10:]
+	if clientID := int16(binary.BigEndian.Uint16(data[8:])); clientID > 0 {
+		if len(body) < int(clientID) {
+			return nil, 0, fmt.Errorf("short request")
+		}
+		body = body[clientID:]
+	}
+	request := kmsg.RequestForKey(key)
+	if request == nil {
+		return nil, 0, fmt.Errorf("unknown request key %d", key)
+	}
+	request.SetVersion(version)
+	if request.IsFlexible() {
+		// skip the tagged fields of the header
+		reader := bytes.NewReader(body)
+		tags, err := binary.ReadUvarint(reader)
+		for ; err == nil && tags > 0; tags-- {
+			var size uint64
+			if _, err = binary.ReadUvarint(reader); err == nil {
+				if size, err = binary.ReadUvarint(reader); err == nil {
+					_, err = reader.Seek(int64(size), io.SeekCurrent)
+				}
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		body = body[len(body)-reader.Len():]
+	}
+	if err := request.ReadFrom(body); err != nil {
+		return nil, 0, err
+	}
+	return request, correlationID, nil
+}
+
+// supportedKeys are the requests answered by the cluster, at every version known to kmsg
+var supportedKeys = []kmsg.Key{
+	kmsg.Metadata, kmsg.SASLHandshake, kmsg.ApiVersions, kmsg.CreateTopics, kmsg.DeleteTopics,
+	kmsg.DescribeConfigs, kmsg.SASLAuthenticate, kmsg.CreatePartitions, kmsg.IncrementalAlterConfigs,
+}
+
+func apiVersions(request *kmsg.ApiVersionsRequest) kmsg.Response {
+	response := kmsg.NewPtrApiVersionsResponse()
+	for _, key := range supportedKeys {
+		apiKey := kmsg.NewApiVersionsResponseApiKey()
+		apiKey.ApiKey = key.Int16()
+		apiKey.MaxVersion = kmsg.RequestForKey(key.Int16()).MaxVersion()
+		response.ApiKeys = append(response.ApiKeys, apiKey)
+	}
+	return response
+}
+
+func saslHandshake(request *kmsg.SASLHandshakeRequest) kmsg.Response {
+	response := kmsg.NewPtrSASLHandshakeResponse()
+	response.SupportedMechanisms = []string{"PLAIN"}
+	if request.Mechanism != "PLAIN" {
+		response.ErrorCode = unsupportedSASL
+	}
+	return response
+}
+
+// saslAuthenticate checks the PLAIN credentials, authzid\x00user\x00password
+func (c *Cluster) saslAuthenticate(request *kmsg.SASLAuthenticateRequest, authenticated *bool) kmsg.Response {
+	response := kmsg.NewPtrSASLAuthenticateResponse()
+	credentials := strings.Split(string(request.SASLAuthBytes), "\x00")
+	if len(credentials) != 3 || credentials[1] != c.user || credentials[2] != c.password {
+		response.ErrorCode = saslAuthenticationError
+		response.ErrorMessage = kmsg.StringPtr("Authentication failed: Invalid username or password")
+		return response
+	}
+	*authenticated = true
+	return response
+}
+
+// handle answers the metadata and admin requests, nil for other requests
+func (c *Cluster) handle(id int32, request kmsg.Request) kmsg.Response {
+	controller := id == int32(len(c.listeners)-1)
+	switch request := request.(type) {
+	case *kmsg.MetadataRequest:
+		return c.metadata(request)
+	case *kmsg.CreateTopicsRequest:
+		response := kmsg.NewPtrCreateTopicsResponse()
+		for _, t := range request.Topics {
+			result := kmsg.NewCreateTopicsResponseTopic()
+			result.Topic = t.Topic
+			topic := &Topic{Partitions: t.NumPartitions, ReplicationFactor: int32(t.ReplicationFactor), Configs: make(map[string]string)}
+			if topic.Partitions == -1 {
+				topic.Partitions = 1
+			}
+			if topic.ReplicationFactor == -1 {
+				topic.ReplicationFactor = int32(len(c.listeners))
+			}
+			for k, v := range defaultConfigs {
+				topic.Configs[k] = v
+			}
+			for _, config := range t.Configs {
+				if config.Value != nil {
+					topic.Configs[config.Name] = *config.Value
+				}
+			}
+			switch {
+			case !controller:
+				result.ErrorCode = notController
+			case c.topics[t.Topic] != nil:
+				result.ErrorCode = topicAlreadyExists
+			case topic.Partitions <= 0:
+				result.ErrorCode = invalidPartitions
+			default:
+				if !request.ValidateOnly {
+					c.topics[t.Topic] = topic
+				}
+				result.NumPartitions = topic.Partitions
+				result.ReplicationFactor = int16(topic.ReplicationFactor)
+			}
+			response.Topics = append(response.Topics, result)
+		}
+		return response
+	case *kmsg.DeleteTopicsRequest:
+		names := request.TopicNames
+		for _, t := range request.Topics {
+			if t.Topic != nil {
+				names = append(names, *t.Topic)
+			}
+		}
+		response := kmsg.NewPtrDeleteTopicsResponse()
+		for _, name := range names {
+			result := kmsg.NewDeleteTopicsResponseTopic()
+			result.Topic = kmsg.StringPtr(name)
+			switch {
+			case !controller:
+				result.ErrorCode = notController
+			case c.topics[name] == nil:
+				result.ErrorCode = unknownTopic
+			default:
+				delete(c.topics, name)
+			}
+			response.Topics = append(response.Topics, result)
+		}
+		return response
+	case *kmsg.CreatePartitionsRequest:
+		response := kmsg.NewPtrCreatePartitionsResponse()
+		for _, t := range request.Topics {
+			result := kmsg.NewCreatePartitionsResponseTopic()
+			result.Topic = t.Topic
+			topic := c.topics[t.Topic]
+			switch {
+			case !controller:
+				result.ErrorCode = notController
+			case topic == nil:
+				result.ErrorCode = unknownTopic
+			case t.Count <= topic.Partitions:
+				result.ErrorCode = invalidPartitions
+				result.ErrorMessage = kmsg.StringPtr(fmt.Sprintf("Topic currently has %d partitions", topic.Partitions))
+			case !request.ValidateOnly:
+				topic.Partitions = t.Count
+			}
+			response.Topics = append(response.Topics, result)
+		}
+		return response
+	case *kmsg.DescribeConfigsRequest:
+		response := kmsg.NewPtrDescribeConfigsResponse()
+		for _, r := range request.Resources {
+			result := kmsg.NewDescribeConfigsResponseResource()
+			result.ResourceType, result.ResourceName = r.ResourceType, r.ResourceName
+			topic := c.topics[r.ResourceName]
+			switch {
+			case r.ResourceType != kmsg.ConfigResourceTypeTopic:
+				result.ErrorCode = invalidRequest
+			case topic == nil:
+				result.ErrorCode = unknownTopic
+			default:
+				for _, name := range sortedKeys(topic.Configs) {
+					config := kmsg.NewDescribeConfigsResponseResourceConfig()
+					config.Name, config.Value = name, kmsg.StringPtr(topic.Configs[name])
+					config.Source = kmsg.ConfigSourceDynamicTopicConfig
+					result.Configs = append(result.Configs, config)
+				}
+			}
+			response.Resources = append(response.Resources, result)
+		}
+		return response
+	case *kmsg.IncrementalAlterConfigsRequest:
+		response := kmsg.NewPtrIncrementalAlterConfigsResponse()
+		for _, r := range request.Resources {
+			result := kmsg.NewIncrementalAlterConfigsResponseResource()
+			result.ResourceType, result.ResourceName = r.ResourceType, r.ResourceName
+			topic := c.topics[r.ResourceName]
+			switch {
+			case r.ResourceType != kmsg.ConfigResourceTypeTopic:
+				result.ErrorCode = invalidRequest
+			case topic == nil:
+				result.ErrorCode = unknownTopic
+			case !request.ValidateOnly:
+				for _, config := range r.Configs {
+					switch {
+					case config.Op == kmsg.IncrementalAlterConfigOpDelete:
+						delete(topic.Configs, config.Name)
+					case config.Op == kmsg.IncrementalAlterConfigOpSet && config.Value != nil:
+						topic.Configs[config.Name] = *config.Value
+					}
+				}
+			}
+			response.Resources = append(response.Resources, result)
+		}
+		return response
+	}
+	return nil
+}
+
+// metadata answers the brokers, the controller and the requested topics, all topics
+// when none are requested
+func (c *Cluster) metadata(request *kmsg.MetadataRequest) kmsg.Response {
+	response := kmsg.NewPtrMetadataResponse()
+	response.ControllerID = int32(len(c.listeners) - 1)
+	for id, listener := range c.listeners {
+		host, port, _ := net.SplitHostPort(listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		broker := kmsg.NewMetadataResponseBroker()
+		broker.NodeID, broker.Host, broker.Port = int32(id), host, int32(p)
+		response.Brokers = append(response.Brokers, broker)
+	}
+	var names []string
+	if request.Topics == nil {
+		names = make([]string, 0, len(c.topics))
+		for name := range c.topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, t := range request.Topics {
+		if t.Topic != nil {
+			names = append(names, *t.Topic)
+		}
+	}
+	for _, name := range names {
+		result := kmsg.NewMetadataResponseTopic()
+		result.Topic = kmsg.StringPtr(name)
+		topic, ok := c.topics[name]
+		if !ok {
+			result.ErrorCode = unknownTopic
+			response.Topics = append(response.Topics, result)
+			continue
+		}
+		for p := int32(0); p < topic.Partitions; p++ {
+			partition := kmsg.NewMetadataResponseTopicPartition()
+			partition.Partition = p
+			partition.Leader = p % int32(len(c.listeners))
+			for r := int32(0); r < topic.ReplicationFactor; r++ {
+				partition.Replicas = append(partition.Replicas, r)
+			}
+			partition.ISR = partition.Replicas
+			result.Partitions = append(result.Partitions, partition)
+		}
+		response.Topics = append(response.Topics, result)
+	}
+	return response
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
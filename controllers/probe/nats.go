@@ -0,0 +1,71 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// NATS reads the INFO message of the server of the natsURL property (a nats or tls URL)
+// and returns its version. With a tls URL, or tlsConfig, the connection is upgraded to
+// TLS after the INFO message, as NATS clients do.
+func NATS(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (string, error) {
+	server, err := url.Parse(properties["natsURL"])
+	if err != nil {
+		return "", fmt.Errorf("natsURL: %w", err)
+	}
+	switch server.Scheme {
+	case "nats":
+	case "tls":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+	default:
+		return "", fmt.Errorf("natsURL: nats or tls URL required")
+	}
+	port := server.Port()
+	if port == "" {
+		port = "4222"
+	}
+	address := net.JoinHostPort(server.Hostname(), port)
+
+	conn, err := Dial(ctx, address, nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("info: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return "", fmt.Errorf("info: unexpected message %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		Version     string `json:"version"`
+		JetStream   bool   `json:"jetstream"`
+		TLSRequired bool   `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		return "", fmt.Errorf("info: %w", err)
+	}
+	if info.TLSRequired && tlsConfig == nil {
+		return "", fmt.Errorf("server requires tls")
+	}
+	if tlsConfig != nil {
+		tlsConn, err := startTLS(conn, address, tlsConfig)
+		if err != nil {
+			return "", err
+		}
+		defer tlsConn.Close()
+	}
+	if !info.JetStream {
+		return "", fmt.Errorf("nats %s: jetstream not enabled", info.Version)
+	}
+	return fmt.Sprintf("nats %s", info.Version), nil
+}
@@ -0,0 +1,84 @@
+// Package probe checks the connectivity of the streaming systems of ClusterStreams. A
+// probe connects to the brokers of a protocol, with the properties of the pubsub
+// component, and fetches their metadata with a protocol request.
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Func probes the brokers configured by the properties of a pubsub component, it
+// returns a description of the brokers
+type Func func(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (string, error)
+
+// defaultTimeout is the timeout of a probe without deadline
+const defaultTimeout = 5 * time.Second
+
+// probes are the probe functions of each protocol
+var probes = map[string]Func{
+	"kafka":     Kafka,
+	"redis":     Redis,
+	"rabbitmq":  AMQP,
+	"jetstream": NATS,
+}
+
+// Supported returns whether a protocol can be probed
+func Supported(protocol string) bool {
+	_, ok := probes[protocol]
+	return ok
+}
+
+// Probe probes the brokers of a protocol, with a default timeout of 5s if ctx has no
+// deadline. tlsConfig, if not nil, encrypts the connections.
+func Probe(ctx context.Context, protocol string, properties map[string]string, tlsConfig *tls.Config) (string, error) {
+	probe, ok := probes[protocol]
+	if !ok {
+		return "", fmt.Errorf("protocol %s can not be probed", protocol)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	return probe(ctx, properties, tlsConfig)
+}
+
+// Dial connects to an address, with TLS if tlsConfig is not nil. The deadline of the
+// context applies to the connection.
+func Dial(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	return startTLS(conn, address, tlsConfig)
+}
+
+// startTLS starts a TLS session on a connection to an address
+func startTLS(conn net.Conn, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,261 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/streaming-runtime/controllers/probe/kafkatest"
+)
+
+// standIn serves the connections of a local listener with handle, and returns its address
+func standIn(t *testing.T, listener net.Listener, handle func(net.Conn)) string {
+	t.Helper()
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// closedAddress returns the address of a closed listener
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+// kafkaCluster starts a fake kafka cluster of brokers
+func kafkaCluster(t *testing.T, brokers int, opts ...kafkatest.Option) *kafkatest.Cluster {
+	t.Helper()
+	cluster, err := kafkatest.NewCluster(brokers, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+// redisServer answers AUTH with the password and INFO commands
+func redisServer(password string) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		authenticated := password == ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var args []string
+			count := 0
+			fmt.Sscanf(line, "*%d", &count)
+			for i := 0; i < count; i++ {
+				reader.ReadString('\n') // size
+				arg, _ := reader.ReadString('\n')
+				args = append(args, strings.TrimSpace(arg))
+			}
+			switch {
+			case args[0] == "AUTH" && args[len(args)-1] == password:
+				authenticated = true
+				io.WriteString(conn, "+OK\r\n")
+			case args[0] == "AUTH":
+				io.WriteString(conn, "-WRONGPASS invalid username-password pair\r\n")
+			case !authenticated:
+				io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			case args[0] == "INFO":
+				info := "# Server\r\nredis_version:6.2.6\r\nredis_mode:standalone\r\n"
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(info), info)
+			}
+		}
+	}
+}
+
+// amqpServer answers the protocol header with a connection.start method
+func amqpServer(conn net.Conn) {
+	header := make([]byte, len(amqpHeader))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if !bytes.Equal(header, amqpHeader) {
+		conn.Write(amqpHeader)
+		return
+	}
+	var table bytes.Buffer
+	field := func(name string, kind byte, value []byte) {
+		table.WriteByte(byte(len(name)))
+		table.WriteString(name)
+		table.WriteByte(kind)
+		binary.Write(&table, binary.BigEndian, uint32(len(value)))
+		table.Write(value)
+	}
+	field("capabilities", 'F', []byte{0, 0, 0, 0})
+	field("product", 'S', []byte("RabbitMQ"))
+	field("version", 'S', []byte("3.9.13"))
+
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.BigEndian, []uint16{amqpConnectionClass, amqpStartMethod})
+	payload.Write([]byte{0, 9})
+	binary.Write(&payload, binary.BigEndian, uint32(table.Len()))
+	payload.Write(table.Bytes())
+
+	var frame bytes.Buffer
+	frame.WriteByte(amqpFrameMethod)
+	binary.Write(&frame, binary.BigEndian, uint16(0))
+	binary.Write(&frame, binary.BigEndian, uint32(payload.Len()))
+	frame.Write(payload.Bytes())
+	frame.WriteByte(amqpFrameEnd)
+	conn.Write(frame.Bytes())
+}
+
+// natsServer sends an INFO message
+func natsServer(info string) func(net.Conn) {
+	return func(conn net.Conn) {
+		fmt.Fprintf(conn, "INFO %s\r\n", info)
+		io.Copy(io.Discard, conn)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	kafka := kafkaCluster(t, 3).Brokers()
+	sasl := kafkaCluster(t, 1, kafkatest.WithSASLPlain("streaming", "secret")).Brokers()
+	redis := standIn(t, nil, redisServer("secret"))
+	amqp := standIn(t, nil, amqpServer)
+	nats := standIn(t, nil, natsServer(`{"version":"2.7.4","jetstream":true}`))
+	natsCore := standIn(t, nil, natsServer(`{"version":"2.7.4"}`))
+	closed := closedAddress(t)
+
+	tests := []struct {
+		name       string
+		protocol   string
+		properties map[string]string
+		want       string
+		wantErr    string
+	}{
+		{name: "kafka", protocol: "kafka", properties: map[string]string{"brokers": kafka}, want: "kafka cluster of 3 brokers"},
+		{name: "kafka first broker down", protocol: "kafka", properties: map[string]string{"brokers": closed + ", " + kafka}, want: "kafka cluster of 3 brokers"},
+		{name: "kafka unreachable", protocol: "kafka", properties: map[string]string{"brokers": closed}, wantErr: "brokers " + closed},
+		{
+			name: "kafka sasl", protocol: "kafka",
+			properties: map[string]string{"brokers": sasl, "authType": "password", "saslMechanism": "PLAINTEXT", "saslUsername": "streaming", "saslPassword": "secret"},
+			want:       "kafka cluster of 1 brokers",
+		},
+		{
+			name: "kafka sasl wrong password", protocol: "kafka",
+			properties: map[string]string{"brokers": sasl, "authType": "password", "saslUsername": "streaming", "saslPassword": "wrong"},
+			wantErr:    "Invalid username or password",
+		},
+		{name: "kafka without credentials", protocol: "kafka", properties: map[string]string{"brokers": sasl}, wantErr: "brokers " + sasl},
+		{
+			name: "kafka unsupported mechanism", protocol: "kafka",
+			properties: map[string]string{"brokers": sasl, "authType": "password", "saslMechanism": "GSSAPI"},
+			wantErr:    "saslMechanism GSSAPI not supported",
+		},
+		{name: "kafka without brokers", protocol: "kafka", properties: map[string]string{}, wantErr: "brokers required"},
+		{name: "redis", protocol: "redis", properties: map[string]string{"redisHost": redis, "redisPassword": "secret"}, want: "redis 6.2.6"},
+		{name: "redis wrong password", protocol: "redis", properties: map[string]string{"redisHost": redis, "redisPassword": "wrong"}, wantErr: "auth: WRONGPASS"},
+		{name: "redis without password", protocol: "redis", properties: map[string]string{"redisHost": redis}, wantErr: "info: NOAUTH"},
+		{name: "rabbitmq", protocol: "rabbitmq", properties: map[string]string{"host": "amqp://guest:guest@" + amqp + "/"}, want: "amqp 0-9 RabbitMQ 3.9.13"},
+		{name: "rabbitmq invalid host", protocol: "rabbitmq", properties: map[string]string{"host": "http://" + amqp}, wantErr: "amqp or amqps URL required"},
+		{name: "jetstream", protocol: "jetstream", properties: map[string]string{"natsURL": "nats://" + nats}, want: "nats 2.7.4"},
+		{name: "nats without jetstream", protocol: "jetstream", properties: map[string]string{"natsURL": "nats://" + natsCore}, wantErr: "jetstream not enabled"},
+		{name: "unsupported protocol", protocol: "mqtt", wantErr: "can not be probed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			got, err := Probe(ctx, test.protocol, test.properties, nil)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProbeTLS(t *testing.T) {
+	// borrow the certificate of a test server, valid for 127.0.0.1
+	server := httptest.NewTLSServer(nil)
+	server.Close()
+	kafka := kafkaCluster(t, 1, kafkatest.WithTLS(&tls.Config{Certificates: server.TLS.Certificates})).Brokers()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		wantErr bool
+	}{
+		{name: "trusted", config: &tls.Config{RootCAs: roots}},
+		{name: "skip verify", config: &tls.Config{InsecureSkipVerify: true}},
+		{name: "untrusted", config: &tls.Config{}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Probe(context.Background(), "kafka", map[string]string{"brokers": kafka}, test.config)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "tls:") {
+					t.Fatalf("got error %v, want tls error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "kafka cluster of 1 brokers" {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	// a server accepting connections but never answering
+	silent := standIn(t, nil, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Probe(ctx, "kafka", map[string]string{"brokers": silent}, nil); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("probe returned after %s", elapsed)
+	}
+}
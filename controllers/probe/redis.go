@@ -0,0 +1,84 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Redis authenticates, with the redisUsername and redisPassword properties, to the
+// server of the redisHost property and returns its version, from the INFO command
+func Redis(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (string, error) {
+	address := properties["redisHost"]
+	if address == "" {
+		return "", fmt.Errorf("redisHost required")
+	}
+	conn, err := Dial(ctx, address, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if password := properties["redisPassword"]; password != "" {
+		args := []string{"AUTH", password}
+		if username := properties["redisUsername"]; username != "" {
+			args = []string{"AUTH", username, password}
+		}
+		if _, err := redisCommand(conn, reader, args...); err != nil {
+			return "", fmt.Errorf("auth: %w", err)
+		}
+	}
+	info, err := redisCommand(conn, reader, "INFO", "server")
+	if err != nil {
+		return "", fmt.Errorf("info: %w", err)
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			return fmt.Sprintf("redis %s", strings.TrimSpace(strings.TrimPrefix(line, "redis_version:"))), nil
+		}
+	}
+	return "", fmt.Errorf("info: redis_version not found")
+}
+
+// redisCommand sends a command and returns its simple or bulk string reply
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (string, error) {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, command.String()); err != nil {
+		return "", err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("%s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return "", fmt.Errorf("invalid reply %q", line)
+		}
+		data := make([]byte, size+2) // with \r\n
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		return string(data[:size]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
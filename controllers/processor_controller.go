@@ -19,20 +19,29 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 
+	daprsubscriptions "github.com/dapr/dapr/pkg/apis/subscriptions/v2alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
 )
 
+// processorLabel labels the resources created for a processor with its name
+const processorLabel = "streaming.vivien.io/processor"
+
 // ProcessorReconciler reconciles a Processor object
 type ProcessorReconciler struct {
 	client.Client
@@ -43,6 +52,12 @@ type ProcessorReconciler struct {
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=processors/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=processors/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams,verbs=get;list;watch
+//+kubebuilder:rbac:groups=dapr.io,resources=subscriptions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams,verbs=get;list;watch
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keda.sh,resources=triggerauthentications,verbs=get;list;watch;create;update;patch;delete
 
 func (r *ProcessorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
@@ -102,6 +117,38 @@ func (r *ProcessorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	// wire the streams declared in From to the processor service route, missing
+	// streams (and clusterstreams, to scale on their lag) are reported in the status
+	streams, missing, err := resolveStreams(ctx, r.Client, proc.Namespace, proc.Spec.From)
+	if err != nil {
+		log.Error(err, "Failed to get streams for Processor", "Name", proc.Name, "Namespace", proc.Namespace)
+		return ctrl.Result{}, err
+	}
+	if autoscaling := proc.Spec.Autoscaling; autoscaling != nil && autoscaling.Scaler != "hpa" {
+		missingClusterStreams, err := resolveClusterStreams(ctx, r.Client, streams)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		missing = append(missing, missingClusterStreams...)
+	}
+	if err := updateReferencesResolved(ctx, r.Client, proc, &proc.Status.Conditions, missing); err != nil {
+		log.Error(err, "Failed to update Processor status", "Name", proc.Name, "Namespace", proc.Namespace)
+		return ctrl.Result{}, err
+	}
+	if len(missing) > 0 {
+		log.Info("Processor references not found", "Name", proc.Name, "Namespace", proc.Namespace, "Missing", missing)
+		return ctrl.Result{}, nil
+	}
+	if err := r.reconcileSubscriptions(ctx, proc, streams); err != nil {
+		log.Error(err, "Failed to reconcile subscriptions for Processor", "Name", proc.Name, "Namespace", proc.Namespace)
+		return ctrl.Result{}, err
+	}
+
+	if err := reconcileAutoscaler(ctx, r.Client, r.Scheme, proc, proc.Spec.Autoscaling, streams); err != nil {
+		log.Error(err, "Failed to reconcile autoscaler for Processor", "Name", proc.Name, "Namespace", proc.Namespace)
+		return ctrl.Result{}, err
+	}
+
 	log.Info("Updated deployment for Processor",
 		"Namespace", proc.Namespace,
 		"Name", proc.Name)
@@ -109,29 +156,135 @@ func (r *ProcessorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	return ctrl.Result{}, nil
 }
 
+// reconcileSubscriptions creates or updates a Dapr Subscription, scoped to the processor
+// app-id, for each stream and deletes the subscriptions of the streams no longer listed.
+func (r *ProcessorReconciler) reconcileSubscriptions(ctx context.Context, proc *streamingruntime.Processor, streams []*streamingruntime.Stream) error {
+	log := log.FromContext(ctx)
+
+	desired := make(map[string]bool)
+	for _, stream := range streams {
+		sub, err := r.createProcessorSubscription(proc, stream)
+		if err != nil {
+			return err
+		}
+		desired[sub.Name] = true
+
+		existing := new(daprsubscriptions.Subscription)
+		err = r.Get(ctx, types.NamespacedName{Namespace: sub.Namespace, Name: sub.Name}, existing)
+		switch {
+		case errors.IsNotFound(err):
+			if err := r.Create(ctx, sub); err != nil {
+				return err
+			}
+			log.Info("Created subscription for Processor",
+				"Processor", proc.Name,
+				"Stream", stream.Name,
+				"Pubsub", sub.Spec.Pubsubname,
+				"Topic", sub.Spec.Topic,
+				"Route", sub.Spec.Routes.Default)
+		case err != nil:
+			return err
+		case !equality.Semantic.DeepEqual(existing.Spec, sub.Spec) || !equality.Semantic.DeepEqual(existing.Scopes, sub.Scopes):
+			existing.Spec = sub.Spec
+			existing.Scopes = sub.Scopes
+			if err := r.Update(ctx, existing); err != nil {
+				return err
+			}
+			log.Info("Updated subscription for Processor", "Processor", proc.Name, "Stream", stream.Name, "Name", existing.Name)
+		}
+	}
+
+	// delete the subscriptions of streams removed from From
+	subs := new(daprsubscriptions.SubscriptionList)
+	if err := r.List(ctx, subs, client.InNamespace(proc.Namespace), client.MatchingLabels{processorLabel: proc.Name}); err != nil {
+		return err
+	}
+	for i := range subs.Items {
+		sub := &subs.Items[i]
+		if desired[sub.Name] || !metav1.IsControlledBy(sub, proc) {
+			continue
+		}
+		if err := r.Delete(ctx, sub); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info("Deleted subscription for Processor", "Processor", proc.Name, "Name", sub.Name)
+	}
+	return nil
+}
+
+// createProcessorSubscription returns the Dapr Subscription delivering the stream
+// events to the processor service route, scoped to the processor app-id
+func (r *ProcessorReconciler) createProcessorSubscription(proc *streamingruntime.Processor, stream *streamingruntime.Stream) (*daprsubscriptions.Subscription, error) {
+	serviceRoute := proc.Spec.ServiceRoute
+	if serviceRoute == "" {
+		serviceRoute = proc.Name
+	}
+	sub := &daprsubscriptions.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", proc.Name, stream.Name),
+			Namespace: proc.Namespace,
+			Labels:    map[string]string{processorLabel: proc.Name},
+		},
+		Spec: daprsubscriptions.SubscriptionSpec{
+			Pubsubname: stream.Spec.ClusterStream,
+			Topic:      stream.Spec.Topic,
+			Routes: daprsubscriptions.Routes{
+				Default: fmt.Sprintf("/%s", strings.TrimPrefix(serviceRoute, "/")),
+			},
+		},
+		Scopes: []string{proc.Name},
+	}
+
+	// establish ownership
+	if err := ctrl.SetControllerReference(proc, sub, r.Scheme); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ProcessorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&streamingruntime.Processor{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&daprsubscriptions.Subscription{}).
+		Owns(&autoscalingv2beta2.HorizontalPodAutoscaler{}).
+		Watches(&source.Kind{Type: &streamingruntime.Stream{}}, handler.EnqueueRequestsFromMapFunc(requestsForStream(r.Client, processorList))).
+		Watches(&source.Kind{Type: &streamingruntime.ClusterStream{}}, handler.EnqueueRequestsFromMapFunc(requestsForClusterStream(r.Client, processorList))).
 		Complete(r)
 }
 
+func processorList() client.ObjectList {
+	return new(streamingruntime.ProcessorList)
+}
+
 func (r *ProcessorReconciler) createProcessorDeployment(_ context.Context, proc *streamingruntime.Processor) (*appsv1.Deployment, error) {
 	replicas := proc.Spec.Replicas
 	if replicas == 0 {
 		replicas = 1
 	}
+	if err := validateAutoscaling(proc.Spec.Autoscaling); err != nil {
+		return nil, fmt.Errorf("processor %s: %w", proc.Name, err)
+	}
+	if proc.Spec.Autoscaling != nil {
+		replicas = autoscalingMinReplicas(proc.Spec.Autoscaling)
+	}
 
 	// collect environment vars
 	serviceRoute := proc.Spec.ServiceRoute
 	if serviceRoute == "" {
 		serviceRoute = proc.Name
 	}
+	targetStream, targetComponent, err := processorTarget(proc.Spec.Target)
+	if err != nil {
+		return nil, err
+	}
 	proc.Spec.Container.Env = append(proc.Spec.Container.Env,
 		corev1.EnvVar{Name: "PROC_SERVICE_PORT", Value: fmt.Sprintf(":%d", proc.Spec.ServicePort)},
 		corev1.EnvVar{Name: "PROC_SERVICE_ROUTE", Value: serviceRoute},
-		corev1.EnvVar{Name: "PROC_TARGET", Value: validateTarget(proc.Spec.Target)},
+		corev1.EnvVar{Name: "PROC_TARGET", Value: targetComponent},
+		corev1.EnvVar{Name: "PROC_TARGET_STREAM", Value: targetStream},
+		corev1.EnvVar{Name: "PROC_TARGET_COMPONENT", Value: targetComponent},
 	)
 
 	// add port info
@@ -176,6 +329,26 @@ func (r *ProcessorReconciler) createProcessorDeployment(_ context.Context, proc
 	return deployment, nil
 }
 
+// processorTarget returns the stream (pubsub/topic) and component (component/route)
+// paths of a processor target, specified as stream:<path> or component:<path>.
+// A target without prefix is a component.
+func processorTarget(target string) (stream, component string, err error) {
+	switch {
+	case target == "":
+		return "", "", nil
+	case strings.HasPrefix(target, "stream:"):
+		stream = strings.TrimPrefix(target, "stream:")
+		if strings.Index(stream, "/") == -1 {
+			return "", "", fmt.Errorf("processor target %s: stream must be specified as stream:pubsub/topic", target)
+		}
+		return stream, "", nil
+	case strings.HasPrefix(target, "component:"):
+		return "", validateTarget(strings.TrimPrefix(target, "component:")), nil
+	default:
+		return "", validateTarget(target), nil
+	}
+}
+
 func (r *ProcessorReconciler) updateProcessorDeployment(proc *streamingruntime.Processor, dep *appsv1.Deployment) {
 	replicas := proc.Spec.Replicas
 	if replicas == 0 {
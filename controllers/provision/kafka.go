@@ -0,0 +1,209 @@
+package provision
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/vladimirvivien/streaming-runtime/controllers/probe"
+)
+
+const kafkaClientID = "streaming-runtime-provisioner"
+
+// topic configuration keys
+const (
+	retentionConfig     = "retention.ms"
+	cleanupPolicyConfig = "cleanup.policy"
+)
+
+// Kafka creates the topic with the admin API of the cluster of the brokers property, or
+// updates its partitions and configuration. The number of partitions can only be
+// increased and the replication factor can not be changed. The brokers are authenticated
+// with the credentials of the pubsub component.
+func Kafka(ctx context.Context, properties map[string]string, tlsConfig *tls.Config, topic Topic) (Config, error) {
+	admin, err := kafkaAdmin(ctx, properties, tlsConfig)
+	if err != nil {
+		return Config{}, err
+	}
+	defer admin.Close()
+
+	configs := make(map[string]*string)
+	if topic.Retention != nil {
+		configs[retentionConfig] = kadm.StringPtr(strconv.FormatInt(topic.Retention.Milliseconds(), 10))
+	}
+	if topic.Compaction != nil {
+		configs[cleanupPolicyConfig] = kadm.StringPtr("delete")
+		if *topic.Compaction {
+			configs[cleanupPolicyConfig] = kadm.StringPtr("compact")
+		}
+	}
+
+	_, exists, err := kafkaTopic(ctx, admin, topic.Name)
+	if err != nil {
+		return Config{}, err
+	}
+	if !exists {
+		partitions, replicationFactor := int32(-1), int16(-1) // the defaults of the brokers
+		if topic.Partitions != nil {
+			partitions = *topic.Partitions
+		}
+		if topic.ReplicationFactor != nil {
+			replicationFactor = int16(*topic.ReplicationFactor)
+		}
+		responses, err := admin.CreateTopics(ctx, partitions, replicationFactor, configs, topic.Name)
+		if err == nil {
+			_, err = responses.On(topic.Name, func(r *kadm.CreateTopicResponse) error { return r.Err })
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("create topic: %w", err)
+		}
+		config, err := kafkaDescribe(ctx, admin, topic.Name)
+		config.Created = err == nil
+		return config, err
+	}
+
+	actual, err := kafkaDescribe(ctx, admin, topic.Name)
+	if err != nil {
+		return Config{}, err
+	}
+	if topic.ReplicationFactor != nil && *topic.ReplicationFactor != actual.ReplicationFactor {
+		return actual, fmt.Errorf("replication factor of topic %s can not be changed from %d", topic.Name, actual.ReplicationFactor)
+	}
+	if topic.Partitions != nil && *topic.Partitions < actual.Partitions {
+		return actual, fmt.Errorf("partitions of topic %s can not be decreased from %d", topic.Name, actual.Partitions)
+	}
+	if topic.Partitions != nil && *topic.Partitions > actual.Partitions {
+		responses, err := admin.UpdatePartitions(ctx, int(*topic.Partitions), topic.Name)
+		if err == nil {
+			_, err = responses.On(topic.Name, func(r *kadm.CreatePartitionsResponse) error { return r.Err })
+		}
+		if err != nil {
+			return actual, fmt.Errorf("create partitions: %w", err)
+		}
+	}
+	current, err := kafkaConfigs(ctx, admin, topic.Name)
+	if err != nil {
+		return actual, err
+	}
+	var changed []kadm.AlterConfig
+	for _, name := range sortedKeys(configs) {
+		if value := configs[name]; current[name] != *value {
+			changed = append(changed, kadm.AlterConfig{Op: kadm.SetConfig, Name: name, Value: value})
+		}
+	}
+	if len(changed) > 0 {
+		responses, err := admin.AlterTopicConfigs(ctx, changed, topic.Name)
+		if err == nil {
+			_, err = responses.On(topic.Name, func(r *kadm.AlterConfigsResponse) error { return r.Err })
+		}
+		if err != nil {
+			return actual, fmt.Errorf("alter configs: %w", err)
+		}
+	}
+	return kafkaDescribe(ctx, admin, topic.Name)
+}
+
+// DeleteKafka deletes a topic with the admin API of the cluster of the brokers property
+func DeleteKafka(ctx context.Context, properties map[string]string, tlsConfig *tls.Config, topic string) error {
+	admin, err := kafkaAdmin(ctx, properties, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	responses, err := admin.DeleteTopics(ctx, topic)
+	if err == nil {
+		_, err = responses.On(topic, func(r *kadm.DeleteTopicResponse) error { return r.Err })
+	}
+	if err != nil && !errors.Is(err, kerr.UnknownTopicOrPartition) {
+		return fmt.Errorf("delete topic: %w", err)
+	}
+	return nil
+}
+
+// kafkaAdmin returns an admin client of the brokers, authenticated as the pubsub component
+func kafkaAdmin(ctx context.Context, properties map[string]string, tlsConfig *tls.Config) (*kadm.Client, error) {
+	client, err := probe.KafkaClient(ctx, properties, tlsConfig, kgo.ClientID(kafkaClientID))
+	if err != nil {
+		return nil, err
+	}
+	return kadm.NewClient(client), nil
+}
+
+// kafkaTopic returns the metadata of a topic and whether it exists
+func kafkaTopic(ctx context.Context, admin *kadm.Client, topic string) (kadm.TopicDetail, bool, error) {
+	topics, err := admin.ListTopics(ctx, topic)
+	if err != nil {
+		return kadm.TopicDetail{}, false, fmt.Errorf("metadata: %w", err)
+	}
+	detail, ok := topics[topic]
+	switch {
+	case !ok || errors.Is(detail.Err, kerr.UnknownTopicOrPartition):
+		return kadm.TopicDetail{}, false, nil
+	case detail.Err != nil:
+		return kadm.TopicDetail{}, false, fmt.Errorf("metadata: %w", detail.Err)
+	}
+	return detail, true, nil
+}
+
+// kafkaConfigs returns the configuration of a topic
+func kafkaConfigs(ctx context.Context, admin *kadm.Client, topic string) (map[string]string, error) {
+	resources, err := admin.DescribeTopicConfigs(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("describe configs: %w", err)
+	}
+	resource, err := resources.On(topic, func(r *kadm.ResourceConfig) error { return r.Err })
+	if err != nil {
+		return nil, fmt.Errorf("describe configs: %w", err)
+	}
+	configs := make(map[string]string)
+	for _, config := range resource.Configs {
+		if config.Value != nil {
+			configs[config.Key] = *config.Value
+		}
+	}
+	return configs, nil
+}
+
+// kafkaDescribe returns the actual configuration of a topic
+func kafkaDescribe(ctx context.Context, admin *kadm.Client, topic string) (Config, error) {
+	detail, exists, err := kafkaTopic(ctx, admin, topic)
+	if err != nil {
+		return Config{}, err
+	}
+	if !exists {
+		return Config{}, fmt.Errorf("topic %s not found", topic)
+	}
+	configs, err := kafkaConfigs(ctx, admin, topic)
+	if err != nil {
+		return Config{}, err
+	}
+	config := Config{
+		Partitions: int32(len(detail.Partitions)),
+		Compaction: strings.Contains(configs[cleanupPolicyConfig], "compact"),
+	}
+	if partition, ok := detail.Partitions[0]; ok {
+		config.ReplicationFactor = int32(len(partition.Replicas))
+	}
+	if retention, err := strconv.ParseInt(configs[retentionConfig], 10, 64); err == nil && retention > 0 {
+		config.Retention = time.Duration(retention) * time.Millisecond
+	}
+	return config, nil
+}
+
+func sortedKeys(m map[string]*string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,147 @@
+package provision
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/streaming-runtime/controllers/probe/kafkatest"
+)
+
+// kafkaCluster starts a fake kafka cluster of two brokers, broker 1 is the controller
+func kafkaCluster(t *testing.T, opts ...kafkatest.Option) *kafkatest.Cluster {
+	t.Helper()
+	cluster, err := kafkatest.NewCluster(2, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func int32Ptr(v int32) *int32                    { return &v }
+func durationPtr(v time.Duration) *time.Duration { return &v }
+func boolPtr(v bool) *bool                       { return &v }
+
+func TestKafka(t *testing.T) {
+	cluster := kafkaCluster(t)
+	// the first broker is not the controller
+	properties := map[string]string{"brokers": strings.Split(cluster.Brokers(), ",")[0]}
+	cluster.SetTopic("existing", kafkatest.Topic{Partitions: 3, ReplicationFactor: 2, Configs: map[string]string{
+		retentionConfig: "-1", cleanupPolicyConfig: "delete", "segment.ms": "3600000",
+	}})
+
+	tests := []struct {
+		name    string
+		topic   Topic
+		want    Config
+		wantErr string
+	}{
+		{
+			name:  "create with broker defaults",
+			topic: Topic{Name: "defaults"},
+			want:  Config{Partitions: 1, ReplicationFactor: 2, Retention: 7 * 24 * time.Hour, Created: true},
+		},
+		{
+			name:  "create",
+			topic: Topic{Name: "orders", Partitions: int32Ptr(6), ReplicationFactor: int32Ptr(3), Retention: durationPtr(time.Hour), Compaction: boolPtr(true)},
+			want:  Config{Partitions: 6, ReplicationFactor: 3, Retention: time.Hour, Compaction: true, Created: true},
+		},
+		{
+			name:  "unchanged",
+			topic: Topic{Name: "existing", Partitions: int32Ptr(3)},
+			want:  Config{Partitions: 3, ReplicationFactor: 2},
+		},
+		{
+			name:  "update",
+			topic: Topic{Name: "existing", Partitions: int32Ptr(4), Retention: durationPtr(24 * time.Hour), Compaction: boolPtr(true)},
+			want:  Config{Partitions: 4, ReplicationFactor: 2, Retention: 24 * time.Hour, Compaction: true},
+		},
+		{
+			name:    "decrease partitions",
+			topic:   Topic{Name: "existing", Partitions: int32Ptr(2)},
+			want:    Config{Partitions: 4, ReplicationFactor: 2, Retention: 24 * time.Hour, Compaction: true},
+			wantErr: "can not be decreased from 4",
+		},
+		{
+			name:    "change replication factor",
+			topic:   Topic{Name: "existing", ReplicationFactor: int32Ptr(3)},
+			want:    Config{Partitions: 4, ReplicationFactor: 2, Retention: 24 * time.Hour, Compaction: true},
+			wantErr: "can not be changed from 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			got, err := Provision(ctx, "kafka", properties, nil, test.topic)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+	// the other configuration keys are unchanged
+	if existing, _ := cluster.Topic("existing"); existing.Configs["segment.ms"] != "3600000" {
+		t.Errorf("got segment.ms %s, want unchanged", existing.Configs["segment.ms"])
+	}
+}
+
+func TestDeleteKafka(t *testing.T) {
+	cluster := kafkaCluster(t)
+	properties := map[string]string{"brokers": cluster.Brokers()}
+	cluster.SetTopic("orders", kafkatest.Topic{Partitions: 1, ReplicationFactor: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := Delete(ctx, "kafka", properties, nil, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cluster.Topic("orders"); ok {
+		t.Fatal("topic orders not deleted")
+	}
+	// deleting a topic that does not exist succeeds
+	if err := Delete(ctx, "kafka", properties, nil, "orders"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaSASL(t *testing.T) {
+	cluster := kafkaCluster(t, kafkatest.WithSASLPlain("streaming", "secret"))
+	properties := map[string]string{
+		"brokers": cluster.Brokers(), "authType": "password", "saslMechanism": "PLAINTEXT", "saslUsername": "streaming", "saslPassword": "secret",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	got, err := Provision(ctx, "kafka", properties, nil, Topic{Name: "orders", Partitions: int32Ptr(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Config{Partitions: 3, ReplicationFactor: 2, Retention: 7 * 24 * time.Hour, Created: true}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if err := Delete(ctx, "kafka", properties, nil, "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	properties["saslPassword"] = "wrong"
+	if _, err := Provision(ctx, "kafka", properties, nil, Topic{Name: "orders"}); err == nil || !strings.Contains(err.Error(), "Invalid username or password") {
+		t.Fatalf("got error %v, want authentication error", err)
+	}
+}
+
+func TestProvisionUnsupported(t *testing.T) {
+	if _, err := Provision(context.Background(), "redis", nil, nil, Topic{Name: "orders"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := Delete(context.Background(), "redis", nil, nil, "orders"); err == nil {
+		t.Fatal("expected error")
+	}
+}
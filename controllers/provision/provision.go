@@ -0,0 +1,75 @@
+// Package provision creates, updates and deletes the topics of Streams, with the connection
+// properties of the pubsub component of their ClusterStream.
+package provision
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+)
+
+// Topic is the desired configuration of a topic, nil fields are not managed and use the
+// defaults of the brokers
+type Topic struct {
+	Name              string
+	Partitions        *int32
+	ReplicationFactor *int32
+	Retention         *time.Duration
+	Compaction        *bool
+}
+
+// Config is the actual configuration of a topic
+type Config struct {
+	Partitions        int32
+	ReplicationFactor int32
+	// Retention of the events, 0 when unlimited
+	Retention  time.Duration
+	Compaction bool
+	// Created is true when the topic did not exist and was created by the provisioning
+	Created bool
+}
+
+// Func creates the topic, or updates it to the desired configuration, with the
+// properties of a pubsub component. It returns the actual configuration of the topic,
+// also with an error when the topic exists but could not be updated.
+type Func func(ctx context.Context, properties map[string]string, tlsConfig *tls.Config, topic Topic) (Config, error)
+
+// DeleteFunc deletes a topic with the properties of a pubsub component, a topic that
+// does not exist is not an error
+type DeleteFunc func(ctx context.Context, properties map[string]string, tlsConfig *tls.Config, topic string) error
+
+// provisioners are the provisioning functions of each protocol
+var provisioners = map[string]Func{
+	"kafka": Kafka,
+}
+
+// deleters are the topic deletion functions of each protocol
+var deleters = map[string]DeleteFunc{
+	"kafka": DeleteKafka,
+}
+
+// Supported returns whether the topics of a protocol can be provisioned
+func Supported(protocol string) bool {
+	_, ok := provisioners[protocol]
+	return ok
+}
+
+// Provision provisions a topic of a protocol. tlsConfig, if not nil, encrypts the
+// connections.
+func Provision(ctx context.Context, protocol string, properties map[string]string, tlsConfig *tls.Config, topic Topic) (Config, error) {
+	provision, ok := provisioners[protocol]
+	if !ok {
+		return Config{}, fmt.Errorf("topic provisioning not supported by protocol %s", protocol)
+	}
+	return provision(ctx, properties, tlsConfig, topic)
+}
+
+// Delete deletes a topic of a protocol. tlsConfig, if not nil, encrypts the connections.
+func Delete(ctx context.Context, protocol string, properties map[string]string, tlsConfig *tls.Config, topic string) error {
+	del, ok := deleters[protocol]
+	if !ok {
+		return fmt.Errorf("topic deletion not supported by protocol %s", protocol)
+	}
+	return del(ctx, properties, tlsConfig, topic)
+}
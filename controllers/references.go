@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+const (
+	// fromStreamsIndex indexes components by the names of the Streams they consume
+	fromStreamsIndex = ".spec.from"
+	// clusterStreamIndex indexes Streams by the name of their ClusterStream
+	clusterStreamIndex = ".spec.clusterStream"
+	// outputClusterStreamsIndex indexes components by the ClusterStreams of the
+	// pubsub/topic streams they send to
+	outputClusterStreamsIndex = ".spec.to.clusterStream"
+	// credentialsIndex indexes ClusterStreams by the namespace/name of the Secrets
+	// they read credentials from
+	credentialsIndex = ".spec.credentials"
+
+	// templateHashAnnotation records the hash of the pod template of a component
+	// workload, rendered from the component and the objects it references. The
+	// workload is updated when the hash changes.
+	templateHashAnnotation = "streaming.vivien.io/template-hash"
+)
+
+// SetupFieldIndexes registers the field indexes used to find the objects
+// referencing a Stream or a ClusterStream
+func SetupFieldIndexes(ctx context.Context, indexer client.FieldIndexer) error {
+	if err := indexer.IndexField(ctx, &streamingruntime.Stream{}, clusterStreamIndex, func(obj client.Object) []string {
+		return []string{obj.(*streamingruntime.Stream).Spec.ClusterStream}
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &streamingruntime.ClusterStream{}, credentialsIndex, credentialsSecrets); err != nil {
+		return err
+	}
+	for _, obj := range []client.Object{&streamingruntime.Channel{}, &streamingruntime.Joiner{}, &streamingruntime.Processor{}} {
+		if err := indexer.IndexField(ctx, obj, outputClusterStreamsIndex, outputClusterStreams); err != nil {
+			return err
+		}
+	}
+	if err := indexer.IndexField(ctx, &streamingruntime.Channel{}, fromStreamsIndex, func(obj client.Object) []string {
+		return obj.(*streamingruntime.Channel).Spec.Stream.From
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &streamingruntime.Joiner{}, fromStreamsIndex, func(obj client.Object) []string {
+		if stream := obj.(*streamingruntime.Joiner).Spec.Stream; stream != nil {
+			return stream.From
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &streamingruntime.Processor{}, fromStreamsIndex, func(obj client.Object) []string {
+		return obj.(*streamingruntime.Processor).Spec.From
+	})
+}
+
+// outputClusterStreams returns the ClusterStreams of the pubsub/topic streams a
+// component sends to
+func outputClusterStreams(obj client.Object) []string {
+	var targets []string
+	switch obj := obj.(type) {
+	case *streamingruntime.Channel:
+		for _, target := range obj.Spec.Stream.To {
+			targets = append(targets, target.Stream)
+		}
+		for _, route := range obj.Spec.Routes {
+			for _, target := range route.To {
+				targets = append(targets, target.Stream)
+			}
+		}
+	case *streamingruntime.Joiner:
+		if obj.Spec.Stream != nil {
+			for _, target := range obj.Spec.Stream.To {
+				targets = append(targets, target.Stream)
+			}
+		}
+	case *streamingruntime.Processor:
+		if stream, _, err := processorTarget(obj.Spec.Target); err == nil {
+			targets = append(targets, stream)
+		}
+	}
+
+	var result []string
+	for _, target := range targets {
+		if target != "" {
+			result = append(result, strings.SplitN(target, "/", 2)[0])
+		}
+	}
+	return result
+}
+
+// requestsForStream returns a map function enqueuing the objects, listed with newList,
+// consuming a Stream
+func requestsForStream(c client.Client, newList func() client.ObjectList) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		return listReferencing(c, newList(), obj.GetNamespace(), fromStreamsIndex, obj.GetName())
+	}
+}
+
+// requestsForClusterStream returns a map function enqueuing the objects, listed with
+// newList, consuming the Streams of a ClusterStream
+func requestsForClusterStream(c client.Client, newList func() client.ObjectList) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		streams := new(streamingruntime.StreamList)
+		if err := c.List(context.Background(), streams, client.MatchingFields{clusterStreamIndex: obj.GetName()}); err != nil {
+			return nil
+		}
+		seen := make(map[types.NamespacedName]bool)
+		var requests []reconcile.Request
+		for _, stream := range streams.Items {
+			for _, req := range listReferencing(c, newList(), stream.Namespace, fromStreamsIndex, stream.Name) {
+				if !seen[req.NamespacedName] {
+					seen[req.NamespacedName] = true
+					requests = append(requests, req)
+				}
+			}
+		}
+		return requests
+	}
+}
+
+// listReferencing returns the requests of the objects of a namespace whose indexed field has the value
+func listReferencing(c client.Client, list client.ObjectList, namespace, field, value string) []reconcile.Request {
+	if err := c.List(context.Background(), list, client.InNamespace(namespace), client.MatchingFields{field: value}); err != nil {
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range items {
+		obj := item.(client.Object)
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}})
+	}
+	return requests
+}
+
+// resolveStreams returns the Streams of a namespace with the names, and the names not found
+func resolveStreams(ctx context.Context, c client.Reader, namespace string, names []string) ([]*streamingruntime.Stream, []string, error) {
+	var streams []*streamingruntime.Stream
+	var missing []string
+	for _, name := range names {
+		stream := new(streamingruntime.Stream)
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, stream)
+		switch {
+		case errors.IsNotFound(err):
+			missing = append(missing, fmt.Sprintf("stream %s", name))
+		case err != nil:
+			return nil, nil, err
+		default:
+			streams = append(streams, stream)
+		}
+	}
+	return streams, missing, nil
+}
+
+// resolveClusterStreams returns the ClusterStreams of the streams that are not found,
+// or that do not allow the namespace of the stream
+func resolveClusterStreams(ctx context.Context, c client.Reader, streams []*streamingruntime.Stream) ([]string, error) {
+	var missing []string
+	for _, stream := range streams {
+		cs := new(streamingruntime.ClusterStream)
+		err := c.Get(ctx, types.NamespacedName{Name: stream.Spec.ClusterStream}, cs)
+		switch {
+		case errors.IsNotFound(err):
+			missing = append(missing, fmt.Sprintf("clusterstream %s", stream.Spec.ClusterStream))
+			continue
+		case err != nil:
+			return nil, err
+		}
+		allowed, err := namespaceAllowed(ctx, c, cs, stream.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			missing = append(missing, fmt.Sprintf("clusterstream %s (namespace %s not allowed)", cs.Name, stream.Namespace))
+		}
+	}
+	return missing, nil
+}
+
+// namespaceAllowed returns whether a ClusterStream can be used in a namespace
+func namespaceAllowed(ctx context.Context, c client.Reader, cs *streamingruntime.ClusterStream, namespace string) (bool, error) {
+	if cs.Spec.AllowedNamespaces == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(cs.Spec.AllowedNamespaces)
+	if err != nil {
+		return false, fmt.Errorf("clusterstream %s: allowedNamespaces: %w", cs.Name, err)
+	}
+	ns := new(corev1.Namespace)
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// updateReferencesResolved sets the ReferencesResolved condition of obj, with status
+// false when references are missing, and updates the status of obj if the condition
+// changed. Objects with missing references are reconciled again when the references
+// are created, through the watches of the referenced kinds.
+func updateReferencesResolved(ctx context.Context, c client.Client, obj client.Object, conditions *[]metav1.Condition, missing []string) error {
+	condition := metav1.Condition{
+		Type:               streamingruntime.ConditionReferencesResolved,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             "Resolved",
+	}
+	if len(missing) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Unresolved"
+		condition.Message = fmt.Sprintf("unresolved references: %s", strings.Join(missing, ", "))
+	}
+	current := meta.FindStatusCondition(*conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	meta.SetStatusCondition(conditions, condition)
+	return c.Status().Update(ctx, obj)
+}
+
+// templateHash returns the hash of a pod template
+func templateHash(template corev1.PodTemplateSpec) (string, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// setTemplateHash annotates a workload with the hash of its pod template
+func setTemplateHash(workload *metav1.ObjectMeta, template corev1.PodTemplateSpec) error {
+	hash, err := templateHash(template)
+	if err != nil {
+		return err
+	}
+	if workload.Annotations == nil {
+		workload.Annotations = make(map[string]string)
+	}
+	workload.Annotations[templateHashAnnotation] = hash
+	return nil
+}
@@ -18,18 +18,38 @@ package controllers
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"strings"
+	"time"
 
 	daprsubscriptions "github.com/dapr/dapr/pkg/apis/subscriptions/v2alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/controllers/provision"
+)
+
+const (
+	// topicRetryInterval is the delay before provisioning a topic again after a failure
+	topicRetryInterval = 30 * time.Second
+	provisionTimeout   = 10 * time.Second
+
+	// topicFinalizer deletes the provisioned topic of a Stream whose reclaim policy is Delete
+	topicFinalizer = "streaming.vivien.io/topic-cleanup"
 )
 
 // StreamReconciler reconciles a Stream object
@@ -42,6 +62,9 @@ type StreamReconciler struct {
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=streaming.vivien.io,resources=streams/finalizers,verbs=update
 //+kubebuilder:rbac:groups=dapr.io,resources=subscriptions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=streaming.vivien.io,resources=clusterstreams,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *StreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
@@ -61,7 +84,33 @@ func (r *StreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	// is item being deleted?
 	if !stream.ObjectMeta.DeletionTimestamp.IsZero() {
-		return ctrl.Result{}, nil // do nothing, stop reconciliation
+		return r.finalizeStream(ctx, stream)
+	}
+
+	// the subscription is created when the clusterstream (its pubsub) is found
+	missing, err := resolveClusterStreams(ctx, r.Client, []*streamingruntime.Stream{stream})
+	if err != nil {
+		log.Error(err, "Failed to get ClusterStream for Stream", "Name", stream.Name, "Namespace", stream.Namespace)
+		return ctrl.Result{}, err
+	}
+	if err := updateReferencesResolved(ctx, r.Client, stream, &stream.Status.Conditions, missing); err != nil {
+		log.Error(err, "Failed to update Stream status", "Name", stream.Name, "Namespace", stream.Namespace)
+		return ctrl.Result{}, err
+	}
+	if len(missing) > 0 {
+		log.Info("Stream references not found", "Name", stream.Name, "Namespace", stream.Namespace, "Missing", missing)
+		return ctrl.Result{}, nil
+	}
+
+	// the topic is provisioned before it is subscribed
+	retry, err := r.provisionTopic(ctx, stream)
+	if err != nil {
+		log.Error(err, "Failed to update Stream status", "Name", stream.Name, "Namespace", stream.Namespace)
+		return ctrl.Result{}, err
+	}
+	if err := r.updateTopicFinalizer(ctx, stream); err != nil {
+		log.Error(err, "Failed to update Stream finalizer", "Name", stream.Name, "Namespace", stream.Namespace)
+		return ctrl.Result{}, err
 	}
 
 	// Look for dapr subscription object
@@ -109,14 +158,233 @@ func (r *StreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		"Name", sub.Name,
 		"Topic", sub.Spec.Topic)
 
+	return ctrl.Result{RequeueAfter: retry}, nil
+}
+
+// provisionTopic creates or updates the topic of a Stream, when the stream sets its
+// partitions, replication factor, retention or compaction, and reports the actual
+// configuration of the topic in the status. It returns the delay before provisioning
+// again when it failed.
+func (r *StreamReconciler) provisionTopic(ctx context.Context, stream *streamingruntime.Stream) (time.Duration, error) {
+	log := log.FromContext(ctx)
+	status := stream.Status.DeepCopy()
+	spec := stream.Spec
+	var retry time.Duration
+	if spec.Partitions == nil && spec.ReplicationFactor == nil && spec.Retention == nil && spec.Compaction == nil {
+		meta.RemoveStatusCondition(&stream.Status.Conditions, streamingruntime.StreamConditionTopicProvisioned)
+		stream.Status.Topic = nil
+	} else {
+		condition := metav1.Condition{
+			Type:               streamingruntime.StreamConditionTopicProvisioned,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: stream.Generation,
+			Reason:             "Provisioned",
+		}
+		config, err := r.provision(ctx, stream)
+		if err != nil {
+			log.Info("Failed to provision Stream topic", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic, "Error", err.Error())
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "ProvisioningFailed"
+			condition.Message = err.Error()
+			retry = topicRetryInterval
+		}
+		if config != nil {
+			// a topic created once stays created by the controller, later provisionings
+			// find it existing
+			stream.Status.Topic = &streamingruntime.TopicStatus{
+				Partitions:        config.Partitions,
+				ReplicationFactor: config.ReplicationFactor,
+				Compaction:        config.Compaction,
+				Created:           config.Created || status.Topic != nil && status.Topic.Created,
+			}
+			if config.Retention > 0 {
+				stream.Status.Topic.Retention = &metav1.Duration{Duration: config.Retention}
+			}
+		}
+		meta.SetStatusCondition(&stream.Status.Conditions, condition)
+	}
+	if equality.Semantic.DeepEqual(status, &stream.Status) {
+		return retry, nil
+	}
+	return retry, r.Status().Update(ctx, stream)
+}
+
+// provision provisions the topic of a Stream with the connection of its ClusterStream,
+// and returns its actual configuration if it exists
+func (r *StreamReconciler) provision(ctx context.Context, stream *streamingruntime.Stream) (*provision.Config, error) {
+	cs, properties, tlsConfig, err := r.connection(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	if !provision.Supported(cs.Spec.Protocol) {
+		return nil, fmt.Errorf("topic provisioning not supported by protocol %s", cs.Spec.Protocol)
+	}
+
+	topic := provision.Topic{
+		Name:              stream.Spec.Topic,
+		Partitions:        stream.Spec.Partitions,
+		ReplicationFactor: stream.Spec.ReplicationFactor,
+		Compaction:        stream.Spec.Compaction,
+	}
+	if stream.Spec.Retention != nil {
+		topic.Retention = &stream.Spec.Retention.Duration
+	}
+	provisionCtx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	config, err := provision.Provision(provisionCtx, cs.Spec.Protocol, properties, tlsConfig, topic)
+	if config == (provision.Config{}) {
+		return nil, err // the topic does not exist or could not be described
+	}
+	return &config, err
+}
+
+// updateTopicFinalizer adds the finalizer deleting the topic of a Stream when its
+// reclaim policy is Delete and its topic was created by the controller, and removes it
+// otherwise
+func (r *StreamReconciler) updateTopicFinalizer(ctx context.Context, stream *streamingruntime.Stream) error {
+	reclaim := stream.Spec.TopicReclaimPolicy == streamingruntime.TopicReclaimDelete && topicCreated(stream)
+	if reclaim == controllerutil.ContainsFinalizer(stream, topicFinalizer) {
+		return nil
+	}
+	if reclaim {
+		controllerutil.AddFinalizer(stream, topicFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(stream, topicFinalizer)
+	}
+	return r.Update(ctx, stream)
+}
+
+// finalizeStream deletes the topic of a deleted Stream, then removes its finalizer. The
+// deletion is retried until it succeeds, unless the ClusterStream is not found: the
+// topic is then retained. The topic is also retained while another Stream uses it, and
+// when it existed before the Stream was provisioned.
+func (r *StreamReconciler) finalizeStream(ctx context.Context, stream *streamingruntime.Stream) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(stream, topicFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	other, err := r.topicStream(ctx, stream)
+	if err != nil {
+		log.Error(err, "Failed to list Streams of ClusterStream", "Name", stream.Name, "Namespace", stream.Namespace, "ClusterStream", stream.Spec.ClusterStream)
+		return ctrl.Result{}, err
+	}
+	created := topicCreated(stream)
+	if other == nil && created {
+		err = r.deleteTopic(ctx, stream)
+	}
+	switch {
+	case !created:
+		log.Info("Stream topic not created by the controller, retaining it", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic)
+	case other != nil:
+		log.Info("Stream topic used by another Stream, retaining it", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic,
+			"Stream", other.Namespace+"/"+other.Name)
+	case errors.IsNotFound(err):
+		log.Info("ClusterStream not found, retaining Stream topic", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic)
+	case err != nil:
+		log.Info("Failed to delete Stream topic", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic, "Error", err.Error())
+		status := stream.Status.DeepCopy()
+		meta.SetStatusCondition(&stream.Status.Conditions, metav1.Condition{
+			Type:               streamingruntime.StreamConditionTopicProvisioned,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: stream.Generation,
+			Reason:             "DeletionFailed",
+			Message:            err.Error(),
+		})
+		if equality.Semantic.DeepEqual(status, &stream.Status) {
+			return ctrl.Result{RequeueAfter: topicRetryInterval}, nil
+		}
+		return ctrl.Result{RequeueAfter: topicRetryInterval}, r.Status().Update(ctx, stream)
+	default:
+		log.Info("Deleted Stream topic", "Name", stream.Name, "Namespace", stream.Namespace, "Topic", stream.Spec.Topic)
+	}
+
+	controllerutil.RemoveFinalizer(stream, topicFinalizer)
+	if err := r.Update(ctx, stream); err != nil {
+		log.Error(err, "Failed to remove Stream finalizer", "Name", stream.Name, "Namespace", stream.Namespace)
+		return ctrl.Result{}, err
+	}
 	return ctrl.Result{}, nil
 }
 
+// topicCreated returns whether the topic of a Stream was created by the controller
+func topicCreated(stream *streamingruntime.Stream) bool {
+	return stream.Status.Topic != nil && stream.Status.Topic.Created
+}
+
+// topicStream returns another Stream of the ClusterStream of a Stream, in any namespace and
+// not being deleted, using the same topic, nil if there is none
+func (r *StreamReconciler) topicStream(ctx context.Context, stream *streamingruntime.Stream) (*streamingruntime.Stream, error) {
+	streams := new(streamingruntime.StreamList)
+	if err := r.List(ctx, streams, client.MatchingFields{clusterStreamIndex: stream.Spec.ClusterStream}); err != nil {
+		return nil, err
+	}
+	for i := range streams.Items {
+		other := &streams.Items[i]
+		if other.UID == stream.UID || !other.DeletionTimestamp.IsZero() || other.Spec.Topic != stream.Spec.Topic {
+			continue
+		}
+		return other, nil
+	}
+	return nil, nil
+}
+
+// deleteTopic deletes the topic of a Stream with the connection of its ClusterStream
+func (r *StreamReconciler) deleteTopic(ctx context.Context, stream *streamingruntime.Stream) error {
+	cs, properties, tlsConfig, err := r.connection(ctx, stream)
+	if err != nil {
+		return err
+	}
+	deleteCtx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	return provision.Delete(deleteCtx, cs.Spec.Protocol, properties, tlsConfig, stream.Spec.Topic)
+}
+
+// connection returns the ClusterStream of a Stream, with the properties and the TLS
+// configuration connecting to its brokers. The error of a ClusterStream not found is
+// returned as is.
+func (r *StreamReconciler) connection(ctx context.Context, stream *streamingruntime.Stream) (*streamingruntime.ClusterStream, map[string]string, *tls.Config, error) {
+	cs := new(streamingruntime.ClusterStream)
+	if err := r.Get(ctx, types.NamespacedName{Name: stream.Spec.ClusterStream}, cs); err != nil {
+		return nil, nil, nil, err
+	}
+	secrets, missing, err := readClusterStreamSecrets(ctx, r.Client, cs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(missing) > 0 {
+		return nil, nil, nil, fmt.Errorf("clusterstream %s: unresolved secrets: %s", cs.Name, strings.Join(missing, ", "))
+	}
+	if err := validateClusterStream(cs, secrets); err != nil {
+		return nil, nil, nil, fmt.Errorf("clusterstream %s: %w", cs.Name, err)
+	}
+	tlsConfig, err := clusterStreamTLSConfig(cs, secrets)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("clusterstream %s: %w", cs.Name, err)
+	}
+	return cs, connectionProperties(cs, secrets), tlsConfig, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *StreamReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&streamingruntime.Stream{}).
 		Owns(&daprsubscriptions.Subscription{}).
+		Watches(&source.Kind{Type: &streamingruntime.ClusterStream{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			return listReferencing(r.Client, new(streamingruntime.StreamList), metav1.NamespaceAll, clusterStreamIndex, obj.GetName())
+		})).
+		// namespace labels select where clusterstreams are allowed
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			streams := new(streamingruntime.StreamList)
+			if err := r.List(context.Background(), streams, client.InNamespace(obj.GetName())); err != nil {
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, stream := range streams.Items {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: stream.Namespace, Name: stream.Name}})
+			}
+			return requests
+		})).
 		Complete(r)
 }
 
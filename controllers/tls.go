@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+// tlsProtocol is the TLS metadata of a Dapr pubsub component
+type tlsProtocol struct {
+	// metadata names of the CA bundle, client certificate and key, and certificate
+	// verification, empty when not supported
+	ca, cert, key, skipVerify string
+	// metadata enabling TLS
+	enable map[string]string
+	// property holding the broker URL, whose scheme must be scheme when TLS is enabled
+	url, scheme string
+}
+
+// tlsProtocols are the protocols supporting TLS
+var tlsProtocols = map[string]tlsProtocol{
+	"kafka": {
+		ca: "caCert", cert: "clientCert", key: "clientKey", skipVerify: "skipVerify",
+		enable: map[string]string{"disableTls": "false"},
+	},
+	"redis": {
+		enable: map[string]string{"enableTLS": "true"},
+	},
+	"rabbitmq": {
+		ca: "caCert", cert: "clientCert", key: "clientKey",
+		url: "host", scheme: "amqps://",
+	},
+	// NATS JetStream enables TLS from the scheme of the server URL
+	"jetstream": {
+		url: "natsURL", scheme: "tls://",
+	},
+}
+
+// clusterStreamTLS returns the metadata enabling the TLS configuration of a ClusterStream,
+// and the secret properties of its certificates, by metadata name
+func clusterStreamTLS(cs *streamingruntime.ClusterStream) (map[string]string, map[string]streamingruntime.SecretKeyReference, error) {
+	config := cs.Spec.TLS
+	if config == nil {
+		return nil, nil, nil
+	}
+	protocol, ok := tlsProtocols[cs.Spec.Protocol]
+	if !ok {
+		return nil, nil, fmt.Errorf("tls: not supported by protocol %s", cs.Spec.Protocol)
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range protocol.enable {
+		metadata[k] = v
+	}
+	if config.InsecureSkipVerify {
+		if protocol.skipVerify == "" {
+			return nil, nil, fmt.Errorf("tls: insecureSkipVerify not supported by protocol %s", cs.Spec.Protocol)
+		}
+		metadata[protocol.skipVerify] = "true"
+	}
+
+	secrets := make(map[string]streamingruntime.SecretKeyReference)
+	if ref := config.CASecretRef; ref != nil {
+		if protocol.ca == "" {
+			return nil, nil, fmt.Errorf("tls: caSecretRef not supported by protocol %s", cs.Spec.Protocol)
+		}
+		secrets[protocol.ca] = *ref
+	}
+	if ref := config.ClientCertSecretRef; ref != nil {
+		if protocol.cert == "" {
+			return nil, nil, fmt.Errorf("tls: clientCertSecretRef not supported by protocol %s", cs.Spec.Protocol)
+		}
+		secrets[protocol.cert] = streamingruntime.SecretKeyReference{Namespace: ref.Namespace, Name: ref.Name, Key: corev1.TLSCertKey}
+		secrets[protocol.key] = streamingruntime.SecretKeyReference{Namespace: ref.Namespace, Name: ref.Name, Key: corev1.TLSPrivateKeyKey}
+	}
+	return metadata, secrets, nil
+}
+
+// clusterStreamTLSConfig returns the TLS configuration of the connections to the brokers
+// of a ClusterStream, nil without TLS. secrets are the values of the secret properties,
+// including the certificates.
+func clusterStreamTLSConfig(cs *streamingruntime.ClusterStream, secrets map[string][]byte) (*tls.Config, error) {
+	if cs.Spec.TLS == nil {
+		return nil, nil
+	}
+	protocol := tlsProtocols[cs.Spec.Protocol]
+	config := &tls.Config{InsecureSkipVerify: cs.Spec.TLS.InsecureSkipVerify}
+	if ca, ok := secrets[protocol.ca]; ok && protocol.ca != "" {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("tls: invalid CA bundle")
+		}
+	}
+	if cert, ok := secrets[protocol.cert]; ok && protocol.cert != "" {
+		certificate, err := tls.X509KeyPair(cert, secrets[protocol.key])
+		if err != nil {
+			return nil, fmt.Errorf("tls: client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// validateTLS returns an error if the TLS configuration of a ClusterStream is not
+// supported by its protocol, sets properties also set by the stream, or if the broker
+// URL does not use TLS. A URL read from a Secret is not checked.
+func validateTLS(cs *streamingruntime.ClusterStream) error {
+	metadata, secrets, err := clusterStreamTLS(cs)
+	if err != nil || cs.Spec.TLS == nil {
+		return err
+	}
+	for name := range secrets {
+		if _, ok := cs.Spec.Properties[name]; ok {
+			return fmt.Errorf("tls: property %s is set by the tls configuration", name)
+		}
+		if _, ok := cs.Spec.SecretProperties[name]; ok {
+			return fmt.Errorf("tls: secret property %s is set by the tls configuration", name)
+		}
+	}
+	for name := range metadata {
+		if _, ok := cs.Spec.SecretProperties[name]; ok {
+			return fmt.Errorf("tls: secret property %s is set by the tls configuration", name)
+		}
+	}
+	protocol := tlsProtocols[cs.Spec.Protocol]
+	if url, ok := cs.Spec.Properties[protocol.url]; ok && protocol.url != "" && !strings.HasPrefix(url, protocol.scheme) {
+		return fmt.Errorf("tls: property %s must use the %s scheme", protocol.url, protocol.scheme)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+)
+
+func TestClusterStreamTLS(t *testing.T) {
+	ca := &streamingruntime.SecretKeyReference{Namespace: "ns", Name: "ca", Key: "ca.crt"}
+	client := &corev1.SecretReference{Namespace: "ns", Name: "client"}
+	tests := []struct {
+		name         string
+		protocol     string
+		tls          *streamingruntime.ClusterStreamTLS
+		wantMetadata map[string]string
+		wantSecrets  map[string]streamingruntime.SecretKeyReference
+		wantErr      string
+	}{
+		{name: "no tls", protocol: "kafka"},
+		{
+			name:         "kafka",
+			protocol:     "kafka",
+			tls:          &streamingruntime.ClusterStreamTLS{CASecretRef: ca, ClientCertSecretRef: client, InsecureSkipVerify: true},
+			wantMetadata: map[string]string{"disableTls": "false", "skipVerify": "true"},
+			wantSecrets: map[string]streamingruntime.SecretKeyReference{
+				"caCert":     *ca,
+				"clientCert": {Namespace: "ns", Name: "client", Key: corev1.TLSCertKey},
+				"clientKey":  {Namespace: "ns", Name: "client", Key: corev1.TLSPrivateKeyKey},
+			},
+		},
+		{
+			name:         "rabbitmq",
+			protocol:     "rabbitmq",
+			tls:          &streamingruntime.ClusterStreamTLS{CASecretRef: ca},
+			wantMetadata: map[string]string{},
+			wantSecrets:  map[string]streamingruntime.SecretKeyReference{"caCert": *ca},
+		},
+		{
+			name:         "redis",
+			protocol:     "redis",
+			tls:          &streamingruntime.ClusterStreamTLS{},
+			wantMetadata: map[string]string{"enableTLS": "true"},
+			wantSecrets:  map[string]streamingruntime.SecretKeyReference{},
+		},
+		{name: "unsupported protocol", protocol: "mqtt", tls: &streamingruntime.ClusterStreamTLS{}, wantErr: "tls: not supported by protocol mqtt"},
+		{
+			name: "unsupported skip verify", protocol: "rabbitmq", tls: &streamingruntime.ClusterStreamTLS{InsecureSkipVerify: true},
+			wantErr: "tls: insecureSkipVerify not supported by protocol rabbitmq",
+		},
+		{
+			name: "unsupported CA", protocol: "redis", tls: &streamingruntime.ClusterStreamTLS{CASecretRef: ca},
+			wantErr: "tls: caSecretRef not supported by protocol redis",
+		},
+		{
+			name: "unsupported client certificate", protocol: "jetstream", tls: &streamingruntime.ClusterStreamTLS{ClientCertSecretRef: client},
+			wantErr: "tls: clientCertSecretRef not supported by protocol jetstream",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := &streamingruntime.ClusterStream{Spec: streamingruntime.ClusterStreamSpec{Protocol: test.protocol, TLS: test.tls}}
+			metadata, secrets, err := clusterStreamTLS(cs)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(metadata, test.wantMetadata) {
+				t.Errorf("got metadata %v, want %v", metadata, test.wantMetadata)
+			}
+			if !reflect.DeepEqual(secrets, test.wantSecrets) {
+				t.Errorf("got secrets %v, want %v", secrets, test.wantSecrets)
+			}
+		})
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	ca := &streamingruntime.SecretKeyReference{Namespace: "ns", Name: "ca", Key: "ca.crt"}
+	tests := []struct {
+		name    string
+		spec    streamingruntime.ClusterStreamSpec
+		wantErr string
+	}{
+		{name: "no tls", spec: streamingruntime.ClusterStreamSpec{Protocol: "rabbitmq", Properties: map[string]string{"host": "amqp://rabbitmq:5672"}}},
+		{
+			name: "TLS scheme",
+			spec: streamingruntime.ClusterStreamSpec{Protocol: "rabbitmq", TLS: &streamingruntime.ClusterStreamTLS{}, Properties: map[string]string{"host": "amqps://rabbitmq:5671"}},
+		},
+		{
+			name:    "plain scheme",
+			spec:    streamingruntime.ClusterStreamSpec{Protocol: "rabbitmq", TLS: &streamingruntime.ClusterStreamTLS{}, Properties: map[string]string{"host": "amqp://rabbitmq:5672"}},
+			wantErr: "tls: property host must use the amqps:// scheme",
+		},
+		{
+			name: "URL read from a secret",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol:         "jetstream",
+				TLS:              &streamingruntime.ClusterStreamTLS{},
+				SecretProperties: map[string]streamingruntime.SecretKeyReference{"natsURL": {Namespace: "ns", Name: "nats", Key: "url"}},
+			},
+		},
+		{
+			name:    "certificate set as a property",
+			spec:    streamingruntime.ClusterStreamSpec{Protocol: "kafka", TLS: &streamingruntime.ClusterStreamTLS{CASecretRef: ca}, Properties: map[string]string{"caCert": "..."}},
+			wantErr: "tls: property caCert is set by the tls configuration",
+		},
+		{
+			name: "metadata set as a secret property",
+			spec: streamingruntime.ClusterStreamSpec{
+				Protocol:         "kafka",
+				TLS:              &streamingruntime.ClusterStreamTLS{},
+				SecretProperties: map[string]streamingruntime.SecretKeyReference{"disableTls": {Namespace: "ns", Name: "kafka", Key: "tls"}},
+			},
+			wantErr: "tls: secret property disableTls is set by the tls configuration",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateTLS(&streamingruntime.ClusterStream{Spec: test.spec})
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestClusterStreamTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	der, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	tests := []struct {
+		name      string
+		tls       *streamingruntime.ClusterStreamTLS
+		secrets   map[string][]byte
+		wantNil   bool
+		wantRoots bool
+		wantCerts int
+		wantErr   string
+	}{
+		{name: "no tls", wantNil: true},
+		{name: "system roots", tls: &streamingruntime.ClusterStreamTLS{}},
+		{name: "CA bundle", tls: &streamingruntime.ClusterStreamTLS{}, secrets: map[string][]byte{"caCert": certificate}, wantRoots: true},
+		{name: "client certificate", tls: &streamingruntime.ClusterStreamTLS{}, secrets: map[string][]byte{"clientCert": certificate, "clientKey": key}, wantCerts: 1},
+		{name: "invalid CA bundle", tls: &streamingruntime.ClusterStreamTLS{}, secrets: map[string][]byte{"caCert": []byte("invalid")}, wantErr: "tls: invalid CA bundle"},
+		{name: "client certificate without key", tls: &streamingruntime.ClusterStreamTLS{}, secrets: map[string][]byte{"clientCert": certificate}, wantErr: "tls: client certificate"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := &streamingruntime.ClusterStream{Spec: streamingruntime.ClusterStreamSpec{Protocol: "kafka", TLS: test.tls}}
+			config, err := clusterStreamTLSConfig(cs, test.secrets)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantNil {
+				if config != nil {
+					t.Errorf("got config %v, want nil", config)
+				}
+				return
+			}
+			if got := config.RootCAs != nil; got != test.wantRoots {
+				t.Errorf("got custom roots %t, want %t", got, test.wantRoots)
+			}
+			if len(config.Certificates) != test.wantCerts {
+				t.Errorf("got %d client certificates, want %d", len(config.Certificates), test.wantCerts)
+			}
+		})
+	}
+}
@@ -2,52 +2,19 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"net/http"
-	"os"
 
-	"github.com/dapr/go-sdk/service/common"
-	daprd "github.com/dapr/go-sdk/service/http"
-)
-
-var (
-	servicePort = os.Getenv("PROC_SERVICE_PORT")
-	serviceRoute = os.Getenv("PROC_SERVICE_ROUTE")
+	"github.com/vladimirvivien/streaming-runtime/components/processor"
 )
 
 func main() {
-	if servicePort == "" {
-		servicePort = ":8080"
-	}
-	if serviceRoute == "" {
-		serviceRoute = os.Getenv("APP_ID")
-	}
-
-	svc := daprd.NewService(servicePort)
-
-
-	// exposes endpoint to receive message events
-	if err := svc.AddServiceInvocationHandler(serviceRoute, messageHandler); err != nil {
-		log.Fatalf("Failed to add orders handler: %s", err)
-	}
-
-	log.Println("Starting service app, port 8080")
-
-	// call Service.Start to start listening to incoming requests
-	if err := svc.Start(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("error listenning: %v", err)
+	// the processor SDK serves PROC_SERVICE_ROUTE and forwards results to PROC_TARGET (if set)
+	if err := processor.Run(messageHandler); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func messageHandler(ctx context.Context, in *common.InvocationEvent) (out *common.Content, err error) {
-	if in == nil {
-		return nil, fmt.Errorf("invocation parameter required")
-	}
-	log.Printf("Stream received: %s", string(in.Data))
-	return &common.Content{
-		Data:        in.Data,
-		ContentType: in.ContentType,
-		DataTypeURL: in.DataTypeURL,
-	}, nil
-}
\ No newline at end of file
+func messageHandler(_ context.Context, e processor.Event) ([]processor.Event, error) {
+	log.Printf("Stream received: %s", string(e.Data))
+	return []processor.Event{{Data: e.Data, ContentType: e.ContentType}}, nil
+}
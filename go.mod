@@ -6,9 +6,21 @@ require (
 	github.com/dapr/dapr v1.6.0
 	github.com/dapr/go-sdk v1.3.1
 	github.com/google/cel-go v0.9.0
+	github.com/google/uuid v1.3.0
+	github.com/hashicorp/golang-lru v0.5.4
+	github.com/linkedin/goavro/v2 v2.9.8
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.15.0
+	github.com/prometheus/client_golang v1.11.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.0.0
+	github.com/spaolacci/murmur3 v1.1.0
+	github.com/twmb/franz-go v1.10.0
+	github.com/twmb/franz-go/pkg/kadm v1.4.0
+	github.com/twmb/franz-go/pkg/kmsg v1.2.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/oauth2 v0.0.0-20210628180205-a41e5a781914
 	google.golang.org/genproto v0.0.0-20210831024726-fe130286e0e2
+	google.golang.org/grpc v1.40.0
 	google.golang.org/protobuf v1.27.1
 	k8s.io/api v0.22.1
 	k8s.io/apiextensions-apiserver v0.22.1
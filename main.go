@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 
@@ -31,11 +32,13 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	streamingv1alpha1 "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
 	"github.com/vladimirvivien/streaming-runtime/controllers"
+	"github.com/vladimirvivien/streaming-runtime/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -77,12 +80,19 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "873658fb.vivienv.io",
+		// only the Secrets of ClusterStreams are cached
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: controllers.CacheSelectors()}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if err := controllers.SetupFieldIndexes(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		setupLog.Error(err, "unable to set up field indexes")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.ClusterStreamReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
@@ -127,6 +137,17 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "Table")
 		os.Exit(1)
 	}
+	if err = (&controllers.PipelineReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Pipeline")
+		os.Exit(1)
+	}
+	// admission webhooks require serving certificates (see config/webhook)
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		webhooks.SetupWithManager(mgr)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
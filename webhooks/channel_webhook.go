@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+//+kubebuilder:webhook:path=/validate-streaming-vivien-io-v1alpha1-channel,mutating=false,failurePolicy=fail,sideEffects=None,groups=streaming.vivien.io,resources=channels,verbs=create;update,versions=v1alpha1,name=vchannel.streaming.vivien.io,admissionReviewVersions=v1
+
+// ChannelValidator type-checks the expressions of a Channel against the
+// schema of its source stream (if declared)
+type ChannelValidator struct {
+	Client  client.Reader
+	decoder *admission.Decoder
+}
+
+func (v *ChannelValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	channel := new(streamingruntime.Channel)
+	if err := v.decoder.Decode(req, channel); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if len(channel.Spec.Stream.From) == 0 {
+		return admission.Denied("channel stream.from must have a stream specified")
+	}
+
+	// source may be a Stream or a service route
+	varName := channel.Spec.Stream.From[0]
+	stream, err := getStream(ctx, v.Client, channel.Namespace, varName)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	variable, err := streamVar(ctx, v.Client, stream, varName)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	if dedup := channel.Spec.Dedup; dedup != nil {
+		if dedup.TTL != "" {
+			if _, err := time.ParseDuration(dedup.TTL); err != nil {
+				return admission.Denied(fmt.Sprintf("dedup.ttl: %s", err))
+			}
+		}
+		if dedup.ID != "" {
+			if err := support.CheckKeyExpr(dedup.ID, channel.Spec.Stream.LibVersion, variable); err != nil {
+				return admission.Denied(fmt.Sprintf("dedup.id: %s", err))
+			}
+		}
+	}
+	vars := []support.StreamVar{variable}
+	if channel.Spec.State != nil {
+		if err := checkStateExpressions(channel.Spec.State, channel.Spec.Stream.LibVersion, variable); err != nil {
+			return admission.Denied(err.Error())
+		}
+		vars = append(vars, support.StreamVar{Name: support.StateVarName})
+	}
+	if err := checkExpressions(&channel.Spec.Stream, vars...); err != nil {
+		return admission.Denied(err.Error())
+	}
+	for i, route := range channel.Spec.Routes {
+		if route.When != "" {
+			if err := support.CheckWhereExpr(route.When, channel.Spec.Stream.LibVersion, vars...); err != nil {
+				return admission.Denied(fmt.Sprintf("routes[%d].when: %s", i, err))
+			}
+		}
+		if route.Select != "" {
+			if err := support.CheckSelectExpr(route.Select, channel.Spec.Stream.LibVersion, vars...); err != nil {
+				return admission.Denied(fmt.Sprintf("routes[%d].select: %s", i, err))
+			}
+		}
+	}
+	return admission.Allowed("")
+}
+
+// checkStateExpressions type-checks the expressions of the keyed state of a Channel
+func checkStateExpressions(state *streamingruntime.ChannelState, libVersion int, variable support.StreamVar) error {
+	if err := support.CheckKeyExpr(state.Key, libVersion, variable); err != nil {
+		return fmt.Errorf("state.key: %s", err)
+	}
+	if state.Initial != "" {
+		if err := support.CheckMapExpr(state.Initial, libVersion, variable); err != nil {
+			return fmt.Errorf("state.initial: %s", err)
+		}
+	}
+	if err := support.CheckMapExpr(state.Update, libVersion, variable, support.StreamVar{Name: support.StateVarName}); err != nil {
+		return fmt.Errorf("state.update: %s", err)
+	}
+	return nil
+}
+
+// InjectDecoder injects the decoder
+func (v *ChannelValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
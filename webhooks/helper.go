@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// SetupWithManager registers the validating admission webhooks with the manager's webhook server
+func SetupWithManager(mgr ctrl.Manager) {
+	server := mgr.GetWebhookServer()
+	server.Register("/validate-streaming-vivien-io-v1alpha1-stream", &webhook.Admission{Handler: &StreamValidator{Client: mgr.GetClient()}})
+	server.Register("/validate-streaming-vivien-io-v1alpha1-channel", &webhook.Admission{Handler: &ChannelValidator{Client: mgr.GetClient()}})
+	server.Register("/validate-streaming-vivien-io-v1alpha1-joiner", &webhook.Admission{Handler: &JoinerValidator{Client: mgr.GetClient()}})
+}
+
+// loadSchema returns the content of a schema source. Schemas stored as files,
+// in the component container, cannot be loaded and nil is returned.
+func loadSchema(ctx context.Context, c client.Reader, namespace string, source streamingruntime.SchemaSource) ([]byte, error) {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		cm := new(corev1.ConfigMap)
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: source.ConfigMapKeyRef.Name}, cm); err != nil {
+			return nil, fmt.Errorf("schema configmap %s: %s", source.ConfigMapKeyRef.Name, err)
+		}
+		if data, ok := cm.Data[source.ConfigMapKeyRef.Key]; ok {
+			return []byte(data), nil
+		}
+		if data, ok := cm.BinaryData[source.ConfigMapKeyRef.Key]; ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("schema configmap %s: key %s not found", source.ConfigMapKeyRef.Name, source.ConfigMapKeyRef.Key)
+	case source.Inline != "":
+		return []byte(source.Inline), nil
+	}
+	return nil, nil
+}
+
+// streamVar returns the CEL variable, named varName, for the stream. If the stream
+// declares a schema (or a protobuf codec), the variable is typed accordingly,
+// otherwise it is a map(string, dyn).
+func streamVar(ctx context.Context, c client.Reader, stream *streamingruntime.Stream, varName string) (support.StreamVar, error) {
+	if stream == nil {
+		return support.StreamVar{Name: varName}, nil
+	}
+	if stream.Spec.Schema != nil {
+		schema, err := loadSchema(ctx, c, stream.Namespace, stream.Spec.Schema.SchemaSource)
+		if err != nil || schema == nil {
+			return support.StreamVar{Name: varName}, err
+		}
+		msgType, err := support.SchemaMessageType(varName, stream.Spec.Schema.Type, schema)
+		if err != nil {
+			return support.StreamVar{}, fmt.Errorf("stream %s: %s", stream.Name, err)
+		}
+		return support.StreamVar{Name: varName, Type: msgType}, nil
+	}
+	if stream.Spec.Codec != nil && stream.Spec.Codec.Schema != nil {
+		schema, err := loadSchema(ctx, c, stream.Namespace, *stream.Spec.Codec.Schema)
+		if err != nil || schema == nil {
+			return support.StreamVar{Name: varName}, err
+		}
+		codec, err := support.NewCodec(support.CodecConfig{
+			ContentType: stream.Spec.Codec.ContentType,
+			Schema:      schema,
+			MessageType: stream.Spec.Codec.MessageType,
+		})
+		if err != nil {
+			return support.StreamVar{}, fmt.Errorf("stream %s: %s", stream.Name, err)
+		}
+		if typed, ok := codec.(support.TypedCodec); ok {
+			return support.StreamVar{Name: varName, Type: typed.MessageType()}, nil
+		}
+	}
+	return support.StreamVar{Name: varName}, nil
+}
+
+// getStream returns the named stream or nil if it does not exist
+func getStream(ctx context.Context, c client.Reader, namespace, name string) (*streamingruntime.Stream, error) {
+	stream := new(streamingruntime.Stream)
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, stream); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+// checkExpressions type-checks the where and select expressions of a StreamSetup
+func checkExpressions(setup *streamingruntime.StreamSetup, vars ...support.StreamVar) error {
+	if setup.Where != "" {
+		if err := support.CheckWhereExpr(setup.Where, setup.LibVersion, vars...); err != nil {
+			return fmt.Errorf("stream.where: %s", err)
+		}
+	}
+	if setup.Select != "" {
+		if err := support.CheckSelectExpr(setup.Select, setup.LibVersion, vars...); err != nil {
+			return fmt.Errorf("stream.select: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+//+kubebuilder:webhook:path=/validate-streaming-vivien-io-v1alpha1-joiner,mutating=false,failurePolicy=fail,sideEffects=None,groups=streaming.vivien.io,resources=joiners,verbs=create;update,versions=v1alpha1,name=vjoiner.streaming.vivien.io,admissionReviewVersions=v1
+
+// JoinerValidator type-checks the expressions of a Joiner against the
+// schemas of its source streams (if declared)
+type JoinerValidator struct {
+	Client  client.Reader
+	decoder *admission.Decoder
+}
+
+func (v *JoinerValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	joiner := new(streamingruntime.Joiner)
+	if err := v.decoder.Decode(req, joiner); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if joiner.Spec.Stream == nil || len(joiner.Spec.Stream.From) != 2 {
+		return admission.Denied("joiner stream.from must have 2 input streams")
+	}
+
+	// joined streams are referenced, in expressions, using their topic names
+	var vars []support.StreamVar
+	for _, name := range joiner.Spec.Stream.From {
+		stream, err := getStream(ctx, v.Client, joiner.Namespace, name)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if stream == nil {
+			return admission.Denied(fmt.Sprintf("joiner stream %s not found", name))
+		}
+		variable, err := streamVar(ctx, v.Client, stream, stream.Spec.Topic)
+		if err != nil {
+			return admission.Denied(err.Error())
+		}
+		vars = append(vars, variable)
+	}
+	if err := checkExpressions(joiner.Spec.Stream, vars...); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the decoder
+func (v *JoinerValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
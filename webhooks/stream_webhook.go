@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	streamingruntime "github.com/vladimirvivien/streaming-runtime/api/v1alpha1"
+	"github.com/vladimirvivien/streaming-runtime/components/support"
+)
+
+//+kubebuilder:webhook:path=/validate-streaming-vivien-io-v1alpha1-stream,mutating=false,failurePolicy=fail,sideEffects=None,groups=streaming.vivien.io,resources=streams,verbs=create;update,versions=v1alpha1,name=vstream.streaming.vivien.io,admissionReviewVersions=v1
+
+// StreamValidator validates the schema declared by a Stream
+type StreamValidator struct {
+	Client  client.Reader
+	decoder *admission.Decoder
+}
+
+func (v *StreamValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	stream := new(streamingruntime.Stream)
+	if err := v.decoder.Decode(req, stream); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if stream.Spec.Schema == nil {
+		return admission.Allowed("")
+	}
+
+	schema, err := loadSchema(ctx, v.Client, stream.Namespace, stream.Spec.Schema.SchemaSource)
+	if err != nil {
+		return admission.Denied(fmt.Sprintf("stream schema: %s", err))
+	}
+	if schema == nil {
+		return admission.Allowed("stream schema file not validated")
+	}
+	if _, err := support.NewSchemaValidator(stream.Spec.Schema.Type, schema); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if _, err := support.SchemaMessageType(stream.Name, stream.Spec.Schema.Type, schema); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the decoder
+func (v *StreamValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}